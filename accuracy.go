@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// accuracyAlpha is how much one request's deviation moves a provider's
+	// running deviation, the rest being its history.
+	accuracyAlpha = 0.2
+
+	// initialDeviation, in °C, is what a provider not yet compared with the
+	// consensus is taken to deviate by.
+	initialDeviation = 1.0
+
+	// deviationFloor, in °C, keeps a provider that has agreed exactly from
+	// drowning out every other one.
+	deviationFloor = 0.1
+)
+
+// accuracy tracks how far each provider's recent readings were from the
+// consensus, for adaptiveAggregator.
+var accuracy = newAccuracyTracker()
+
+type accuracyTracker struct {
+	mu        sync.Mutex
+	deviation map[string]float64 // exponentially weighted mean absolute deviation, °C
+}
+
+func newAccuracyTracker() *accuracyTracker {
+	return &accuracyTracker{deviation: make(map[string]float64)}
+}
+
+// weight is how much the provider's reading counts, the inverse of its running
+// deviation, so a provider that keeps agreeing gains weight.
+func (t *accuracyTracker) weight(provider string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.deviation[provider]
+	if !ok {
+		d = initialDeviation
+	}
+	return 1 / math.Max(d, deviationFloor)
+}
+
+// observe folds each fresh reading's distance from consensus into its
+// provider's running deviation. Cached readings were counted when fetched.
+func (t *accuracyTracker) observe(readings []providerReading, consensus float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, r := range readings {
+		if r.fromCache {
+			continue
+		}
+		d, ok := t.deviation[r.provider]
+		if !ok {
+			d = initialDeviation
+		}
+		t.deviation[r.provider] = (1-accuracyAlpha)*d + accuracyAlpha*math.Abs(r.data.Celsius-consensus)
+	}
+}
+
+// adaptiveAggregator is a mean weighted by how close each provider's recent
+// readings were to the consensus, the median of each request's readings. Every
+// request with two or more readings updates the weights for the next.
+type adaptiveAggregator struct {
+	tracker *accuracyTracker
+}
+
+func (a adaptiveAggregator) Aggregate(readings []providerReading) (weatherData, error) {
+	if len(readings) == 0 {
+		return weatherData{}, errNoData
+	}
+
+	var result weatherData
+	var total, pressureTotal float64
+	temps := make([]float64, 0, len(readings))
+	for _, r := range readings {
+		w := a.tracker.weight(r.provider)
+		total += w
+		result.Celsius += w * r.data.Celsius
+		result.WindSpeed += w * r.data.WindSpeed
+		if r.data.Pressure > 0 {
+			pressureTotal += w
+			result.Pressure += w * r.data.Pressure
+		}
+		temps = append(temps, r.data.Celsius)
+	}
+
+	result.Celsius /= total
+	result.WindSpeed /= total
+	if pressureTotal > 0 {
+		result.Pressure /= pressureTotal
+	}
+	result.Observed = oldestObservation(readings)
+
+	// a lone reading is its own consensus and says nothing about accuracy
+	if len(readings) > 1 {
+		a.tracker.observe(readings, median(temps))
+	}
+	return result, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAdaptiveWeightFavoursTheProviderThatAgrees(t *testing.T) {
+	tracker := newAccuracyTracker()
+	agg := adaptiveAggregator{tracker: tracker}
+	readings := []providerReading{
+		{provider: "low", data: reading(10)},
+		{provider: "steady", data: reading(11)}, // always the median
+		{provider: "high", data: reading(13)},
+	}
+
+	lastWeight, lastC := tracker.weight("steady"), math.Inf(1)
+	for i := 1; i <= 10; i++ {
+		wd, err := agg.Aggregate(readings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := tracker.weight("steady")
+		if w <= lastWeight {
+			t.Errorf("request %d: steady's weight went from %.3f to %.3f, want it to gain", i, lastWeight, w)
+		}
+		if wd.Celsius >= lastC {
+			t.Errorf("request %d: got %.3f°C after %.3f°C, want it drawn towards steady's 11°C", i, wd.Celsius, lastC)
+		}
+		lastWeight, lastC = w, wd.Celsius
+	}
+
+	if low, high := tracker.weight("low"), tracker.weight("high"); lastWeight <= low || lastWeight <= high {
+		t.Errorf("steady weighs %.3f, low %.3f and high %.3f, want steady the most", lastWeight, low, high)
+	}
+	if tracker.weight("high") >= tracker.weight("low") {
+		t.Error("high, the furthest out, weighs no less than low")
+	}
+}
+
+func TestAdaptiveWeightNeedsAConsensus(t *testing.T) {
+	tracker := newAccuracyTracker()
+	agg := adaptiveAggregator{tracker: tracker}
+	start := tracker.weight("alone")
+
+	// a lone reading, or a cached one, says nothing about accuracy
+	agg.Aggregate([]providerReading{{provider: "alone", data: reading(30)}})
+	agg.Aggregate([]providerReading{
+		{provider: "alone", data: reading(30), fromCache: true},
+		{provider: "other", data: reading(10)},
+	})
+	if w := tracker.weight("alone"); w != start {
+		t.Errorf("weight %.3f, want the untouched %.3f", w, start)
+	}
+
+	if _, err := agg.Aggregate(nil); err != errNoData {
+		t.Errorf("got %v for no readings, want errNoData", err)
+	}
+}
+
+func TestAdaptiveWeightIsCapped(t *testing.T) {
+	tracker := newAccuracyTracker()
+	for i := 0; i < 100; i++ {
+		tracker.observe([]providerReading{{provider: "exact", data: reading(10)}}, 10)
+	}
+	if w := tracker.weight("exact"); w != 1/deviationFloor {
+		t.Errorf("weight %.3f, want it capped at %.3f", w, 1/deviationFloor)
+	}
+}
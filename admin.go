@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// switches records providers turned off at runtime through /admin/providers.
+// They stay off across config reloads until turned back on.
+var switches = newProviderSwitches()
+
+type providerSwitches struct {
+	mu       sync.RWMutex
+	disabled map[string]bool
+}
+
+func newProviderSwitches() *providerSwitches {
+	return &providerSwitches{disabled: make(map[string]bool)}
+}
+
+func (s *providerSwitches) enabled(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.disabled[name]
+}
+
+// anyDisabled lets callers skip filtering in the common case of every provider on.
+func (s *providerSwitches) anyDisabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.disabled) > 0
+}
+
+func (s *providerSwitches) set(name string, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if enabled {
+		delete(s.disabled, name)
+	} else {
+		s.disabled[name] = true
+	}
+}
+
+// authorized checks the request's bearer token against the shared admin secret.
+// An empty secret locks the admin endpoints entirely.
+func authorized(r *http.Request, secret string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if secret == "" || !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// adminProvidersHandler serves PATCH /admin/providers/{name} with a body of
+// {"enabled": true|false}.
+func adminProvidersHandler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, secret) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodPatch {
+			w.Header().Set("Allow", http.MethodPatch)
+			http.Error(w, "use PATCH", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/admin/providers/")
+		if !isKnownProvider(name) {
+			http.Error(w, "unknown provider "+name, http.StatusNotFound)
+			return
+		}
+
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil || body.Enabled == nil {
+			http.Error(w, `send {"enabled": true} or {"enabled": false}`, http.StatusBadRequest)
+			return
+		}
+
+		switches.set(name, *body.Enabled)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":    name,
+			"enabled": *body.Enabled,
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func patchProvider(h http.Handler, name string, body string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/admin/providers/"+name, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminDisablesProvider(t *testing.T) {
+	t.Cleanup(func() { switches.set("darksky", true) })
+	on := &fakeProvider{id: "openweathermap", data: reading(10)}
+	off := &fakeProvider{id: "darksky", data: reading(20)}
+	sc := testRoutes(on, off)
+	sc.adminToken = "secret"
+	h := newRouter(sc)
+
+	if rec := patchProvider(h, "darksky", `{"enabled": false}`, "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", rec.Code, rec.Body.String())
+	}
+	resp := decodeWeather(t, serve(h, "/weather/Bucharest?sources=1"))
+	if off.calls.Load() != 0 {
+		t.Error("disabled provider was asked")
+	}
+	if resp.Temp != "10.00°C" {
+		t.Errorf("temp is %s, want 10.00°C from the enabled provider alone", resp.Temp)
+	}
+	if len(resp.Skipped) != 1 || resp.Skipped[0].Reason != skipDisabled {
+		t.Errorf("skipped %+v, want darksky as disabled", resp.Skipped)
+	}
+
+	if rec := patchProvider(h, "darksky", `{"enabled": true}`, "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", rec.Code, rec.Body.String())
+	}
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+	if off.calls.Load() != 1 {
+		t.Errorf("re-enabled provider asked %d times, want once", off.calls.Load())
+	}
+}
+
+func TestAdminRejectsBadRequests(t *testing.T) {
+	t.Cleanup(func() { switches.set("darksky", true) })
+	sc := testRoutes()
+	sc.adminToken = "secret"
+	h := newRouter(sc)
+
+	tests := []struct {
+		name, body, token string
+		want              int
+	}{
+		{"darksky", `{"enabled": false}`, "", http.StatusUnauthorized},
+		{"darksky", `{"enabled": false}`, "wrong", http.StatusUnauthorized},
+		{"nosuch", `{"enabled": false}`, "secret", http.StatusNotFound},
+		{"darksky", `{}`, "secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := patchProvider(h, tt.name, tt.body, tt.token); rec.Code != tt.want {
+			t.Errorf("%s %s with %q: got %d, want %d", tt.name, tt.body, tt.token, rec.Code, tt.want)
+		}
+	}
+	if !switches.enabled("darksky") {
+		t.Error("a rejected request switched darksky off")
+	}
+
+	// without a token configured the endpoint is locked
+	sc.adminToken = ""
+	if rec := patchProvider(newRouter(sc), "darksky", `{"enabled": false}`, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("locked endpoint: got %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// providerReading is one provider's valid reading, handed to an Aggregator.
+type providerReading struct {
+	provider  string
+	data      weatherData
+	fromCache bool // answered from the per-provider cache rather than fetched
+}
+
+// source is the provider whose data the reading is, which differs from the
+// provider asked when a fallback answered for it.
+func (r providerReading) source() string {
+	if r.data.Source != "" {
+		return r.data.Source
+	}
+	return r.provider
+}
+
+// Aggregator combines the valid readings of a request into the single reading
+// that is served. Readings arrive in provider order and there is at least one.
+// Coordinates are resolved separately and need not be set on the result.
+type Aggregator interface {
+	Aggregate(readings []providerReading) (weatherData, error)
+}
+
+// nearAggregator is an Aggregator that also needs to know where the reading was
+// asked for. lat and long are zero when that isn't known.
+type nearAggregator interface {
+	AggregateNear(readings []providerReading, lat float64, long float64) (weatherData, error)
+}
+
+// aggregatorByName returns one of the built-in aggregators.
+func aggregatorByName(name string) (Aggregator, error) {
+	switch name {
+	case "mean":
+		return meanAggregator{}, nil
+	case "median":
+		return medianAggregator{}, nil
+	case "first":
+		return firstAggregator{}, nil
+	case "trimmed":
+		return trimmedAggregator{}, nil
+	case "nearest":
+		return nearestAggregator{}, nil
+	case "adaptive":
+		return adaptiveAggregator{tracker: accuracy}, nil
+	}
+	return nil, fmt.Errorf("unknown aggregation %q, expected mean, median, first, trimmed, nearest or adaptive", name)
+}
+
+// meanAggregator averages every measurement. Pressure is averaged over the
+// readings that report it.
+type meanAggregator struct{}
+
+func (meanAggregator) Aggregate(readings []providerReading) (weatherData, error) {
+	if len(readings) == 0 {
+		return weatherData{}, errNoData
+	}
+
+	var result weatherData
+	np := 0
+	for _, r := range readings {
+		result.Celsius += r.data.Celsius
+		result.WindSpeed += r.data.WindSpeed
+		if r.data.Pressure > 0 {
+			np++
+			result.Pressure += r.data.Pressure
+		}
+	}
+
+	n := float64(len(readings))
+	result.Celsius /= n
+	result.WindSpeed /= n
+	if np > 0 {
+		result.Pressure /= float64(np)
+	}
+	result.Observed = oldestObservation(readings)
+	return result, nil
+}
+
+// medianAggregator takes the median of every measurement, which keeps a single
+// wild provider from dragging the result.
+type medianAggregator struct{}
+
+func (medianAggregator) Aggregate(readings []providerReading) (weatherData, error) {
+	if len(readings) == 0 {
+		return weatherData{}, errNoData
+	}
+
+	var temps, winds, pressures []float64
+	for _, r := range readings {
+		temps = append(temps, r.data.Celsius)
+		winds = append(winds, r.data.WindSpeed)
+		if r.data.Pressure > 0 {
+			pressures = append(pressures, r.data.Pressure)
+		}
+	}
+
+	return weatherData{
+		Celsius:   median(temps),
+		WindSpeed: median(winds),
+		Pressure:  median(pressures),
+		Observed:  oldestObservation(readings),
+	}, nil
+}
+
+// firstAggregator serves the reading of the first provider that had one.
+type firstAggregator struct{}
+
+func (firstAggregator) Aggregate(readings []providerReading) (weatherData, error) {
+	if len(readings) == 0 {
+		return weatherData{}, errNoData
+	}
+	return readings[0].data, nil
+}
+
+// trimmedAggregator leaves out the readings with the highest and the lowest
+// temperature and averages the rest, so a single outlier on either side doesn't
+// count. With fewer than three readings it is the plain mean.
+type trimmedAggregator struct{}
+
+func (trimmedAggregator) Aggregate(readings []providerReading) (weatherData, error) {
+	if len(readings) < 3 {
+		return meanAggregator{}.Aggregate(readings)
+	}
+
+	lowest, highest := 0, 0
+	for i, r := range readings {
+		if r.data.Celsius < readings[lowest].data.Celsius {
+			lowest = i
+		}
+		if r.data.Celsius > readings[highest].data.Celsius {
+			highest = i
+		}
+	}
+	if lowest == highest {
+		// every reading is the same temperature
+		highest = (lowest + 1) % len(readings)
+	}
+
+	kept := make([]providerReading, 0, len(readings)-2)
+	for i, r := range readings {
+		if i != lowest && i != highest {
+			kept = append(kept, r)
+		}
+	}
+	return meanAggregator{}.Aggregate(kept)
+}
+
+// nearestAggregator serves the reading of the provider whose station is closest
+// to the requested coordinates, the first one on a tie. Coordinates that only
+// echo the request are passed over. When nobody reports coordinates of their
+// own, or there are none to compare with, it is the mean.
+type nearestAggregator struct{}
+
+func (nearestAggregator) Aggregate(readings []providerReading) (weatherData, error) {
+	return meanAggregator{}.Aggregate(readings)
+}
+
+func (nearestAggregator) AggregateNear(readings []providerReading, lat float64, long float64) (weatherData, error) {
+	if lat == 0.0 && long == 0.0 {
+		return meanAggregator{}.Aggregate(readings)
+	}
+
+	nearest, nearestKm := -1, 0.0
+	for i, r := range readings {
+		if r.data.Latitude == 0.0 && r.data.Longitude == 0.0 {
+			continue
+		}
+		if echoesRequest(lat, long, r.data.Latitude, r.data.Longitude) {
+			continue
+		}
+		km := haversineKm(lat, long, r.data.Latitude, r.data.Longitude)
+		if nearest < 0 || km < nearestKm {
+			nearest, nearestKm = i, km
+		}
+	}
+	if nearest < 0 {
+		return meanAggregator{}.Aggregate(readings)
+	}
+	return readings[nearest].data, nil
+}
+
+// median of values, zero when there are none. values is sorted in place.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// oldestObservation is the earliest known observation time, since a combined
+// reading is only as fresh as its oldest part.
+func oldestObservation(readings []providerReading) time.Time {
+	var oldest time.Time
+	for _, r := range readings {
+		observed := r.data.Observed
+		if !observed.IsZero() && (oldest.IsZero() || observed.Before(oldest)) {
+			oldest = observed
+		}
+	}
+	return oldest
+}
+
+// commonConditions is the conditions text most providers agree on, ignoring case.
+// Ties go to the provider asked first, and readings without conditions don't vote.
+func commonConditions(readings []providerReading) string {
+	counts := map[string]int{}
+	best := ""
+	for _, r := range readings {
+		conditions := strings.ToLower(strings.TrimSpace(r.data.Conditions))
+		if conditions == "" {
+			continue
+		}
+		counts[conditions]++
+		if best == "" || counts[conditions] > counts[best] {
+			best = conditions
+		}
+	}
+	return best
+}
+
+// tCritical95 are the two-sided 95% critical values of Student's t distribution
+// for 1 to 30 degrees of freedom. Beyond that the normal 1.96 is close enough.
+var tCritical95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// confidenceInterval is the 95% confidence interval of the mean celsius reading,
+// mean ± t·s/√n. A single reading has nothing to spread over, so its interval is
+// just that reading. It is around the mean whichever aggregator is in use.
+func confidenceInterval(readings []providerReading) (low float64, high float64) {
+	n := len(readings)
+	if n == 0 {
+		return 0, 0
+	}
+
+	mean := 0.0
+	for _, r := range readings {
+		mean += r.data.Celsius
+	}
+	mean /= float64(n)
+	if n == 1 {
+		return mean, mean
+	}
+
+	variance := 0.0
+	for _, r := range readings {
+		d := r.data.Celsius - mean
+		variance += d * d
+	}
+	variance /= float64(n - 1)
+
+	t := 1.96
+	if n-1 <= len(tCritical95) {
+		t = tCritical95[n-2]
+	}
+	margin := t * math.Sqrt(variance) / math.Sqrt(float64(n))
+	// few, far apart readings can put the low end below absolute zero
+	return math.Max(mean-margin, -KelvinShift), mean + margin
+}
+
+// maxSpreadPct caps spreadPct, which grows without bound as the mean nears zero.
+const maxSpreadPct = 1000
+
+// minSpreadMean is the smallest mean celsius reading, either side of zero, that
+// a spread is worth expressing as a percentage of.
+const minSpreadMean = 0.5
+
+// spreadPct is how far apart the celsius readings are, (max−min)/|mean| as a
+// percentage, capped at maxSpreadPct. It is false when the mean is too close to
+// zero for a percentage of it to mean anything.
+func spreadPct(readings []providerReading) (float64, bool) {
+	if len(readings) == 0 {
+		return 0, false
+	}
+
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	mean := 0.0
+	for _, r := range readings {
+		lowest = math.Min(lowest, r.data.Celsius)
+		highest = math.Max(highest, r.data.Celsius)
+		mean += r.data.Celsius
+	}
+	mean /= float64(len(readings))
+	if math.Abs(mean) < minSpreadMean {
+		return 0, false
+	}
+	return math.Min((highest-lowest)/math.Abs(mean)*100, maxSpreadPct), true
+}
+
+// consensus reports whether every celsius reading is within tolerance of every
+// other, which a single reading always is.
+func consensus(readings []providerReading, tolerance float64) bool {
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for _, r := range readings {
+		lowest = math.Min(lowest, r.data.Celsius)
+		highest = math.Max(highest, r.data.Celsius)
+	}
+	return len(readings) == 0 || highest-lowest <= tolerance
+}
+
+// locationNames are the distinct names providers gave the place, ignoring case,
+// in provider order.
+func locationNames(readings []providerReading) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, r := range readings {
+		name := strings.TrimSpace(r.data.LocationName)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// meanFeelsLike averages the feels-like temperature of the readings that have
+// one. It is false when none do.
+func meanFeelsLike(readings []providerReading) (float64, bool) {
+	sum, n := 0.0, 0
+	for _, r := range readings {
+		if r.data.HasFeelsLike {
+			sum += r.data.FeelsLike
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// meanCloudCover averages the cloud cover of the readings that have one. It is
+// false when none do.
+func meanCloudCover(readings []providerReading) (float64, bool) {
+	sum, n := 0.0, 0
+	for _, r := range readings {
+		if r.data.HasCloudCover {
+			sum += r.data.CloudCover
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// sunTimes are the sunrise and sunset of the first provider to report them.
+func sunTimes(readings []providerReading) (sunrise time.Time, sunset time.Time) {
+	for _, r := range readings {
+		if !r.data.Sunrise.IsZero() || !r.data.Sunset.IsZero() {
+			return r.data.Sunrise, r.data.Sunset
+		}
+	}
+	return time.Time{}, time.Time{}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// readingsOf are valid readings of the given celsius temperatures, from
+// providers p0, p1 and so on.
+func readingsOf(temps ...float64) []providerReading {
+	readings := make([]providerReading, len(temps))
+	for i, c := range temps {
+		readings[i] = providerReading{provider: fmt.Sprintf("p%d", i), data: reading(c)}
+	}
+	return readings
+}
+
+// closestTo is a custom Aggregator picking the reading closest to target.
+type closestTo struct {
+	target float64
+	called *int
+}
+
+func (a closestTo) Aggregate(readings []providerReading) (weatherData, error) {
+	*a.called++
+	best := readings[0].data
+	for _, r := range readings[1:] {
+		if math.Abs(r.data.Celsius-a.target) < math.Abs(best.Celsius-a.target) {
+			best = r.data
+		}
+	}
+	return best, nil
+}
+
+func TestCustomAggregator(t *testing.T) {
+	mw := multiWeatherProvider{
+		&fakeProvider{id: "custom-a", data: reading(5)},
+		&fakeProvider{id: "custom-b", data: reading(19)},
+		&fakeProvider{id: "custom-c", data: reading(30)},
+	}
+	called := 0
+	agg, err := mw.temperature(context.Background(), "Bucharest", 0, 0, aggregateOptions{aggregator: closestTo{target: 20, called: &called}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called != 1 {
+		t.Errorf("aggregator called %d times, want once", called)
+	}
+	if agg.Celsius != 19 {
+		t.Errorf("got %.2f°C, want the 19.00°C closest to 20", agg.Celsius)
+	}
+}
+
+func TestBuiltInAggregators(t *testing.T) {
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{"mean", 13},
+		{"median", 10},
+		{"first", 4},
+	}
+	for _, tt := range tests {
+		a, err := aggregatorByName(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := a.Aggregate(readingsOf(4, 25, 10))
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if got.Celsius != tt.want {
+			t.Errorf("%s: got %.2f°C, want %.2f°C", tt.name, got.Celsius, tt.want)
+		}
+	}
+
+	if _, err := aggregatorByName("mode"); err == nil {
+		t.Error("unknown aggregation accepted")
+	}
+}
+
+func TestMeanAggregatorAveragesOnlyReportedPressure(t *testing.T) {
+	readings := readingsOf(10, 20, 30)
+	readings[0].data.Pressure = 1000
+	readings[2].data.Pressure = 1010
+
+	got, err := meanAggregator{}.Aggregate(readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Pressure != 1005 {
+		t.Errorf("pressure is %.2f hPa, want 1005.00 from the two readings that have one", got.Pressure)
+	}
+}
+
+func TestCommonConditions(t *testing.T) {
+	tests := []struct {
+		conditions []string
+		want       string
+	}{
+		{[]string{"light rain", "Light Rain", "clear sky"}, "light rain"},
+		{[]string{"clear sky", "light rain", "light rain"}, "light rain"},
+		{[]string{"clear sky", "light rain"}, "clear sky"},
+		{[]string{"", "", "overcast"}, "overcast"},
+		{[]string{"", ""}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		readings := make([]providerReading, len(tt.conditions))
+		for i, conditions := range tt.conditions {
+			readings[i] = providerReading{provider: fmt.Sprintf("p%d", i), data: weatherData{HasReading: true, Conditions: conditions}}
+		}
+		if got := commonConditions(readings); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.conditions, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkAggregate(b *testing.B) {
+	mw := multiWeatherProvider{
+		&fakeProvider{id: "bench-a", data: reading(10)},
+		&fakeProvider{id: "bench-b", data: reading(11)},
+		&fakeProvider{id: "bench-c", data: reading(12)},
+		&fakeProvider{id: "bench-d", data: reading(13)},
+	}
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := mw.temperature(ctx, "", 44.4268, 26.1025, aggregateOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConfidenceInterval(t *testing.T) {
+	many := make([]float64, 40)
+	for i := range many {
+		many[i] = float64(10 + i%2*2) // 10 and 12 alternately
+	}
+	tests := []struct {
+		name      string
+		temps     []float64
+		low, high float64
+	}{
+		{"three readings", []float64{10, 12, 14}, 12 - 4.303*2/math.Sqrt(3), 12 + 4.303*2/math.Sqrt(3)},
+		{"two readings", []float64{10, 12}, 11 - 12.706*math.Sqrt2/math.Sqrt(2), 11 + 12.706*math.Sqrt2/math.Sqrt(2)},
+		{"one reading", []float64{12}, 12, 12},
+		{"agreeing readings", []float64{12, 12, 12}, 12, 12},
+		{"past the t table", many, 11 - 1.96*math.Sqrt(40.0/39)/math.Sqrt(40), 11 + 1.96*math.Sqrt(40.0/39)/math.Sqrt(40)},
+		{"no readings", nil, 0, 0},
+	}
+	for _, tt := range tests {
+		low, high := confidenceInterval(readingsOf(tt.temps...))
+		if math.Abs(low-tt.low) > 1e-9 || math.Abs(high-tt.high) > 1e-9 {
+			t.Errorf("%s: got %.4f to %.4f, want %.4f to %.4f", tt.name, low, high, tt.low, tt.high)
+		}
+	}
+
+	// the low end never goes below absolute zero
+	if low, _ := confidenceInterval(readingsOf(-270, 100)); low != -KelvinShift {
+		t.Errorf("low end %.2f, want it held at -273.15", low)
+	}
+}
+
+func TestTrimmedAggregator(t *testing.T) {
+	tests := []struct {
+		temps []float64
+		want  float64
+	}{
+		{[]float64{5, 10, 11, 12, 40}, 11},
+		{[]float64{40, 12, 5, 11, 10}, 11}, // in any order
+		{[]float64{-50, 10, 12}, 10},
+		{[]float64{7, 7, 7}, 7},
+		{[]float64{7, 7, 9, 9}, 8},
+		{[]float64{10, 20}, 15}, // too few to trim, the mean
+		{[]float64{12}, 12},
+	}
+	for _, tt := range tests {
+		got, err := trimmedAggregator{}.Aggregate(readingsOf(tt.temps...))
+		if err != nil {
+			t.Errorf("%v: %s", tt.temps, err)
+			continue
+		}
+		if got.Celsius != tt.want {
+			t.Errorf("%v: got %.2f°C, want %.2f°C", tt.temps, got.Celsius, tt.want)
+		}
+	}
+	if _, err := (trimmedAggregator{}).Aggregate(nil); !errors.Is(err, errNoData) {
+		t.Errorf("no readings: got %v, want errNoData", err)
+	}
+}
+
+func TestNearestAggregator(t *testing.T) {
+	at := func(c float64, lat float64, long float64) weatherData {
+		wd := reading(c)
+		wd.Latitude, wd.Longitude = lat, long
+		return wd
+	}
+	const lat, long = 44.4268, 26.1025
+	tests := []struct {
+		name     string
+		readings []weatherData
+		want     float64
+	}{
+		{"nearest wins", []weatherData{at(10, 44.9, 26.1), at(11, 44.43, 26.1), at(12, 45.7, 21.2)}, 11},
+		{"in any order", []weatherData{at(12, 45.7, 21.2), at(10, 44.9, 26.1), at(11, 44.43, 26.1)}, 11},
+		{"echoes are passed over", []weatherData{at(10, lat, long), at(12, 44.9, 26.1)}, 12},
+		{"without coordinates the mean", []weatherData{reading(10), reading(14), at(30, lat, long)}, 18},
+	}
+	for _, tt := range tests {
+		readings := make([]providerReading, len(tt.readings))
+		for i, wd := range tt.readings {
+			readings[i] = providerReading{provider: fmt.Sprintf("p%d", i), data: wd}
+		}
+		got, err := nearestAggregator{}.AggregateNear(readings, lat, long)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if got.Celsius != tt.want {
+			t.Errorf("%s: got %.2f°C, want %.2f°C", tt.name, got.Celsius, tt.want)
+		}
+	}
+}
+
+func TestWeatherNearestAggregation(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.openweathermap.org": {body: `{"coord":{"lon":26.1025,"lat":44.4268},"main":{"temp":283.15}}`},
+		"api.weatherbit.io":      {body: `{"data":[{"lat":44.5,"lon":26.11,"temp":11}]}`},
+		"api.open-meteo.com":     providerFixtures["api.open-meteo.com"],
+	})
+	sc := testRoutes(openWeatherMap{apiKey: "key"}, weatherBit{apiKey: "key"}, openMeteo{})
+	sc.opts.aggregator = nearestAggregator{}
+
+	resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025"))
+	if resp.Temp != "12.40°C" {
+		t.Errorf("temp is %s, want open-meteo's 12.40°C from the grid cell nearest, not openweathermap's echo", resp.Temp)
+	}
+}
+
+func TestSpreadPct(t *testing.T) {
+	tests := []struct {
+		name   string
+		temps  []float64
+		want   float64
+		wantOK bool
+	}{
+		{"known readings", []float64{8, 10, 12}, 40, true},
+		{"negative mean", []float64{-12, -8}, 40, true},
+		{"agreeing", []float64{15, 15}, 0, true},
+		{"capped", []float64{-10, 11}, maxSpreadPct, true},
+		{"mean near zero", []float64{-1, 1.5}, 0, false},
+		{"no readings", nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := spreadPct(readingsOf(tt.temps...))
+		if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: got %.2f%%, %t, want %.2f%%, %t", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestWeatherSpreadPct(t *testing.T) {
+	sc := testRoutes(
+		&fakeProvider{id: "spread-a", data: reading(8)},
+		&fakeProvider{id: "spread-b", data: reading(10)},
+		&fakeProvider{id: "spread-c", data: reading(12)},
+	)
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest"))
+	if resp.SpreadPct == nil || *resp.SpreadPct != 40 {
+		t.Errorf("spread_pct %v, want 40", resp.SpreadPct)
+	}
+}
+
+func TestMeanFeelsLike(t *testing.T) {
+	feels := func(c float64) weatherData {
+		wd := reading(10)
+		wd.FeelsLike, wd.HasFeelsLike = c, true
+		return wd
+	}
+	tests := []struct {
+		name     string
+		readings []weatherData
+		want     float64
+		wantHas  bool
+	}{
+		{"averaged", []weatherData{feels(4), feels(8)}, 6, true},
+		{"only those that have one", []weatherData{feels(4), reading(10), feels(0)}, 2, true},
+		{"a real zero", []weatherData{feels(0)}, 0, true},
+		{"none", []weatherData{reading(10), reading(12)}, 0, false},
+	}
+	for _, tt := range tests {
+		readings := make([]providerReading, len(tt.readings))
+		for i, wd := range tt.readings {
+			readings[i] = providerReading{provider: fmt.Sprintf("p%d", i), data: wd}
+		}
+		got, ok := meanFeelsLike(readings)
+		if got != tt.want || ok != tt.wantHas {
+			t.Errorf("%s: got %.2f°C, %t, want %.2f°C, %t", tt.name, got, ok, tt.want, tt.wantHas)
+		}
+	}
+}
+
+func TestMeanCloudCover(t *testing.T) {
+	cover := func(pct float64) weatherData {
+		wd := reading(10)
+		wd.CloudCover, wd.HasCloudCover = pct, true
+		return wd
+	}
+	tests := []struct {
+		name     string
+		readings []weatherData
+		want     float64
+		wantHas  bool
+	}{
+		{"averaged", []weatherData{cover(20), cover(60)}, 40, true},
+		{"only those that have one", []weatherData{cover(90), reading(10), cover(0)}, 45, true},
+		{"a clear sky", []weatherData{cover(0)}, 0, true},
+		{"none", []weatherData{reading(10), reading(12)}, 0, false},
+	}
+	for _, tt := range tests {
+		readings := make([]providerReading, len(tt.readings))
+		for i, wd := range tt.readings {
+			readings[i] = providerReading{provider: fmt.Sprintf("p%d", i), data: wd}
+		}
+		got, ok := meanCloudCover(readings)
+		if got != tt.want || ok != tt.wantHas {
+			t.Errorf("%s: got %v%%, %t, want %v%%, %t", tt.name, got, ok, tt.want, tt.wantHas)
+		}
+	}
+}
+
+func TestWeatherCloudCover(t *testing.T) {
+	cloudy := reading(10)
+	cloudy.CloudCover, cloudy.HasCloudCover = 80, true
+	clear := reading(12)
+	clear.CloudCover, clear.HasCloudCover = 0, true
+	sc := testRoutes(
+		&fakeProvider{id: "clouds-cloudy", data: cloudy},
+		&fakeProvider{id: "clouds-clear", data: clear},
+		&fakeProvider{id: "clouds-none", data: reading(11)},
+	)
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest"))
+	if resp.CloudCover == nil || *resp.CloudCover != 40 {
+		t.Errorf("cloud_cover %v, want 40", resp.CloudCover)
+	}
+
+	sc = testRoutes(&fakeProvider{id: "clouds-none", data: reading(11)})
+	if resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest")); resp.CloudCover != nil {
+		t.Errorf("cloud_cover %v without any provider giving one", *resp.CloudCover)
+	}
+}
+
+func TestWeatherShowsAFreezingFeelsLike(t *testing.T) {
+	wd := reading(3)
+	wd.FeelsLike, wd.HasFeelsLike = 0, true
+	sc := testRoutes(&fakeProvider{id: "feels-freezing", data: wd}, &fakeProvider{id: "feels-none", data: reading(5)})
+	if resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest")); resp.FeelsLike != "0.00°C" {
+		t.Errorf("feels_like %q, want 0.00°C", resp.FeelsLike)
+	}
+
+	sc = testRoutes(&fakeProvider{id: "feels-none", data: reading(5)})
+	if resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest")); resp.FeelsLike != "" {
+		t.Errorf("feels_like %q without any provider giving one", resp.FeelsLike)
+	}
+}
+
+func TestConsensus(t *testing.T) {
+	tests := []struct {
+		name      string
+		readings  []providerReading
+		tolerance float64
+		want      bool
+	}{
+		{"tight", readingsOf(10, 10.25, 10.5), 1, true},
+		{"exactly the tolerance apart", readingsOf(10, 10.5, 11), 1, true},
+		{"just past the tolerance", readingsOf(10, 10.5, 11.0625), 1, false},
+		{"loose", readingsOf(8, 10, 12), 1, false},
+		{"loose within a wider tolerance", readingsOf(8, 10, 12), 4, true},
+		{"tight past a narrower tolerance", readingsOf(10, 10.25, 10.5), 0.25, false},
+		{"a single reading", readingsOf(10), 0, true},
+		{"no readings", nil, 1, true},
+	}
+	for _, tt := range tests {
+		if got := consensus(tt.readings, tt.tolerance); got != tt.want {
+			t.Errorf("%s: consensus is %t within %g°C, want %t", tt.name, got, tt.tolerance, tt.want)
+		}
+	}
+}
+
+func TestWeatherConsensus(t *testing.T) {
+	tests := []struct {
+		temps     []float64
+		tolerance float64
+		want      bool
+	}{
+		{[]float64{10, 11}, 1, true},
+		{[]float64{10, 11.0625}, 1, false},
+		{[]float64{10, 11.0625}, 2, true},
+	}
+	for _, tt := range tests {
+		sc := testRoutes(
+			&fakeProvider{id: "consensus-a", data: reading(tt.temps[0])},
+			&fakeProvider{id: "consensus-b", data: reading(tt.temps[1])},
+		)
+		sc.consensusTolerance = tt.tolerance
+		resp := decodeWeather(t, serve(newRouter(sc), "/weather/?lat=44.4268&long=26.1025"))
+		if resp.Consensus != tt.want {
+			t.Errorf("%v within %g°C: consensus is %t, want %t", tt.temps, tt.tolerance, resp.Consensus, tt.want)
+		}
+	}
+}
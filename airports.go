@@ -0,0 +1,47 @@
+package main
+
+import "regexp"
+
+// icaoPattern matches an ICAO airport code as aviation users write it, four
+// capital letters. Only codes in airports are taken as one, so a city such as
+// "ROME" is still a city.
+var icaoPattern = regexp.MustCompile(`^[A-Z]{4}$`)
+
+// airports are the aerodrome reference points of the airports that can be asked
+// for by ICAO code.
+var airports = map[string]coordinates{
+	"KDSM": {Latitude: 41.5340, Longitude: -93.6631},  // Des Moines
+	"KATL": {Latitude: 33.6407, Longitude: -84.4277},  // Atlanta
+	"KDEN": {Latitude: 39.8561, Longitude: -104.6737}, // Denver
+	"KDFW": {Latitude: 32.8998, Longitude: -97.0403},  // Dallas/Fort Worth
+	"KJFK": {Latitude: 40.6413, Longitude: -73.7781},  // New York JFK
+	"KLAX": {Latitude: 33.9416, Longitude: -118.4085}, // Los Angeles
+	"KORD": {Latitude: 41.9742, Longitude: -87.9073},  // Chicago O'Hare
+	"KSEA": {Latitude: 47.4502, Longitude: -122.3088}, // Seattle-Tacoma
+	"KSFO": {Latitude: 37.6213, Longitude: -122.3790}, // San Francisco
+	"CYYZ": {Latitude: 43.6777, Longitude: -79.6248},  // Toronto Pearson
+	"EGLL": {Latitude: 51.4700, Longitude: -0.4543},   // London Heathrow
+	"EDDF": {Latitude: 50.0379, Longitude: 8.5622},    // Frankfurt
+	"EHAM": {Latitude: 52.3105, Longitude: 4.7683},    // Amsterdam Schiphol
+	"LEMD": {Latitude: 40.4983, Longitude: -3.5676},   // Madrid Barajas
+	"LFPG": {Latitude: 49.0097, Longitude: 2.5479},    // Paris Charles de Gaulle
+	"LIRF": {Latitude: 41.8003, Longitude: 12.2389},   // Rome Fiumicino
+	"LROP": {Latitude: 44.5711, Longitude: 26.0850},   // Bucharest Otopeni
+	"OMDB": {Latitude: 25.2532, Longitude: 55.3657},   // Dubai
+	"RJTT": {Latitude: 35.5494, Longitude: 139.7798},  // Tokyo Haneda
+	"ZBAA": {Latitude: 40.0799, Longitude: 116.6031},  // Beijing Capital
+	"VHHH": {Latitude: 22.3080, Longitude: 113.9185},  // Hong Kong
+	"WSSS": {Latitude: 1.3644, Longitude: 103.9915},   // Singapore Changi
+	"YSSY": {Latitude: -33.9399, Longitude: 151.1753}, // Sydney
+	"SBGR": {Latitude: -23.4356, Longitude: -46.4731}, // São Paulo Guarulhos
+	"FAOR": {Latitude: -26.1367, Longitude: 28.2411},  // Johannesburg
+}
+
+// airportByICAO looks city up as an ICAO code.
+func airportByICAO(city string) (coordinates, bool) {
+	if !icaoPattern.MatchString(city) {
+		return coordinates{}, false
+	}
+	c, ok := airports[city]
+	return c, ok
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestAirportByICAO(t *testing.T) {
+	tests := []struct {
+		city   string
+		want   coordinates
+		wantOK bool
+	}{
+		{"KDSM", coordinates{Latitude: 41.5340, Longitude: -93.6631}, true},
+		{"LROP", coordinates{Latitude: 44.5711, Longitude: 26.0850}, true},
+		{"kdsm", coordinates{}, false}, // codes are written in capitals
+		{"ROME", coordinates{}, false}, // four capitals, but no airport
+		{"KDSMX", coordinates{}, false},
+		{"Des Moines", coordinates{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := airportByICAO(tt.city)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: got %+v, %t, want %+v, %t", tt.city, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestWeatherForAnICAOCode(t *testing.T) {
+	var got coordinates
+	g := &fakeGeocoder{lat: 1, long: 1}
+	sc := testRoutes(askedAt("icao", &got))
+	sc.opts.geocoder = g
+	sc.reverse = fakeReverse{name: "Des Moines"}
+
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/KDSM"))
+	if got != (coordinates{Latitude: 41.5340, Longitude: -93.6631}) {
+		t.Errorf("provider asked at %+v, want KDSM's 41.5340, -93.6631", got)
+	}
+	if resp.ICAO != "KDSM" || resp.City != "Des Moines" {
+		t.Errorf("got icao %q and city %q, want KDSM in Des Moines", resp.ICAO, resp.City)
+	}
+	if n := g.calls.Load(); n != 0 {
+		t.Errorf("geocoded %d times, want the airport's own coordinates used", n)
+	}
+
+	// a four-letter city that isn't an airport is geocoded like any other
+	resp = decodeWeather(t, serve(newRouter(sc), "/weather/ROME"))
+	if got != (coordinates{Latitude: 1, Longitude: 1}) || resp.ICAO != "" {
+		t.Errorf("ROME asked at %+v with icao %q, want the geocoded 1, 1 and none", got, resp.ICAO)
+	}
+}
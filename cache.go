@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// weatherCache holds aggregated readings per city until they are older than the ttl.
+// A ttl of zero or less disables caching. It is shared by every request, so it is safe
+// for concurrent use.
+type weatherCache struct {
+	mu      sync.Mutex // guards entries, including the delete of expired entries in get
+	clock   Clock
+	ttl     time.Duration
+	entries map[string]cacheEntry
+
+	// maxStale is how long past the ttl entries are kept for stale, zero
+	// deletes them as soon as they expire
+	maxStale time.Duration
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cacheEntry struct {
+	data   aggregate
+	stored time.Time
+}
+
+func newWeatherCache(clock Clock, ttl time.Duration) *weatherCache {
+	return &weatherCache{
+		clock:   clock,
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// cacheKey normalizes a city so that "Bucharest" and "bucharest" share an entry.
+// Requests with coordinates are keyed by them as well.
+func cacheKey(city string, lat float64, long float64) string {
+	key := strings.ToLower(strings.TrimSpace(city))
+	if lat != 0.0 || long != 0.0 {
+		key += fmt.Sprintf("@%.4f,%.4f", lat, long)
+	}
+	return key
+}
+
+func (c *weatherCache) get(key string) (cacheEntry, bool) {
+	if c.ttl <= 0 {
+		return cacheEntry{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return cacheEntry{}, false
+	}
+
+	if age := c.clock.Since(e.stored); age >= c.ttl {
+		if age >= c.ttl+c.maxStale {
+			delete(c.entries, key)
+		}
+		c.misses.Add(1)
+		return cacheEntry{}, false
+	}
+
+	c.hits.Add(1)
+	return e, true
+}
+
+// stale returns the entry for key even when it has expired, as long as it
+// expired less than maxStale ago, for serving when a fresh fetch fails.
+func (c *weatherCache) stale(key string) (cacheEntry, bool) {
+	if c.ttl <= 0 {
+		return cacheEntry{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	if c.clock.Since(e.stored) >= c.ttl+c.maxStale {
+		delete(c.entries, key)
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+// cacheEntryInfo describes one entry for /debug/cache.
+type cacheEntryInfo struct {
+	Key       string      `json:"key"`
+	Reading   weatherData `json:"reading"`
+	Providers []string    `json:"providers"`
+	Stored    time.Time   `json:"stored"`
+	TTLLeft   float64     `json:"ttl_left_seconds"` // negative once expired and only kept for -stale-while-error
+}
+
+// list lists the cache contents sorted by key, with how long each entry has left.
+func (c *weatherCache) list() []cacheEntryInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	infos := make([]cacheEntryInfo, 0, len(c.entries))
+	for key, e := range c.entries {
+		providers := make([]string, 0, len(e.data.Readings))
+		for _, r := range e.data.Readings {
+			providers = append(providers, r.provider)
+		}
+		infos = append(infos, cacheEntryInfo{
+			Key:       key,
+			Reading:   e.data.weatherData,
+			Providers: providers,
+			Stored:    e.stored,
+			TTLLeft:   (c.ttl - c.clock.Since(e.stored)).Seconds(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos
+}
+
+// hitRatio is the share of lookups answered from the cache, zero before any lookup.
+func (c *weatherCache) hitRatio() float64 {
+	hits, misses := c.hits.Load(), c.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+func (c *weatherCache) set(key string, agg aggregate) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = cacheEntry{data: agg, stored: c.clock.Now()}
+}
+
+// readingCache holds single providers' readings, so that a provider that was
+// asked recently isn't asked again while the others are. Like weatherCache a ttl
+// of zero or less disables it, and it is safe for concurrent use.
+type readingCache struct {
+	mu      sync.Mutex
+	clock   Clock
+	ttl     time.Duration
+	entries map[string]readingEntry
+}
+
+type readingEntry struct {
+	data   weatherData
+	stored time.Time
+}
+
+func newReadingCache(clock Clock, ttl time.Duration) *readingCache {
+	return &readingCache{
+		clock:   clock,
+		ttl:     ttl,
+		entries: make(map[string]readingEntry),
+	}
+}
+
+// readingKey keys a provider's reading by the city and coordinates it was asked for.
+func readingKey(provider string, city string, lat float64, long float64) string {
+	return provider + "|" + cacheKey(city, lat, long)
+}
+
+func (c *readingCache) get(key string) (weatherData, bool) {
+	if c == nil || c.ttl <= 0 {
+		return weatherData{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return weatherData{}, false
+	}
+	if c.clock.Since(e.stored) >= c.ttl {
+		delete(c.entries, key)
+		return weatherData{}, false
+	}
+	return e.data, true
+}
+
+func (c *readingCache) set(key string, wd weatherData) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = readingEntry{data: wd, stored: c.clock.Now()}
+}
+
+// refreshGroup runs at most one background refresh per cache key at a time, so
+// a burst of requests for a stale entry fetches it once.
+type refreshGroup struct {
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func newRefreshGroup() *refreshGroup {
+	return &refreshGroup{running: make(map[string]bool)}
+}
+
+// start runs refresh in the background unless one is already running for key,
+// and reports whether it did.
+func (g *refreshGroup) start(key string, refresh func()) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.running[key] {
+		return false
+	}
+	g.running[key] = true
+	go func() {
+		defer func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			delete(g.running, key)
+		}()
+		refresh()
+	}()
+	return true
+}
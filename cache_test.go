@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWeatherCacheExpiresAfterTTL(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := newWeatherCache(clock, time.Minute)
+	c.set("bucharest", aggregate{weatherData: weatherData{Celsius: 12}})
+
+	clock.Advance(time.Minute - time.Second)
+	e, ok := c.get("bucharest")
+	if !ok {
+		t.Fatal("entry missing before the ttl is up")
+	}
+	if e.data.Celsius != 12 {
+		t.Errorf("got %.2f°C, want 12.00°C", e.data.Celsius)
+	}
+
+	clock.Advance(time.Second)
+	if _, ok := c.get("bucharest"); ok {
+		t.Error("entry still served once the ttl is up")
+	}
+}
+
+func TestWeatherCacheZeroTTLDisablesCaching(t *testing.T) {
+	c := newWeatherCache(newFakeClock(time.Now()), 0)
+	c.set("bucharest", aggregate{})
+	if _, ok := c.get("bucharest"); ok {
+		t.Error("entry served with caching disabled")
+	}
+}
+
+func TestWeatherCacheConcurrent(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := newWeatherCache(clock, time.Hour)
+	c.maxStale = time.Hour
+
+	const workers, rounds = 16, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			own := fmt.Sprintf("city-%d", w)
+			for i := 0; i < rounds; i++ {
+				// every worker writes the shared key, and only this one its own
+				c.set("shared", aggregate{weatherData: weatherData{Celsius: float64(w)}})
+				c.set(own, aggregate{weatherData: weatherData{Celsius: float64(w)}})
+
+				if e, ok := c.get(own); !ok || e.data.Celsius != float64(w) {
+					t.Errorf("%s: got %+v, %t, want %d°C", own, e.data.weatherData, ok, w)
+					return
+				}
+				if e, ok := c.stale("shared"); !ok || e.data.Celsius < 0 || e.data.Celsius >= workers {
+					t.Errorf("shared: got %+v, %t, want a worker's reading", e.data.weatherData, ok)
+					return
+				}
+				c.list()
+				c.hitRatio()
+				clock.Advance(time.Millisecond)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		key := fmt.Sprintf("city-%d", w)
+		if e, ok := c.get(key); !ok || e.data.Celsius != float64(w) {
+			t.Errorf("%s: got %+v, %t, want %d°C", key, e.data.weatherData, ok, w)
+		}
+	}
+	if got := len(c.list()); got != workers+1 {
+		t.Errorf("%d entries, want %d", got, workers+1)
+	}
+	if hits, misses := c.hits.Load(), c.misses.Load(); hits != workers*rounds+workers || misses != 0 {
+		t.Errorf("%d hits and %d misses, want %d hits and no misses", hits, misses, workers*rounds+workers)
+	}
+}
+
+func TestWeatherCacheHitRatio(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := newWeatherCache(clock, time.Minute)
+	if got := c.hitRatio(); got != 0 {
+		t.Errorf("got %v before any lookup, want 0", got)
+	}
+
+	c.get("bucharest") // miss, nothing stored
+	c.set("bucharest", aggregate{})
+	c.get("bucharest") // hit
+	c.get("bucharest") // hit
+	clock.Advance(time.Minute)
+	c.get("bucharest") // miss, expired
+	c.set("bucharest", aggregate{})
+	c.get("bucharest") // hit
+	c.get("cluj")      // miss
+
+	if hits, misses := c.hits.Load(), c.misses.Load(); hits != 3 || misses != 3 {
+		t.Errorf("%d hits and %d misses, want 3 of each", hits, misses)
+	}
+	if got := c.hitRatio(); got != 0.5 {
+		t.Errorf("got %v, want 0.5", got)
+	}
+
+	registry := newStatsRegistry()
+	registry.watchCache(c)
+	rec := serve(registry, "/stats")
+	var snap statsSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("decoding %q: %s", rec.Body.String(), err)
+	}
+	if snap.Cache == nil || *snap.Cache != (cacheCounts{Hits: 3, Misses: 3, HitRatio: 0.5}) {
+		t.Errorf("/stats cache is %+v, want 3 hits, 3 misses and a 0.5 ratio", snap.Cache)
+	}
+}
+
+func TestWeatherCacheStaleUntilMaxStale(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := newWeatherCache(clock, time.Minute)
+	c.maxStale = time.Hour
+	c.set("bucharest", aggregate{weatherData: weatherData{Celsius: 12}})
+
+	clock.Advance(time.Minute + 59*time.Minute)
+	if _, ok := c.get("bucharest"); ok {
+		t.Error("expired entry served as fresh")
+	}
+	if e, ok := c.stale("bucharest"); !ok || e.data.Celsius != 12 {
+		t.Errorf("got %+v, %t just inside max stale, want the entry", e.data.weatherData, ok)
+	}
+
+	clock.Advance(time.Minute)
+	if _, ok := c.stale("bucharest"); ok {
+		t.Error("entry served past max stale")
+	}
+	if len(c.list()) != 0 {
+		t.Error("entry past max stale still held")
+	}
+}
+
+func TestWeatherCacheZeroMaxStaleDropsOnExpiry(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := newWeatherCache(clock, time.Minute)
+	c.set("bucharest", aggregate{})
+	clock.Advance(time.Minute)
+	if _, ok := c.stale("bucharest"); ok {
+		t.Error("expired entry kept without max stale")
+	}
+}
+
+func TestReadingCacheExpiresAfterTTL(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := newReadingCache(clock, 30*time.Second)
+	key := readingKey("openmeteo", "", 44.4268, 26.1025)
+	c.set(key, weatherData{HasReading: true, Celsius: 3})
+
+	clock.Advance(29 * time.Second)
+	if wd, ok := c.get(key); !ok || wd.Celsius != 3 {
+		t.Fatalf("got %+v, %t before the ttl is up, want the 3°C reading", wd, ok)
+	}
+
+	clock.Advance(time.Second)
+	if _, ok := c.get(key); ok {
+		t.Error("reading still served once the ttl is up")
+	}
+}
+
+func TestWeatherSourcesFromTheReadingCache(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	cached := &fakeProvider{id: "reading-cache-cached", data: reading(10)}
+	fetched := openMeteo{}
+	sc := testRoutes(cached, fetched)
+	sc.opts.readings = newReadingCache(clock, time.Minute)
+
+	// as stored by an earlier request, with that request's status and timing
+	earlier := reading(10)
+	earlier.HTTPStatus, earlier.Took = 200, 3*time.Second
+	sc.opts.readings.set(readingKey(cached.id, "", 44.4268, 26.1025), earlier)
+
+	ctx, _ := withCanned(providerFixtures)
+	resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025&sources=1"))
+	if n := cached.calls.Load(); n != 0 {
+		t.Errorf("%s asked %d times, want it answered from the cache", cached.id, n)
+	}
+	if len(resp.Sources) != 2 {
+		t.Fatalf("got sources %+v, want both providers", resp.Sources)
+	}
+	want := map[string]sourceEntry{
+		cached.id:   {Provider: cached.id, FromCache: true},
+		"openmeteo": {Provider: "openmeteo", FromCache: false, HTTPStatus: 200},
+	}
+	for _, s := range resp.Sources {
+		w := want[s.Provider]
+		if s.FromCache != w.FromCache || s.HTTPStatus != w.HTTPStatus {
+			t.Errorf("%s: from_cache %t, http_status %d, want %t, %d", s.Provider, s.FromCache, s.HTTPStatus, w.FromCache, w.HTTPStatus)
+		}
+	}
+
+	// the cached reading made no request, so it isn't timed or counted as queried
+	if _, ok := resp.Timings[cached.id]; ok || resp.Timings["openmeteo"] == "" {
+		t.Errorf("timings %v, want only openmeteo's", resp.Timings)
+	}
+	if resp.ProvidersQueried != 1 || resp.ProvidersSucceeded != 2 {
+		t.Errorf("%d queried and %d succeeded, want 1 and 2", resp.ProvidersQueried, resp.ProvidersSucceeded)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// providerClient makes every outbound request, to providers and geocoders alike,
+// unless the request's context carries another, see withClient. Until main sets
+// it up it honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+var providerClient = &http.Client{Transport: newProviderTransport(defaultTransportOptions)}
+
+type clientKey struct{}
+
+// withClient returns a context under which get sends requests with c instead of
+// providerClient, so a caller can put providers behind a transport of its own,
+// such as one answering from canned responses, without touching the rest.
+func withClient(ctx context.Context, c *http.Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// clientFor is the client get should use under ctx.
+func clientFor(ctx context.Context) *http.Client {
+	if c, _ := ctx.Value(clientKey{}).(*http.Client); c != nil {
+		return c
+	}
+	return providerClient
+}
+
+// transportOptions tune the connections kept to providers.
+type transportOptions struct {
+	proxy *url.URL // nil for the proxy the environment names
+
+	maxIdleConns        int           // idle connections kept across all hosts, 0 for no limit
+	maxIdleConnsPerHost int           // idle connections kept to any one provider
+	idleConnTimeout     time.Duration // how long an idle connection is kept, 0 for ever
+}
+
+// defaultTransportOptions keep more idle connections per host than Go's default
+// two, since every request asks each provider at about the same time.
+var defaultTransportOptions = transportOptions{
+	maxIdleConns:        100,
+	maxIdleConnsPerHost: 16,
+	idleConnTimeout:     90 * time.Second,
+}
+
+// newProviderTransport makes the transport providerClient sends through. It
+// speaks HTTP/2 to the providers that support it.
+func newProviderTransport(opts transportOptions) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	if opts.proxy != nil {
+		t.Proxy = http.ProxyURL(opts.proxy)
+	}
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConns = opts.maxIdleConns
+	t.MaxIdleConnsPerHost = opts.maxIdleConnsPerHost
+	t.IdleConnTimeout = opts.idleConnTimeout
+	return t
+}
+
+// parseProxy checks a -proxy flag value, which must be an absolute http(s) URL.
+// An empty value means no override.
+func parseProxy(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http or https URL", s)
+	}
+	return u, nil
+}
+
+// statusError is a provider or geocoder answering with a status other than 2xx.
+// Like a dropped connection it counts as the provider being unavailable, so
+// the provider is skipped rather than failing the request.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: status %d %s", ErrProviderUnavailable, e.code, http.StatusText(e.code))
+}
+
+func (e *statusError) Is(target error) bool { return target == ErrProviderUnavailable }
+
+type statusRecorderKey struct{}
+
+// withStatusRecorder returns a context under which get records the status of
+// the responses it receives, the last one winning.
+func withStatusRecorder(ctx context.Context) (context.Context, *atomic.Int32) {
+	status := &atomic.Int32{}
+	return context.WithValue(ctx, statusRecorderKey{}, status), status
+}
+
+func recordStatus(ctx context.Context, code int) {
+	if status, _ := ctx.Value(statusRecorderKey{}).(*atomic.Int32); status != nil {
+		status.Store(int32(code))
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cannedTransport is an http.RoundTripper answering from canned responses,
+// without a network, so providers can be tested against their real URLs. It
+// records the requests sent through it.
+type cannedTransport struct {
+	responses map[string]cannedResponse // by URL, or else by host
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+// cannedResponse is what a cannedTransport answers: err when it is set,
+// otherwise status and body. Either comes after delay, unless the request's
+// context is done first.
+type cannedResponse struct {
+	status int // 200 when zero
+	body   string
+	header http.Header
+	err    error
+	delay  time.Duration
+}
+
+// withCanned returns a context under which get answers from responses.
+func withCanned(responses map[string]cannedResponse) (context.Context, *cannedTransport) {
+	t := &cannedTransport{responses: responses}
+	return withClient(context.Background(), &http.Client{Transport: t}), t
+}
+
+func (t *cannedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.requests = append(t.requests, req)
+	t.mu.Unlock()
+
+	canned, ok := t.responses[req.URL.String()]
+	if !ok {
+		canned, ok = t.responses[req.URL.Host]
+	}
+	if !ok {
+		return nil, fmt.Errorf("no canned response for %s", req.URL)
+	}
+
+	if canned.delay > 0 {
+		timer := time.NewTimer(canned.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if canned.err != nil {
+		return nil, canned.err
+	}
+
+	status := canned.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := canned.header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(canned.body)),
+		ContentLength: int64(len(canned.body)),
+		Request:       req,
+	}, nil
+}
+
+// sent lists the requests sent so far.
+func (t *cannedTransport) sent() []*http.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*http.Request(nil), t.requests...)
+}
+
+func TestCannedTransport(t *testing.T) {
+	const forecast = "https://api.open-meteo.com/v1/forecast?latitude=44.4268&longitude=26.1025&current_weather=true&windspeed_unit=ms&timeformat=unixtime"
+	boom := errors.New("connection reset")
+	tests := []struct {
+		name      string
+		responses map[string]cannedResponse
+		wantTemp  float64
+		wantErr   error
+	}{
+		{"success", map[string]cannedResponse{
+			"api.open-meteo.com": {body: `{"current_weather":{"temperature":12.4}}`},
+		}, 12.4, nil},
+		{"by URL before host", map[string]cannedResponse{
+			"api.open-meteo.com": {body: `{"current_weather":{"temperature":12.4}}`},
+			forecast:             {body: `{"current_weather":{"temperature":3.5}}`},
+		}, 3.5, nil},
+		{"error", map[string]cannedResponse{"api.open-meteo.com": {err: boom}}, 0, boom},
+		{"status", map[string]cannedResponse{"api.open-meteo.com": {status: http.StatusBadGateway}}, 0, ErrProviderUnavailable},
+		{"timeout", map[string]cannedResponse{
+			"api.open-meteo.com": {body: `{"current_weather":{"temperature":12.4}}`, delay: time.Minute},
+		}, 0, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		ctx, transport := withCanned(tt.responses)
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		wd, err := openMeteo{}.temperature(ctx, "", 44.4268, 26.1025)
+		cancel()
+
+		if len(transport.sent()) != 1 || transport.sent()[0].URL.String() != forecast {
+			t.Errorf("%s: sent %v, want the one forecast request", tt.name, transport.sent())
+		}
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if wd.Celsius != tt.wantTemp {
+			t.Errorf("%s: got %.2f°C, want %.2f°C", tt.name, wd.Celsius, tt.wantTemp)
+		}
+	}
+
+	// anything without a canned response fails rather than reaching the network
+	ctx, _ := withCanned(nil)
+	_, err := openMeteo{}.temperature(ctx, "", 44.4268, 26.1025)
+	if err == nil || !strings.Contains(err.Error(), "no canned response") {
+		t.Errorf("got %v, want no canned response", err)
+	}
+}
+
+func TestProviderTransportOptions(t *testing.T) {
+	tr := newProviderTransport(transportOptions{maxIdleConns: 7, maxIdleConnsPerHost: 3, idleConnTimeout: 42 * time.Second})
+	if tr.MaxIdleConns != 7 || tr.MaxIdleConnsPerHost != 3 || tr.IdleConnTimeout != 42*time.Second || !tr.ForceAttemptHTTP2 {
+		t.Errorf("got idle %d, per host %d, timeout %s, http2 %t, want 7, 3, 42s, true", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.ForceAttemptHTTP2)
+	}
+}
+
+func TestProviderTransportReusesConnections(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"current_weather":{"temperature":3.5}}`)
+	}))
+	var mu sync.Mutex
+	conns := 0
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	ctx := withClient(context.Background(), &http.Client{Transport: newProviderTransport(defaultTransportOptions)})
+	for i := 0; i < 3; i++ {
+		resp, err := get(ctx, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v map[string]interface{}
+		err = decodeJSON(ctx, resp, &v)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("three requests in a row opened %d connections, want 1", conns)
+	}
+}
+
+func TestProviderTransportUsesTheProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy is sent the whole URL
+		proxied = append(proxied, r.URL.String())
+		fmt.Fprint(w, `{"main":{"temp":285.65},"name":"Bucharest"}`)
+	}))
+	defer proxy.Close()
+
+	u, err := parseProxy(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultTransportOptions
+	opts.proxy = u
+	ctx := withClient(context.Background(), &http.Client{Transport: newProviderTransport(opts)})
+
+	wd, err := openWeatherMap{apiKey: "key"}.temperature(ctx, "Bucharest", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.LocationName != "Bucharest" {
+		t.Errorf("got %+v, want the proxy's answer", wd)
+	}
+	if want := "http://api.openweathermap.org/data/2.5/weather?APPID=key&q=Bucharest"; len(proxied) != 1 || proxied[0] != want {
+		t.Errorf("proxy was sent %v, want %s", proxied, want)
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	if u, err := parseProxy(""); u != nil || err != nil {
+		t.Errorf("empty: got %v, %v, want no override", u, err)
+	}
+	for _, ok := range []string{"http://proxy.internal:3128", "https://proxy.internal"} {
+		if u, err := parseProxy(ok); err != nil || u.String() != ok {
+			t.Errorf("%s: got %v, %v", ok, u, err)
+		}
+	}
+	for _, bad := range []string{"proxy.internal:3128", "socks5://proxy.internal", "http://", "://x"} {
+		if _, err := parseProxy(bad); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
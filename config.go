@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// config is the part of the setup that can change without a restart. It is read
+// from the file named by -config and re-read on SIGHUP.
+type config struct {
+	Providers map[string]providerConfig `json:"providers"`
+
+	// Order lists provider names in the order they are asked. Providers it
+	// leaves out follow in their default order.
+	Order []string `json:"order"`
+}
+
+type providerConfig struct {
+	APIKey   string `json:"api_key"`
+	Disabled bool   `json:"disabled"`
+
+	// SecondaryAPIKey is tried whenever the provider rejects APIKey, so a new
+	// key can be rolled out before the old one is revoked.
+	SecondaryAPIKey string `json:"secondary_api_key"`
+
+	// RetryStatuses are the statuses the provider is asked again on, by
+	// default defaultRetryStatuses.
+	RetryStatuses []int `json:"retry_statuses"`
+
+	// Fallback names a provider asked in this one's place whenever it fails or
+	// has no reading, such as openmeteo for darksky. The fallback is built even
+	// when it is disabled, and when it is enabled it is only asked as the
+	// fallback, so its reading is never counted twice.
+	Fallback string `json:"fallback"`
+}
+
+// knownProviders lists the provider names a config may refer to, in the order
+// they are asked unless the config says otherwise.
+var knownProviders = []string{"openweathermap", "darksky", "weatherbit", "openmeteo", "metno", "file"}
+
+func isKnownProvider(name string) bool {
+	for _, known := range knownProviders {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultAPIKeys are used for providers the config doesn't give a key for.
+var defaultAPIKeys = map[string]string{
+	"openweathermap": "aa863cffe90108e0d8be0840d87de50f",
+	"darksky":        "16f1d1a16039f72b7fb8af35ae20fe80",
+}
+
+func loadConfig(path string) (config, error) {
+	var cfg config
+	if path == "" {
+		return cfg, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// parseProviderSet reads a comma-separated list of provider names, such as the
+// -required-providers flag, into a set. Unknown names are an error.
+func parseProviderSet(list string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !isKnownProvider(name) {
+			return nil, fmt.Errorf("unknown provider %q", name)
+		}
+		set[name] = true
+	}
+	return set, nil
+}
+
+// buildProviders turns a config into the providers to ask, rejecting configs
+// that name unknown providers or leave none enabled. The file provider is only
+// built when there is a stationFile, and weatherbit only when it has a key from
+// the config or WEATHERBIT_KEY. A provider that is another's fallback is only
+// asked as the fallback.
+func buildProviders(cfg config, stationFile string) (multiWeatherProvider, error) {
+	for name := range cfg.Providers {
+		if !isKnownProvider(name) {
+			return nil, fmt.Errorf("unknown provider %q", name)
+		}
+	}
+
+	order, err := providerOrder(cfg.Order)
+	if err != nil {
+		return nil, err
+	}
+
+	// providers serving as another's fallback are only asked as that
+	type namedProvider struct {
+		name string
+		p    weatherProvider
+	}
+	var built []namedProvider
+	backs := map[string]string{} // fallback name to the provider it backs up
+	for _, name := range order {
+		pc := cfg.Providers[name]
+		if pc.Disabled {
+			continue
+		}
+
+		p, err := newProvider(name, pc, stationFile)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			continue
+		}
+
+		if pc.Fallback != "" {
+			if !isKnownProvider(pc.Fallback) || pc.Fallback == name {
+				return nil, fmt.Errorf("provider %q: bad fallback %q", name, pc.Fallback)
+			}
+			backup, err := newProvider(pc.Fallback, cfg.Providers[pc.Fallback], stationFile)
+			if err != nil {
+				return nil, err
+			}
+			if backup != nil {
+				p = fallbackProvider{weatherProvider: p, backup: backup}
+				backs[pc.Fallback] = name
+			}
+		}
+		built = append(built, namedProvider{name, p})
+	}
+
+	var mw multiWeatherProvider
+	for _, b := range built {
+		if backed, ok := backs[b.name]; ok {
+			log.Printf("%s is %s's fallback, so it isn't also asked on its own", b.name, backed)
+			continue
+		}
+		mw = append(mw, b.p)
+	}
+
+	if len(mw) == 0 {
+		return nil, errors.New("no providers enabled")
+	}
+	return mw, nil
+}
+
+// newProvider builds the named provider from its config. It is nil, without an
+// error, when the provider can't be used as configured: weatherbit without a
+// key, or the file provider without a stationFile.
+func newProvider(name string, pc providerConfig, stationFile string) (weatherProvider, error) {
+	key := pc.APIKey
+	if key == "" {
+		key = defaultAPIKeys[name]
+	}
+
+	retryStatuses, err := retryStatusSet(pc.RetryStatuses)
+	if err != nil {
+		return nil, fmt.Errorf("provider %q: %w", name, err)
+	}
+
+	var p weatherProvider
+	switch name {
+	case "openweathermap":
+		p = openWeatherMap{apiKey: key, secondaryKey: pc.SecondaryAPIKey}
+	case "darksky":
+		p = darkSky{apiKey: key, secondaryKey: pc.SecondaryAPIKey}
+	case "openmeteo":
+		p = openMeteo{}
+	case "metno":
+		p = metNo{}
+	case "weatherbit":
+		if key == "" {
+			key = os.Getenv("WEATHERBIT_KEY")
+		}
+		if key == "" {
+			return nil, nil
+		}
+		p = weatherBit{apiKey: key, secondaryKey: pc.SecondaryAPIKey}
+	case "file":
+		if stationFile == "" {
+			return nil, nil
+		}
+		fp, err := loadStations(stationFile)
+		if err != nil {
+			return nil, err
+		}
+		// a local file has no statuses to retry on
+		return fp, nil
+	}
+	return retryingProvider{weatherProvider: p, statuses: retryStatuses, clock: realClock{}}, nil
+}
+
+// providerOrder puts the providers named in preferred first, then the rest of
+// knownProviders.
+func providerOrder(preferred []string) ([]string, error) {
+	listed := map[string]bool{}
+	var order []string
+	for _, name := range preferred {
+		if !isKnownProvider(name) {
+			return nil, fmt.Errorf("unknown provider %q in order", name)
+		}
+		if listed[name] {
+			return nil, fmt.Errorf("provider %q listed twice in order", name)
+		}
+		listed[name] = true
+		order = append(order, name)
+	}
+
+	for _, name := range knownProviders {
+		if !listed[name] {
+			order = append(order, name)
+		}
+	}
+	return order, nil
+}
+
+// reloadOnHangup rebuilds the providers with load, which re-reads the config and
+// station files, whenever the process gets SIGHUP. When load fails the error is
+// logged and the current providers are kept.
+func reloadOnHangup(load func() (multiWeatherProvider, error), providers *atomic.Pointer[multiWeatherProvider]) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reloadOn(hup, load, providers)
+}
+
+// reloadOn reloads the providers like reloadOnHangup for every signal received,
+// until signals is closed.
+func reloadOn(signals <-chan os.Signal, load func() (multiWeatherProvider, error), providers *atomic.Pointer[multiWeatherProvider]) {
+	for range signals {
+		mw, err := load()
+		if err != nil {
+			log.Printf("reload failed, keeping current providers: %s", err)
+			continue
+		}
+
+		providers.Store(&mw)
+		log.Printf("reloaded, %d providers enabled", len(mw))
+	}
+}
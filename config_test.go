@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// offlineConfig is a config with every network provider disabled, leaving
+// only the file provider.
+const offlineConfig = `{"providers": {
+	"openweathermap": {"disabled": true},
+	"darksky": {"disabled": true},
+	"weatherbit": {"disabled": true},
+	"openmeteo": {"disabled": true},
+	"metno": {"disabled": true}
+}}`
+
+// writeFile writes content to a file named name in a fresh directory.
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReloadSwapsProviders(t *testing.T) {
+	configPath := writeFile(t, "config.json", offlineConfig)
+	stationPath := writeFile(t, "stations.csv", "city,lat,long,temp\nBucharest,44.4268,26.1025,10\n")
+	load := func() (multiWeatherProvider, error) {
+		cfg, err := loadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		return buildProviders(cfg, stationPath)
+	}
+
+	mw, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := testRoutes()
+	sc.providers.Store(&mw)
+	h := newRouter(sc)
+	if resp := decodeWeather(t, serve(h, "/weather/Bucharest")); resp.Temp != "10.00°C" {
+		t.Fatalf("before the reload temp is %s, want 10.00°C", resp.Temp)
+	}
+
+	// reload signals one at a time, waiting for each to be handled
+	reload := func() {
+		hup := make(chan os.Signal)
+		done := make(chan struct{})
+		go func() {
+			reloadOn(hup, load, sc.providers)
+			close(done)
+		}()
+		hup <- syscall.SIGHUP
+		close(hup)
+		<-done
+	}
+
+	if err := os.WriteFile(stationPath, []byte("city,lat,long,temp\nBucharest,44.4268,26.1025,20\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reload()
+	if resp := decodeWeather(t, serve(h, "/weather/Bucharest")); resp.Temp != "20.00°C" {
+		t.Errorf("after the reload temp is %s, want the new 20.00°C", resp.Temp)
+	}
+
+	// a broken config is rejected and the providers kept
+	if err := os.WriteFile(configPath, []byte(`{"providers": {"nosuch": {}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reload()
+	if resp := decodeWeather(t, serve(h, "/weather/Bucharest")); resp.Temp != "20.00°C" {
+		t.Errorf("after a failed reload temp is %s, want the kept 20.00°C", resp.Temp)
+	}
+}
+
+func TestProviderOrder(t *testing.T) {
+	order, err := providerOrder([]string{"metno", "darksky"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"metno", "darksky", "openweathermap", "weatherbit", "openmeteo", "file"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", order, want)
+	}
+
+	for _, bad := range [][]string{{"nosuch"}, {"metno", "metno"}} {
+		if _, err := providerOrder(bad); err == nil {
+			t.Errorf("order %v accepted", bad)
+		}
+	}
+}
+
+func TestBuildProvidersInOrder(t *testing.T) {
+	t.Setenv("WEATHERBIT_KEY", "")
+	cfg := config{Order: []string{"metno", "openmeteo"}}
+	mw, err := buildProviders(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range mw {
+		names = append(names, p.name())
+	}
+	if got, want := strings.Join(names, ","), "metno,openmeteo,openweathermap,darksky"; got != want {
+		t.Errorf("built %s, want %s", got, want)
+	}
+}
+
+// weatherbitKey builds the providers from cfg and returns the key weatherbit
+// sends, empty when it wasn't built.
+func weatherbitKey(t *testing.T, cfg config) string {
+	t.Helper()
+	mw, err := buildProviders(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range mw {
+		if p.name() != "weatherbit" {
+			continue
+		}
+		ctx, transport := withCanned(providerFixtures)
+		if _, err := p.temperature(ctx, "", 44.4268, 26.1025); err != nil {
+			t.Fatal(err)
+		}
+		return transport.sent()[0].URL.Query().Get("key")
+	}
+	return ""
+}
+
+func TestBuildProvidersUsesTheEnvKey(t *testing.T) {
+	t.Setenv("WEATHERBIT_KEY", "from-env")
+	if got := weatherbitKey(t, config{}); got != "from-env" {
+		t.Errorf("weatherbit sent key %q, want the one from WEATHERBIT_KEY", got)
+	}
+
+	// a key in the config wins over the environment
+	cfg := config{Providers: map[string]providerConfig{"weatherbit": {APIKey: "from-config"}}}
+	if got := weatherbitKey(t, cfg); got != "from-config" {
+		t.Errorf("weatherbit sent key %q, want from-config", got)
+	}
+
+	t.Setenv("WEATHERBIT_KEY", "")
+	if got := weatherbitKey(t, config{}); got != "" {
+		t.Errorf("weatherbit built with key %q and no key anywhere", got)
+	}
+}
+
+func TestSequentialAsksInOrder(t *testing.T) {
+	var asked []string
+	inOrder := func(id string) *fakeProvider {
+		return &fakeProvider{id: id, answer: func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+			asked = append(asked, id)
+			return reading(10), nil
+		}}
+	}
+	mw := multiWeatherProvider{inOrder("order-c"), inOrder("order-a"), inOrder("order-b")}
+
+	if _, err := mw.temperature(context.Background(), "", 44.4268, 26.1025, aggregateOptions{sequential: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(asked, ","); got != "order-c,order-a,order-b" {
+		t.Errorf("asked %s, want order-c,order-a,order-b", got)
+	}
+}
+
+func TestSequentialAndParallelAgree(t *testing.T) {
+	// coords-only providers need the coordinates the city provider resolves
+	coordsOnly := func(id string, c float64) *fakeProvider {
+		return &fakeProvider{id: id, answer: func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+			if lat == 0 && long == 0 {
+				return weatherData{}, nil
+			}
+			return reading(c), nil
+		}}
+	}
+	byCity := reading(10)
+	byCity.Latitude, byCity.Longitude = 44.4268, 26.1025
+	mw := multiWeatherProvider{
+		&fakeProvider{id: "modes-city", data: byCity},
+		coordsOnly("modes-a", 14),
+		coordsOnly("modes-b", 18),
+		&fakeProvider{id: "modes-fails", err: ErrProviderUnavailable},
+	}
+
+	var aggs []aggregate
+	for _, sequential := range []bool{false, true} {
+		agg, err := mw.temperature(context.Background(), "Bucharest", 0, 0, aggregateOptions{sequential: sequential})
+		if err != nil {
+			t.Fatalf("sequential %t: %s", sequential, err)
+		}
+		aggs = append(aggs, agg)
+	}
+	parallel, sequential := aggs[0], aggs[1]
+	if parallel.Celsius != 14 || sequential.Celsius != parallel.Celsius {
+		t.Errorf("parallel %.2f°C, sequential %.2f°C, want both 14.00°C", parallel.Celsius, sequential.Celsius)
+	}
+	if sequential.Latitude != parallel.Latitude || sequential.Longitude != parallel.Longitude {
+		t.Errorf("parallel at %.4f, %.4f, sequential at %.4f, %.4f", parallel.Latitude, parallel.Longitude, sequential.Latitude, sequential.Longitude)
+	}
+	if len(parallel.Readings) != 3 || len(sequential.Readings) != 3 {
+		t.Errorf("parallel has %d readings, sequential %d, want 3 each", len(parallel.Readings), len(sequential.Readings))
+	}
+}
+
+func TestDedupProviders(t *testing.T) {
+	twice := &fakeProvider{id: "twice", data: reading(20)}
+	mw := multiWeatherProvider{twice, &fakeProvider{id: "once", data: reading(10)}, twice}
+
+	kept, dropped := mw.dedup()
+	if len(kept) != 2 || len(dropped) != 1 || dropped[0] != "twice" {
+		t.Fatalf("kept %d, dropped %v, want twice dropped once", len(kept), dropped)
+	}
+	agg, err := kept.temperature(context.Background(), "", 44.4268, 26.1025, aggregateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg.Celsius != 15 || twice.calls.Load() != 1 {
+		t.Errorf("got %.2f°C with twice asked %d times, want 15.00°C asking it once", agg.Celsius, twice.calls.Load())
+	}
+
+	// allowing duplicates counts the reading each time
+	agg, err = mw.temperature(context.Background(), "", 44.4268, 26.1025, aggregateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 50.0 / 3; math.Abs(agg.Celsius-want) > 1e-9 {
+		t.Errorf("without dedup got %.2f°C, want %.2f°C", agg.Celsius, want)
+	}
+}
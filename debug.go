@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// maxRawBytes is how much of each raw provider body is echoed back by ?raw=1.
+const maxRawBytes = 4 << 10
+
+type rawBodiesKey struct{}
+type rawBufferKey struct{}
+
+// rawBodies collects the response bodies providers received during one request.
+type rawBodies struct {
+	mu     sync.Mutex
+	bodies map[string]interface{}
+}
+
+// withRawBodies returns a context under which provider bodies are captured into rb.
+func withRawBodies(ctx context.Context, rb *rawBodies) context.Context {
+	return context.WithValue(ctx, rawBodiesKey{}, rb)
+}
+
+// captureRaw prepares ctx to record the body the named provider decodes. The
+// returned function stores what was recorded and must be called once the provider is done.
+func captureRaw(ctx context.Context, name string) (context.Context, func()) {
+	rb, _ := ctx.Value(rawBodiesKey{}).(*rawBodies)
+	if rb == nil {
+		return ctx, func() {}
+	}
+
+	buf := &bytes.Buffer{}
+	return context.WithValue(ctx, rawBufferKey{}, buf), func() {
+		rb.mu.Lock()
+		defer rb.mu.Unlock()
+
+		if rb.bodies == nil {
+			rb.bodies = make(map[string]interface{})
+		}
+		rb.bodies[name] = rawValue(buf.Bytes())
+	}
+}
+
+// rawBuffer is where the current provider's body should be copied, if anywhere.
+func rawBuffer(ctx context.Context) *bytes.Buffer {
+	buf, _ := ctx.Value(rawBufferKey{}).(*bytes.Buffer)
+	return buf
+}
+
+// rawValue embeds a body as JSON when it is valid and short enough, and
+// otherwise as a string cut at maxRawBytes.
+func rawValue(body []byte) interface{} {
+	if len(body) <= maxRawBytes && json.Valid(body) {
+		return json.RawMessage(append([]byte(nil), body...))
+	}
+	if len(body) > maxRawBytes {
+		body = body[:maxRawBytes]
+	}
+	return string(body)
+}
+
+// snapshot copies the captured bodies so far.
+func (rb *rawBodies) snapshot() map[string]interface{} {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	bodies := make(map[string]interface{}, len(rb.bodies))
+	for name, body := range rb.bodies {
+		bodies[name] = body
+	}
+	return bodies
+}
+
+// sampled picks roughly rate of all requests, between 0 for none and 1 for all,
+// for -debug-sample-rate.
+func sampled(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// logSample logs everything about a sampled request's fetch: the readings each
+// provider gave, the bodies they were decoded from and the outcome.
+func logSample(city string, lat float64, long float64, agg aggregate, err error, rb *rawBodies) {
+	readings := make(map[string]weatherData, len(agg.Readings))
+	for _, r := range agg.Readings {
+		readings[r.provider] = r.data
+	}
+	detail, _ := json.Marshal(map[string]interface{}{
+		"readings":  readings,
+		"bodies":    rb.snapshot(),
+		"timed_out": agg.TimedOut,
+	})
+	log.Printf("DEBUG sample city:%s, latitude:%.4f, longitude:%.4f, error:%v, detail:%s", city, lat, long, err, detail)
+}
+
+// cacheDebugHandler serves /debug/cache, the cache's entries as JSON. It is only
+// registered with -debug.
+func cacheDebugHandler(c *weatherCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(c.list())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRawValue(t *testing.T) {
+	if got, ok := rawValue([]byte(`{"temp":3.5}`)).(json.RawMessage); !ok || string(got) != `{"temp":3.5}` {
+		t.Errorf("got %#v, want the JSON body embedded as it is", got)
+	}
+
+	if got := rawValue([]byte("<html>")); got != "<html>" {
+		t.Errorf("got %#v, want a body that isn't JSON as a string", got)
+	}
+
+	long := `{"padding":"` + strings.Repeat("x", maxRawBytes) + `"}`
+	got, ok := rawValue([]byte(long)).(string)
+	if !ok || len(got) != maxRawBytes || !strings.HasPrefix(long, got) {
+		t.Errorf("got %d bytes, want the body cut at %d as a string", len(got), maxRawBytes)
+	}
+}
+
+func TestSampledHonorsTheRate(t *testing.T) {
+	const n = 100000
+	for _, rate := range []float64{0, 0.01, 0.25, 1} {
+		hits := 0
+		for i := 0; i < n; i++ {
+			if sampled(rate) {
+				hits++
+			}
+		}
+		// within five standard deviations, exact at 0 and 1
+		want := rate * n
+		if slack := 5 * math.Sqrt(n*rate*(1-rate)); math.Abs(float64(hits)-want) > slack {
+			t.Errorf("rate %v: sampled %d of %d, want about %.0f", rate, hits, n, want)
+		}
+	}
+}
+
+func TestWeatherLogsSamples(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	sc := testRoutes(&fakeProvider{id: "sampled", data: reading(10)})
+	sc.debugSampleRate = 1
+	decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest"))
+	if !strings.Contains(logged.String(), "DEBUG sample city:Bucharest") || !strings.Contains(logged.String(), `"sampled":`) {
+		t.Errorf("logged %q, want a sample with the sampled provider's reading", logged.String())
+	}
+
+	logged.Reset()
+	sc.debugSampleRate = 0
+	decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest"))
+	if strings.Contains(logged.String(), "DEBUG sample") {
+		t.Errorf("logged %q with sampling off", logged.String())
+	}
+}
+
+func TestCacheDebugShowsEntriesAndTTL(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	sc := cachedRoutes(clock, time.Minute, 0, &fakeProvider{id: "debug-cache", data: reading(10)})
+	sc.debug = true
+	h := newRouter(sc)
+
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+	clock.Advance(20 * time.Second)
+	decodeWeather(t, serve(h, "/weather/Paris"))
+	clock.Advance(10 * time.Second)
+
+	rec := serve(h, "/debug/cache")
+	var entries []cacheEntryInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("%s: %q", err, rec.Body.String())
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(entries), entries)
+	}
+	tests := []struct {
+		key     string
+		stored  time.Time
+		ttlLeft float64
+	}{
+		{"bucharest", clock.Now().Add(-30 * time.Second), 30},
+		{"paris", clock.Now().Add(-10 * time.Second), 50},
+	}
+	for i, tt := range tests {
+		e := entries[i]
+		if e.Key != tt.key || !e.Stored.Equal(tt.stored) || e.TTLLeft != tt.ttlLeft {
+			t.Errorf("entry %d is %s stored %s with %.0fs left, want %s stored %s with %.0fs left", i, e.Key, e.Stored, e.TTLLeft, tt.key, tt.stored, tt.ttlLeft)
+		}
+		if e.Reading.Celsius != 10 || len(e.Providers) != 1 || e.Providers[0] != "debug-cache" {
+			t.Errorf("%s holds %.2f°C from %v, want 10.00°C from debug-cache", e.Key, e.Reading.Celsius, e.Providers)
+		}
+	}
+
+	sc.debug = false
+	if rec := serve(newRouter(sc), "/debug/cache"); rec.Code != http.StatusNotFound {
+		t.Errorf("without -debug got %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWeatherETag(t *testing.T) {
+	p := &fakeProvider{id: "etag", data: reading(10)}
+	h := newRouter(testRoutes(p))
+
+	rec := serve(h, "/weather/Bucharest")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with ETag %q, want 200 with an ETag", rec.Code, etag)
+	}
+
+	for _, match := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		req := httptest.NewRequest(http.MethodGet, "/weather/Bucharest", nil)
+		req.Header.Set("If-None-Match", match)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() > 0 {
+			t.Errorf("If-None-Match %s: got %d with %d bytes, want an empty 304", match, rec.Code, rec.Body.Len())
+		}
+		if rec.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: 304 has ETag %q, want %q", match, rec.Header().Get("ETag"), etag)
+		}
+	}
+
+	// a changed reading is a new tag
+	p.data = reading(11)
+	req := httptest.NewRequest(http.MethodGet, "/weather/Bucharest", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("changed reading: got %d with ETag %q, want 200 with a new ETag", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestWeatherETagDiffersByEncoding(t *testing.T) {
+	h := newRouter(testRoutes(&fakeProvider{id: "etag-encoding", data: reading(10)}))
+
+	json := serve(h, "/weather/Bucharest").Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/weather/Bucharest", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if msgpack := rec.Header().Get("ETag"); msgpack == json {
+		t.Errorf("json and msgpack share the ETag %s", json)
+	}
+}
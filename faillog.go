@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// failureLog keeps a provider that is down from logging a line on every request.
+var failureLog = newFailureLimiter(realClock{}, time.Minute)
+
+// failureLimiter logs the first failure of each provider and error class, then
+// at most one line per interval saying how many were suppressed since.
+type failureLimiter struct {
+	clock    Clock
+	interval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*failureEntry
+}
+
+type failureEntry struct {
+	logged     time.Time // when a line for this key was last written
+	suppressed int       // failures not logged since then
+}
+
+func newFailureLimiter(clock Clock, interval time.Duration) *failureLimiter {
+	return &failureLimiter{clock: clock, interval: interval, entries: make(map[string]*failureEntry)}
+}
+
+// errorClass groups errors that are the same failure for rate limiting, so a
+// provider answering 503 doesn't hide it starting to answer 401.
+func errorClass(err error) string {
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		return fmt.Sprintf("status %d", se.code)
+	case errors.Is(err, errResponseTooLarge):
+		return "too_large"
+	case errors.Is(err, ErrProviderUnavailable):
+		return skipUnavailable
+	}
+	return skipFailed
+}
+
+// skipping logs that provider was skipped because of err, unless the same
+// provider failed the same way less than interval ago. An interval of 0 logs
+// every failure.
+func (l *failureLimiter) skipping(provider string, err error) {
+	if line, ok := l.line(provider, err); ok {
+		log.Print(line)
+	}
+}
+
+// line is the log line for a failure, and whether it should be written now.
+func (l *failureLimiter) line(provider string, err error) (string, bool) {
+	line := fmt.Sprintf("skipping %s: %s", provider, err)
+	if l.interval <= 0 {
+		return line, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := provider + "\x00" + errorClass(err)
+	e, ok := l.entries[key]
+	if !ok {
+		l.entries[key] = &failureEntry{logged: l.clock.Now()}
+		return line, true
+	}
+	if l.clock.Since(e.logged) < l.interval {
+		e.suppressed++
+		return "", false
+	}
+	if e.suppressed > 0 {
+		line = fmt.Sprintf("%s (%d more like it suppressed in the last %s)", line, e.suppressed, l.clock.Since(e.logged).Round(time.Second))
+	}
+	e.logged, e.suppressed = l.clock.Now(), 0
+	return line, true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailureLimiterAggregatesRepeats(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	l := newFailureLimiter(clock, time.Minute)
+	down := &statusError{code: 503}
+
+	for i := 0; i < 5; i++ {
+		l.skipping("darksky", down)
+		clock.Advance(10 * time.Second)
+	}
+	if n := strings.Count(logged.String(), "skipping darksky"); n != 1 {
+		t.Errorf("logged %d lines for 5 failures in the window, want 1: %q", n, logged.String())
+	}
+
+	// another provider, or another class of failure, is logged on its own
+	l.skipping("metno", down)
+	l.skipping("darksky", &statusError{code: 401})
+	if n := strings.Count(logged.String(), "\n"); n != 3 {
+		t.Errorf("logged %d lines, want 3: %q", n, logged.String())
+	}
+
+	// once the window is up one line covers the suppressed failures
+	logged.Reset()
+	clock.Advance(10 * time.Second)
+	l.skipping("darksky", down)
+	if got := logged.String(); strings.Count(got, "\n") != 1 || !strings.Contains(got, "(4 more like it suppressed in the last 1m0s)") {
+		t.Errorf("logged %q, want one line counting the 4 suppressed failures", got)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&statusError{code: 503}, "status 503"},
+		{errResponseTooLarge, "too_large"},
+		{ErrProviderUnavailable, skipUnavailable},
+		{errors.New("boom"), skipFailed},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFailureLimiterZeroIntervalLogsEverything(t *testing.T) {
+	l := newFailureLimiter(newFakeClock(time.Now()), 0)
+	for i := 0; i < 3; i++ {
+		if _, ok := l.line("darksky", ErrProviderUnavailable); !ok {
+			t.Errorf("failure %d suppressed with no interval", i)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// fallbackProvider asks backup whenever the provider it wraps fails or has no
+// reading, and answers with backup's reading under the wrapped provider's name,
+// with Source naming backup. When backup does no better the wrapped provider's
+// own answer is returned.
+type fallbackProvider struct {
+	weatherProvider
+	backup weatherProvider
+}
+
+func (p fallbackProvider) hasHistory() bool { return hasHistory(p.weatherProvider) }
+
+func (p fallbackProvider) temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+	wd, err := p.weatherProvider.temperature(ctx, city, lat, long)
+	if err == nil && wd.valid() {
+		return wd, nil
+	}
+	if ctx.Err() != nil {
+		return wd, err
+	}
+	if _, past := historyTime(ctx); past && !hasHistory(p.backup) {
+		return wd, err
+	}
+
+	backupData, backupErr := p.backup.temperature(ctx, city, lat, long)
+	if backupErr != nil || !backupData.valid() {
+		return wd, err
+	}
+	log.Printf("%s had no reading (%v), answered with %s", p.name(), err, p.backup.name())
+	// a backup that is itself a fallback has already named its own source
+	if backupData.Source == "" {
+		backupData.Source = p.backup.name()
+	}
+	return backupData, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFallbackAnswersWithTheBackup(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.darksky.net":    {status: 500},
+		"api.open-meteo.com": providerFixtures["api.open-meteo.com"],
+	})
+	p := fallbackProvider{weatherProvider: darkSky{apiKey: "key"}, backup: openMeteo{}}
+	if p.name() != "darksky" {
+		t.Errorf("named %q, want darksky", p.name())
+	}
+	wd, err := p.temperature(ctx, "", 44.4268, 26.1025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.Celsius != 12.4 || wd.Source != "openmeteo" {
+		t.Errorf("got %.2f°C from %q, want open-meteo's 12.40°C", wd.Celsius, wd.Source)
+	}
+
+	// the backup isn't asked while the wrapped provider answers
+	backup := &fakeProvider{id: "fallback-backup", data: reading(20)}
+	p = fallbackProvider{weatherProvider: &fakeProvider{id: "fallback-ok", data: reading(10)}, backup: backup}
+	if wd, err := p.temperature(context.Background(), "", 44.4268, 26.1025); err != nil || wd.Celsius != 10 || wd.Source != "" || backup.calls.Load() != 0 {
+		t.Errorf("got %.2f°C from %q, %v with the backup asked %d times, want 10.00°C without it", wd.Celsius, wd.Source, err, backup.calls.Load())
+	}
+
+	// when the backup fails too the wrapped provider's error is returned
+	boom := errors.New("boom")
+	p = fallbackProvider{weatherProvider: &fakeProvider{id: "fallback-fails", err: boom}, backup: &fakeProvider{id: "fallback-empty"}}
+	if _, err := p.temperature(context.Background(), "", 44.4268, 26.1025); !errors.Is(err, boom) {
+		t.Errorf("got %v, want the wrapped provider's error", err)
+	}
+}
+
+func TestEnabledFallbackIsntCountedTwice(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	t.Setenv("WEATHERBIT_KEY", "")
+
+	cfg := config{Providers: map[string]providerConfig{"darksky": {Fallback: "openmeteo"}}}
+	mw, err := buildProviders(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range mw {
+		names = append(names, p.name())
+	}
+	if got, want := strings.Join(names, ","), "openweathermap,darksky,metno"; got != want {
+		t.Errorf("built %s, want %s without openmeteo on its own", got, want)
+	}
+	if !strings.Contains(logged.String(), "openmeteo is darksky's fallback") {
+		t.Errorf("logged %q, want openmeteo's leaving out explained", logged.String())
+	}
+	if _, ok := mw[1].(fallbackProvider); !ok {
+		t.Errorf("darksky is %T, want it wrapped with its fallback", mw[1])
+	}
+}
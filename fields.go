@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// responseFields are the top-level field names of weatherResponse, which are
+// all ?fields= and ?exclude_fields= may name.
+var responseFields = jsonFieldNames(reflect.TypeOf(weatherResponse{}))
+
+// jsonFieldNames lists the names encoding/json gives the fields of struct t.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, ok := jsonField(t.Field(i)); ok {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// jsonField is the name encoding/json gives f and whether it is omitempty. ok is
+// false for fields it leaves out.
+func jsonField(f reflect.StructField) (name string, omitEmpty bool, ok bool) {
+	tag := f.Tag.Get("json")
+	if !f.IsExported() || tag == "-" {
+		return "", false, false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, strings.Contains(opts, "omitempty"), true
+}
+
+// fieldFilter trims a response to the fields a client asked for. The zero
+// value keeps every field.
+type fieldFilter struct {
+	only map[string]bool // when set, the only fields kept
+	drop map[string]bool
+}
+
+// requestedFields reads ?fields=city,temp, the only fields to return, or
+// ?exclude_fields=took, fields to leave out. Asking for both, or naming a field
+// the response doesn't have, is an error.
+func requestedFields(r *http.Request) (fieldFilter, error) {
+	q := r.URL.Query()
+	_, hasOnly := q["fields"]
+	_, hasDrop := q["exclude_fields"]
+	if hasOnly && hasDrop {
+		return fieldFilter{}, errors.New("fields and exclude_fields can't be given together")
+	}
+
+	var f fieldFilter
+	var err error
+	if hasOnly {
+		if f.only, err = parseFieldList("fields", q.Get("fields")); err != nil {
+			return fieldFilter{}, err
+		}
+	}
+	if hasDrop {
+		if f.drop, err = parseFieldList("exclude_fields", q.Get("exclude_fields")); err != nil {
+			return fieldFilter{}, err
+		}
+	}
+	return f, nil
+}
+
+// parseFieldList reads a comma-separated list of response field names.
+func parseFieldList(param string, list string) (map[string]bool, error) {
+	names := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !responseFields[name] {
+			return nil, fmt.Errorf("%s: unknown field %q", param, name)
+		}
+		names[name] = true
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s must name at least one field", param)
+	}
+	return names, nil
+}
+
+func (f fieldFilter) empty() bool {
+	return f.only == nil && f.drop == nil
+}
+
+// tag tells responses trimmed in different ways apart in their ETag.
+func (f fieldFilter) tag() string {
+	if f.empty() {
+		return ""
+	}
+	var names []string
+	for name := range f.only {
+		names = append(names, "+"+name)
+	}
+	for name := range f.drop {
+		names = append(names, "-"+name)
+	}
+	sort.Strings(names)
+	sum := sha256.Sum256([]byte(strings.Join(names, ",")))
+	return hex.EncodeToString(sum[:4])
+}
+
+// apply returns resp, trimmed to the requested fields, ready to be encoded.
+func (f fieldFilter) apply(resp weatherResponse) (interface{}, error) {
+	if f.empty() {
+		return resp, nil
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for name := range fields {
+		if (f.only != nil && !f.only[name]) || f.drop[name] {
+			delete(fields, name)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"testing"
+)
+
+// responseKeys decodes a JSON response and lists its top-level fields.
+func responseKeys(t *testing.T, body []byte) []string {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestWeatherOnlyRequestedFields(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "fields-only", data: reading(10)})
+	rec := serve(newRouter(sc), "/weather/Bucharest?fields=city,temp")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", rec.Code)
+	}
+	keys := responseKeys(t, rec.Body.Bytes())
+	if len(keys) != 2 || keys[0] != "city" || keys[1] != "temp" {
+		t.Errorf("got fields %v, want only city and temp", keys)
+	}
+}
+
+func TestWeatherExcludedFields(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "fields-excluded", data: reading(10)})
+	full := responseKeys(t, serve(newRouter(sc), "/weather/Bucharest").Body.Bytes())
+	rec := serve(newRouter(sc), "/weather/Bucharest?exclude_fields=took,%20lat")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", rec.Code)
+	}
+	keys := responseKeys(t, rec.Body.Bytes())
+	for _, k := range keys {
+		if k == "took" || k == "lat" {
+			t.Errorf("%s returned though excluded", k)
+		}
+	}
+	if len(keys) != len(full)-2 {
+		t.Errorf("got fields %v, want those of %v but took and lat", keys, full)
+	}
+}
+
+func TestWeatherFieldsValidated(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "fields-invalid", data: reading(10)})
+	for _, target := range []string{
+		"/weather/Bucharest?fields=city,humidity",
+		"/weather/Bucharest?exclude_fields=City",
+		"/weather/Bucharest?fields=",
+		"/weather/Bucharest?fields=city&exclude_fields=took",
+	} {
+		if rec := serve(newRouter(sc), target); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+func TestWeatherFieldsChangeTheETag(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "fields-etag", data: reading(10)})
+	tags := map[string]string{}
+	for _, target := range []string{
+		"/weather/Bucharest",
+		"/weather/Bucharest?fields=city",
+		"/weather/Bucharest?fields=temp",
+		"/weather/Bucharest?exclude_fields=city",
+	} {
+		etag := serve(newRouter(sc), target).Header().Get("ETag")
+		if other, ok := tags[etag]; ok {
+			t.Errorf("%s and %s share the ETag %s", target, other, etag)
+		}
+		tags[etag] = target
+	}
+}
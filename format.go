@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TemperatureFormatter renders a temperature, already converted to the unit
+// with the given code from temperatureUnits, for display.
+type TemperatureFormatter interface {
+	FormatTemperature(value float64, unit string) string
+}
+
+// suffixFormatter prints two decimals, rounded with displayRounding, followed by
+// a per-unit suffix.
+type suffixFormatter struct {
+	suffixes map[string]string
+	decimal  string // the decimal separator, "." when empty
+}
+
+func (f suffixFormatter) FormatTemperature(value float64, unit string) string {
+	s := fmt.Sprintf("%.2f", roundDecimal(value, 2, displayRounding))
+	if f.decimal != "" && f.decimal != "." {
+		s = strings.Replace(s, ".", f.decimal, 1)
+	}
+	return s + f.suffixes[unit]
+}
+
+// temperatureUnit is a unit temperatures can be shown in.
+type temperatureUnit struct {
+	symbol      string // written after the number, e.g. "°C"
+	asciiSymbol string // the symbol without a degree sign, for -temp-format=ascii
+	fromCelsius func(c float64) (float64, error)
+}
+
+// temperatureUnits are the units by the code ?units= and the formatters use.
+// Adding a unit takes an entry here and a field in weatherResponse.
+var temperatureUnits = map[string]temperatureUnit{
+	"c": {symbol: "°C", asciiSymbol: "C", fromCelsius: func(c float64) (float64, error) { return c, nil }},
+	"f": {symbol: "°F", asciiSymbol: "F", fromCelsius: celsiusToFahrenheit},
+	"k": {symbol: "K", asciiSymbol: "K", fromCelsius: celsiusToKelvin},
+	"r": {symbol: "°R", asciiSymbol: "R", fromCelsius: celsiusToRankine},
+}
+
+// unitCodes lists the codes of temperatureUnits in order, for messages.
+func unitCodes() string {
+	codes := make([]string, 0, len(temperatureUnits))
+	for code := range temperatureUnits {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return strings.Join(codes, ", ")
+}
+
+// unitSymbols maps each unit code to its symbol, or its ascii symbol.
+func unitSymbols(ascii bool) map[string]string {
+	symbols := make(map[string]string, len(temperatureUnits))
+	for code, u := range temperatureUnits {
+		symbols[code] = u.symbol
+		if ascii {
+			symbols[code] = u.asciiSymbol
+		}
+	}
+	return symbols
+}
+
+var (
+	degreeSuffixes = unitSymbols(false)
+	asciiSuffixes  = unitSymbols(true)
+)
+
+// temperatureFormatters are the formatters -temp-format can pick.
+var temperatureFormatters = map[string]TemperatureFormatter{
+	"degree": suffixFormatter{suffixes: degreeSuffixes},               // 12.34°C
+	"ascii":  suffixFormatter{suffixes: asciiSuffixes},                // 12.34C
+	"comma":  suffixFormatter{suffixes: degreeSuffixes, decimal: ","}, // 12,34°C
+}
+
+// displayFormatter renders every temperature in responses.
+var displayFormatter = temperatureFormatters["degree"]
+
+// formatTemperature converts a celsius reading to the given unit code and
+// renders it with displayFormatter, e.g. "12.34°C".
+func formatTemperature(unit string, c float64) (string, error) {
+	u, ok := temperatureUnits[unit]
+	if !ok {
+		return "", fmt.Errorf("formatTemperature: unknown unit %q", unit)
+	}
+	value, err := u.fromCelsius(c)
+	if err != nil {
+		return "", err
+	}
+	return displayFormatter.FormatTemperature(value, unit), nil
+}
+
+// measurementSystem picks the units for every measurement in a response.
+type measurementSystem string
+
+const (
+	metric   measurementSystem = "metric"   // °C, m/s, hPa
+	imperial measurementSystem = "imperial" // °F, mph, inHg
+)
+
+// tempUnit is the unit code temperatures are shown in.
+func (sys measurementSystem) tempUnit() string {
+	if sys == imperial {
+		return "f"
+	}
+	return "c"
+}
+
+// measurements are the display strings for a reading in one system.
+type measurements struct {
+	temp      string
+	wind      string
+	pressure  string // empty when unknown
+	feelsLike string // empty when unknown
+}
+
+// formatMeasurements renders the measurements in wd in the units of sys.
+func formatMeasurements(sys measurementSystem, wd weatherData) (measurements, error) {
+	var m measurements
+
+	temp, err := formatTemperature(sys.tempUnit(), wd.Celsius)
+	if err != nil {
+		return m, err
+	}
+	m.temp = temp
+
+	if wd.HasFeelsLike {
+		if m.feelsLike, err = formatTemperature(sys.tempUnit(), wd.FeelsLike); err != nil {
+			return m, err
+		}
+	}
+
+	if sys == imperial {
+		mph, err := metresPerSecondToMph(wd.WindSpeed)
+		if err != nil {
+			return m, err
+		}
+		m.wind = fmt.Sprintf("%.1f mph", mph)
+	} else {
+		m.wind = fmt.Sprintf("%.1f m/s", wd.WindSpeed)
+	}
+
+	if wd.Pressure > 0 {
+		if sys == imperial {
+			inHg, err := hectopascalsToInchesOfMercury(wd.Pressure)
+			if err != nil {
+				return m, err
+			}
+			m.pressure = fmt.Sprintf("%.2f inHg", inHg)
+		} else {
+			m.pressure = fmt.Sprintf("%.0f hPa", wd.Pressure)
+		}
+	}
+
+	return m, nil
+}
+
+// coordFormat picks how coordinates are written in responses.
+type coordFormat string
+
+const (
+	signedDecimal coordFormat = "decimal"    // 41.6267, -93.7122
+	hemisphere    coordFormat = "hemisphere" // 41.6267° N, 93.7122° W
+)
+
+// formatLatitude renders a latitude with the given number of decimals.
+func formatLatitude(lat float64, precision int, format coordFormat) string {
+	return formatCoord(lat, precision, format, "N", "S")
+}
+
+// formatLongitude renders a longitude with the given number of decimals.
+func formatLongitude(long float64, precision int, format coordFormat) string {
+	return formatCoord(long, precision, format, "E", "W")
+}
+
+// formatCoord writes a coordinate as a signed decimal or with a hemisphere
+// suffix. Anything that rounds to zero counts as north or east, so there is
+// never a "-0" or a "0° S".
+func formatCoord(v float64, precision int, format coordFormat, positive string, negative string) string {
+	scale := math.Pow(10, float64(precision))
+	if math.Round(v*scale) == 0 {
+		v = 0
+	}
+
+	if format != hemisphere {
+		return fmt.Sprintf("%.*f", precision, v)
+	}
+
+	suffix := positive
+	if v < 0 {
+		suffix = negative
+	}
+	return fmt.Sprintf("%.*f° %s", precision, math.Abs(v), suffix)
+}
+
+// formatTime renders t as RFC 3339, or nothing when it is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// formatTimings renders per-provider durations the way took is rendered.
+func formatTimings(timings map[string]time.Duration) map[string]string {
+	if len(timings) == 0 {
+		return nil
+	}
+	formatted := make(map[string]string, len(timings))
+	for name, d := range timings {
+		formatted[name] = d.String()
+	}
+	return formatted
+}
+
+// ageSeconds renders a data age as whole seconds, never negative even when a
+// provider's clock runs ahead of ours.
+func ageSeconds(d time.Duration) int64 {
+	if d < 0 {
+		return 0
+	}
+	return int64(d / time.Second)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAgeSecondsNeverNegative(t *testing.T) {
+	if got := ageSeconds(-5 * time.Second); got != 0 {
+		t.Errorf("got %d for a provider clock running ahead, want 0", got)
+	}
+	if got := ageSeconds(90*time.Second + 900*time.Millisecond); got != 90 {
+		t.Errorf("got %d, want 90", got)
+	}
+}
+
+func TestFormatMeasurements(t *testing.T) {
+	wd := weatherData{HasReading: true, Celsius: 20, WindSpeed: 10, Pressure: 1013.25, FeelsLike: 18, HasFeelsLike: true}
+	tests := []struct {
+		sys  measurementSystem
+		want measurements
+	}{
+		{metric, measurements{temp: "20.00°C", wind: "10.0 m/s", pressure: "1013 hPa", feelsLike: "18.00°C"}},
+		{imperial, measurements{temp: "68.00°F", wind: "22.4 mph", pressure: "29.92 inHg", feelsLike: "64.40°F"}},
+	}
+	for _, tt := range tests {
+		got, err := formatMeasurements(tt.sys, wd)
+		if err != nil {
+			t.Errorf("%s: %s", tt.sys, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.sys, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMeasurementsWithoutPressure(t *testing.T) {
+	got, err := formatMeasurements(imperial, weatherData{HasReading: true, Celsius: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.pressure != "" {
+		t.Errorf("pressure is %q for a reading without one, want none", got.pressure)
+	}
+}
+
+func TestTemperatureFormatters(t *testing.T) {
+	tests := []struct {
+		formatter string
+		value     float64
+		unit      string
+		want      string
+	}{
+		{"degree", 12.344, "c", "12.34°C"},
+		{"degree", -3.5, "f", "-3.50°F"},
+		{"degree", 285.49, "k", "285.49K"},
+		{"ascii", 12.344, "c", "12.34C"},
+		{"ascii", 54.2, "f", "54.20F"},
+		{"ascii", 500, "r", "500.00R"},
+		{"comma", 12.344, "c", "12,34°C"},
+		{"comma", -0.5, "k", "-0,50K"},
+	}
+	for _, tt := range tests {
+		if got := temperatureFormatters[tt.formatter].FormatTemperature(tt.value, tt.unit); got != tt.want {
+			t.Errorf("%s formatter: got %q for %v%s, want %q", tt.formatter, got, tt.value, tt.unit, tt.want)
+		}
+	}
+}
+
+func TestEachUnitFormatsWithItsSymbol(t *testing.T) {
+	saved := displayFormatter
+	t.Cleanup(func() { displayFormatter = saved })
+	displayFormatter = temperatureFormatters["degree"]
+
+	tests := []struct {
+		unit  string
+		want  string
+		ascii string
+	}{
+		{"c", "10.00°C", "C"},
+		{"f", "50.00°F", "F"},
+		{"k", "283.15K", "K"},
+		{"r", "509.67°R", "R"},
+	}
+	if len(tests) != len(temperatureUnits) {
+		t.Fatalf("testing %d units, want all %d", len(tests), len(temperatureUnits))
+	}
+	asciiSymbols := unitSymbols(true)
+	for _, tt := range tests {
+		if got, err := formatTemperature(tt.unit, 10); err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.unit, got, err, tt.want)
+		}
+		if got := asciiSymbols[tt.unit]; got != tt.ascii {
+			t.Errorf("%s: ascii symbol %q, want %q", tt.unit, got, tt.ascii)
+		}
+	}
+}
+
+func TestFormatTemperatureUsesDisplayFormatter(t *testing.T) {
+	saved := displayFormatter
+	t.Cleanup(func() { displayFormatter = saved })
+
+	displayFormatter = temperatureFormatters["ascii"]
+	if got, err := formatTemperature("f", 20); err != nil || got != "68.00F" {
+		t.Errorf("got %q, %v, want 68.00F", got, err)
+	}
+	displayFormatter = temperatureFormatters["comma"]
+	if got, err := formatTemperature("c", 20.5); err != nil || got != "20,50°C" {
+		t.Errorf("got %q, %v, want 20,50°C", got, err)
+	}
+	if _, err := formatTemperature("x", 20); err == nil {
+		t.Error("unknown unit formatted")
+	}
+}
+
+func TestFormatCoordinates(t *testing.T) {
+	tests := []struct {
+		lat, long         float64
+		precision         int
+		format            coordFormat
+		wantLat, wantLong string
+	}{
+		{41.6267, -93.7122, 4, hemisphere, "41.6267° N", "93.7122° W"},
+		{-33.8688, 151.2093, 4, hemisphere, "33.8688° S", "151.2093° E"},
+		{-33.8688, -70.6693, 2, hemisphere, "33.87° S", "70.67° W"},
+		{0, 0, 4, hemisphere, "0.0000° N", "0.0000° E"},
+		{-0.00004, -0.00004, 4, hemisphere, "0.0000° N", "0.0000° E"}, // rounds to zero
+		{-0.00005, -0.00005, 4, hemisphere, "0.0001° S", "0.0001° W"},
+		{-0.004, 0.004, 2, hemisphere, "0.00° N", "0.00° E"},
+		{41.6267, -93.7122, 4, signedDecimal, "41.6267", "-93.7122"},
+		{-0.00004, -0.00004, 4, signedDecimal, "0.0000", "0.0000"},
+	}
+	for _, tt := range tests {
+		lat := formatLatitude(tt.lat, tt.precision, tt.format)
+		long := formatLongitude(tt.long, tt.precision, tt.format)
+		if lat != tt.wantLat || long != tt.wantLong {
+			t.Errorf("%v, %v as %s: got %q, %q, want %q, %q", tt.lat, tt.long, tt.format, lat, long, tt.wantLat, tt.wantLong)
+		}
+	}
+}
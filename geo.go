@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type coordinates struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"long"`
+}
+
+// coordKey identifies coordinates rounded to 4 decimals, about 11m, for use as a map key.
+type coordKey struct {
+	lat  int64
+	long int64
+}
+
+func roundedCoords(lat float64, long float64) coordKey {
+	return coordKey{lat: int64(math.Round(lat * 1e4)), long: int64(math.Round(long * 1e4))}
+}
+
+// echoesRequest reports whether the coordinates a provider gave back are just
+// the ones it was asked about, to 4 decimals, rather than its own station's or
+// grid cell's. Such coordinates say nothing about where the reading is from.
+func echoesRequest(lat float64, long float64, reportedLat float64, reportedLong float64) bool {
+	return (lat != 0.0 || long != 0.0) && roundedCoords(lat, long) == roundedCoords(reportedLat, reportedLong)
+}
+
+// parseCoords reads coordinates written as "lat,long", e.g. "44.4268,26.1025".
+func parseCoords(s string) (coordinates, error) {
+	latValue, longValue, ok := strings.Cut(s, ",")
+	if !ok {
+		return coordinates{}, fmt.Errorf("coordinates %q should look like lat,long", s)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latValue), 64)
+	if err != nil {
+		return coordinates{}, fmt.Errorf("lat %q is not a number", latValue)
+	}
+	long, err := strconv.ParseFloat(strings.TrimSpace(longValue), 64)
+	if err != nil {
+		return coordinates{}, fmt.Errorf("long %q is not a number", longValue)
+	}
+
+	if err := validateCoords(lat, long); err != nil {
+		return coordinates{}, err
+	}
+	return coordinates{Latitude: lat, Longitude: long}, nil
+}
+
+// earthRadiusKm is the mean radius of the Earth.
+const earthRadiusKm = 6371.0
+
+// haversineKm is the great-circle distance between two points given in degrees.
+func haversineKm(lat1 float64, long1 float64, lat2 float64, long2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLong := (long2 - long1) * rad
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// validateCoords rejects coordinates off the globe. 0,0 is rejected too, since
+// it is what an unset lat/long looks like everywhere else.
+func validateCoords(lat float64, long float64) error {
+	if lat == 0.0 && long == 0.0 {
+		return errors.New("lat and long are required")
+	}
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("lat %.4f out of range -90 to 90", lat)
+	}
+	if long < -180 || long > 180 {
+		return fmt.Errorf("long %.4f out of range -180 to 180", long)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHaversineKm(t *testing.T) {
+	tests := []struct {
+		name                     string
+		lat1, long1, lat2, long2 float64
+		want                     float64
+	}{
+		{"London to Paris", 51.5074, -0.1278, 48.8566, 2.3522, 343.5},
+		{"New York to Los Angeles", 40.7128, -74.0060, 34.0522, -118.2437, 3935.7},
+		{"Bucharest to itself", 44.4268, 26.1025, 44.4268, 26.1025, 0},
+		{"pole to pole", 90, 0, -90, 0, math.Pi * earthRadiusKm},
+	}
+	for _, tt := range tests {
+		got := haversineKm(tt.lat1, tt.long1, tt.lat2, tt.long2)
+		if math.Abs(got-tt.want) > tt.want*0.005 {
+			t.Errorf("%s: got %.1f km, want about %.1f km", tt.name, got, tt.want)
+		}
+		if back := haversineKm(tt.lat2, tt.long2, tt.lat1, tt.long1); math.Abs(back-got) > 1e-9 {
+			t.Errorf("%s: %.1f km one way but %.1f km back", tt.name, got, back)
+		}
+	}
+}
+
+func TestWeatherCoordDelta(t *testing.T) {
+	station := reading(10)
+	station.Latitude, station.Longitude = 44.5, 26.1025
+	sc := testRoutes(&fakeProvider{id: "coord-delta", data: station})
+
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/?lat=44.4268&long=26.1025"))
+	if resp.CoordDeltaKm == nil || math.Abs(*resp.CoordDeltaKm-8.15) > 0.01 {
+		t.Errorf("coord_delta_km is %v, want 8.15", resp.CoordDeltaKm)
+	}
+
+	resp = decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest"))
+	if resp.CoordDeltaKm != nil {
+		t.Errorf("coord_delta_km is %.2f without caller coordinates, want none", *resp.CoordDeltaKm)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// errNotGeocoded means a geocoder found no place by the given name.
+var errNotGeocoded = errors.New("no coordinates found")
+
+// ErrGeocodeTimeout means the geocoder didn't answer within its own timeout,
+// as opposed to the request as a whole running out of time.
+var ErrGeocodeTimeout = errors.New("geocoding timed out")
+
+// geocodeError is a failure to geocode a city, which is the geocoder's fault
+// rather than any weather provider's.
+type geocodeError struct {
+	city string
+	err  error
+}
+
+func (e *geocodeError) Error() string { return fmt.Sprintf("geocoding %s: %s", e.city, e.err) }
+func (e *geocodeError) Unwrap() error { return e.err }
+
+// Geocoder resolves a city name to coordinates, so that every provider can be
+// asked with them at once.
+type Geocoder interface {
+	Geocode(ctx context.Context, city string) (lat float64, long float64, err error)
+}
+
+// geocoderByName returns one of the built-in geocoders, or nil for "none".
+func geocoderByName(name string) (Geocoder, error) {
+	switch name {
+	case "none":
+		return nil, nil
+	case "nominatim":
+		return nominatim{}, nil
+	case "openmeteo":
+		return openMeteoGeocoder{}, nil
+	}
+	return nil, fmt.Errorf("unknown geocoder %q, expected none, nominatim or openmeteo", name)
+}
+
+// nominatim uses OpenStreetMap's Nominatim search.
+type nominatim struct{}
+
+func (g nominatim) Geocode(ctx context.Context, city string) (float64, float64, error) {
+	u := fmt.Sprintf("https://nominatim.openstreetmap.org/search?format=json&limit=1&q=%s", url.QueryEscape(city))
+	resp, err := get(ctx, u)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	// nominatim sends the coordinates as strings
+	var places []struct {
+		Latitude  flexFloat `json:"lat"`
+		Longitude flexFloat `json:"lon"`
+	}
+	if err := decodeJSON(ctx, resp, &places); err != nil {
+		return 0, 0, err
+	}
+
+	if len(places) == 0 {
+		return 0, 0, fmt.Errorf("%w for %s", errNotGeocoded, city)
+	}
+	return float64(places[0].Latitude), float64(places[0].Longitude), nil
+}
+
+// openMeteoGeocoder uses the Open-Meteo geocoding API.
+type openMeteoGeocoder struct{}
+
+func (g openMeteoGeocoder) Geocode(ctx context.Context, city string) (float64, float64, error) {
+	u := fmt.Sprintf("https://geocoding-api.open-meteo.com/v1/search?count=1&name=%s", url.QueryEscape(city))
+	resp, err := get(ctx, u)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	var d struct {
+		Results []struct {
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+		} `json:"results"`
+	}
+	if err := decodeJSON(ctx, resp, &d); err != nil {
+		return 0, 0, err
+	}
+
+	if len(d.Results) == 0 {
+		return 0, 0, fmt.Errorf("%w for %s", errNotGeocoded, city)
+	}
+	return d.Results[0].Latitude, d.Results[0].Longitude, nil
+}
+
+// ReverseGeocoder names the place nearest to a pair of coordinates.
+type ReverseGeocoder interface {
+	ReverseGeocode(ctx context.Context, lat float64, long float64) (string, error)
+}
+
+// openWeatherMapReverse uses OpenWeatherMap's reverse geocoding API.
+type openWeatherMapReverse struct {
+	apiKey string
+}
+
+func (g openWeatherMapReverse) ReverseGeocode(ctx context.Context, lat float64, long float64) (string, error) {
+	u := fmt.Sprintf("http://api.openweathermap.org/geo/1.0/reverse?lat=%.4f&lon=%.4f&limit=1&appid=%s", lat, long, g.apiKey)
+	resp, err := get(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var places []struct {
+		Name string `json:"name"`
+	}
+	if err := decodeJSON(ctx, resp, &places); err != nil {
+		return "", err
+	}
+
+	if len(places) == 0 || places[0].Name == "" {
+		return "", fmt.Errorf("no place found near %.4f, %.4f", lat, long)
+	}
+	return places[0].Name, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeGeocoder resolves every city to lat and long, or fails with err, after
+// delay unless ctx is done first. It counts the times it was asked.
+type fakeGeocoder struct {
+	lat, long float64
+	err       error
+	delay     time.Duration
+
+	calls atomic.Int32
+}
+
+func (g *fakeGeocoder) Geocode(ctx context.Context, city string) (float64, float64, error) {
+	g.calls.Add(1)
+	if g.delay > 0 {
+		select {
+		case <-time.After(g.delay):
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		}
+	}
+	if g.err != nil {
+		return 0, 0, g.err
+	}
+	return g.lat, g.long, nil
+}
+
+// askedAt is a provider recording the coordinates it is asked about.
+func askedAt(id string, got *coordinates) *fakeProvider {
+	return &fakeProvider{id: id, answer: func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+		*got = coordinates{Latitude: lat, Longitude: long}
+		return reading(10), nil
+	}}
+}
+
+func TestGeocoderResolvesBeforeProviders(t *testing.T) {
+	var got coordinates
+	g := &fakeGeocoder{lat: 44.4268, long: 26.1025}
+	mw := multiWeatherProvider{askedAt("geocoded", &got)}
+
+	if _, err := mw.temperature(context.Background(), "Bucharest", 0, 0, aggregateOptions{geocoder: g}); err != nil {
+		t.Fatal(err)
+	}
+	if got != (coordinates{Latitude: 44.4268, Longitude: 26.1025}) {
+		t.Errorf("provider asked at %+v, want the geocoded 44.4268, 26.1025", got)
+	}
+
+	// coordinates given by the caller aren't geocoded again
+	if _, err := mw.temperature(context.Background(), "Bucharest", 45.7489, 21.2087, aggregateOptions{geocoder: g}); err != nil {
+		t.Fatal(err)
+	}
+	if g.calls.Load() != 1 || got != (coordinates{Latitude: 45.7489, Longitude: 21.2087}) {
+		t.Errorf("geocoded %d times and asked at %+v, want once and the caller's coordinates", g.calls.Load(), got)
+	}
+}
+
+func TestGeocoderFailureIsAGeocodeError(t *testing.T) {
+	p := &fakeProvider{id: "geocode-failed", data: reading(10)}
+	mw := multiWeatherProvider{p}
+	_, err := mw.temperature(context.Background(), "Atlantis", 0, 0, aggregateOptions{geocoder: &fakeGeocoder{err: errNotGeocoded}})
+
+	var gerr *geocodeError
+	if !errors.As(err, &gerr) || !errors.Is(err, errNotGeocoded) {
+		t.Errorf("got %v, want a geocodeError wrapping errNotGeocoded", err)
+	}
+	if p.calls.Load() != 0 {
+		t.Error("provider asked without coordinates")
+	}
+}
+
+func TestGeocoders(t *testing.T) {
+	tests := []struct {
+		name string
+		g    Geocoder
+		url  string
+		body string
+	}{
+		{
+			"nominatim", nominatim{},
+			"https://nominatim.openstreetmap.org/search?format=json&limit=1&q=S%C3%A3o+Paulo",
+			`[{"place_id":1,"lat":"-23.5506507","lon":"-46.6333824","display_name":"São Paulo, Brasil"}]`,
+		},
+		{
+			"openmeteo", openMeteoGeocoder{},
+			"https://geocoding-api.open-meteo.com/v1/search?count=1&name=S%C3%A3o+Paulo",
+			`{"results":[{"id":3448439,"name":"São Paulo","latitude":-23.5506507,"longitude":-46.6333824,"country_code":"BR"}],"generationtime_ms":0.5}`,
+		},
+	}
+	for _, tt := range tests {
+		ctx, transport := withCanned(map[string]cannedResponse{tt.url: {body: tt.body}})
+		lat, long, err := tt.g.Geocode(ctx, "São Paulo")
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if lat != -23.5506507 || long != -46.6333824 {
+			t.Errorf("%s: got %v, %v, want -23.5506507, -46.6333824", tt.name, lat, long)
+		}
+		if sent := transport.sent(); len(sent) != 1 || sent[0].URL.String() != tt.url {
+			t.Errorf("%s: sent %d requests, want one to %s", tt.name, len(sent), tt.url)
+		}
+	}
+}
+
+func TestGeocodersFindNothing(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"nominatim.openstreetmap.org":  {body: `[]`},
+		"geocoding-api.open-meteo.com": {body: `{"generationtime_ms":0.5}`},
+	})
+	for _, g := range []Geocoder{nominatim{}, openMeteoGeocoder{}} {
+		if _, _, err := g.Geocode(ctx, "Atlantis"); !errors.Is(err, errNotGeocoded) {
+			t.Errorf("%T: got %v, want errNotGeocoded", g, err)
+		}
+	}
+}
+
+func TestGeocoderByName(t *testing.T) {
+	for name, want := range map[string]Geocoder{"none": nil, "nominatim": nominatim{}, "openmeteo": openMeteoGeocoder{}} {
+		if g, err := geocoderByName(name); err != nil || g != want {
+			t.Errorf("%s: got %T, %v, want %T", name, g, err, want)
+		}
+	}
+	if _, err := geocoderByName("google"); err == nil {
+		t.Error("unknown geocoder accepted")
+	}
+}
+
+func TestFallbackCoordsWhenNotGeocoded(t *testing.T) {
+	var got coordinates
+	mw := multiWeatherProvider{askedAt("fallback-coords", &got)}
+	centroid := coordinates{Latitude: 45.9432, Longitude: 24.9668}
+	opts := aggregateOptions{geocoder: &fakeGeocoder{err: errNotGeocoded}, fallbackCoords: &centroid}
+
+	agg, err := mw.temperature(context.Background(), "Nowhere", 0, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != centroid || !agg.CoordFallback {
+		t.Errorf("asked at %+v with coord_fallback %t, want the fallback %+v", got, agg.CoordFallback, centroid)
+	}
+
+	// other geocoder failures aren't covered by the fallback
+	opts.geocoder = &fakeGeocoder{err: errors.New("rate limited")}
+	if _, err := mw.temperature(context.Background(), "Nowhere", 0, 0, opts); err == nil {
+		t.Error("a failing geocoder fell back to the fallback coordinates")
+	}
+}
+
+func TestSlowGeocoderTimesOut(t *testing.T) {
+	g := &fakeGeocoder{lat: 44.4268, long: 26.1025, delay: time.Minute}
+	p := &fakeProvider{id: "slow-geocoder", data: reading(10)}
+	mw := multiWeatherProvider{p}
+
+	began := time.Now()
+	_, err := mw.temperature(context.Background(), "Bucharest", 0, 0, aggregateOptions{geocoder: g, geocodeTimeout: 20 * time.Millisecond})
+	if !errors.Is(err, ErrGeocodeTimeout) {
+		t.Errorf("got %v, want ErrGeocodeTimeout", err)
+	}
+	if took := time.Since(began); took > 5*time.Second {
+		t.Errorf("took %s, want the geocoder given up on after its timeout", took)
+	}
+	if p.calls.Load() != 0 {
+		t.Error("provider asked without coordinates")
+	}
+
+	// a request running out of time is not the geocoder's own timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = mw.temperature(ctx, "Bucharest", 0, 0, aggregateOptions{geocoder: g, geocodeTimeout: time.Minute})
+	if errors.Is(err, ErrGeocodeTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the request's deadline", err)
+	}
+}
+
+func TestWeatherSlowGeocoderIsAGatewayTimeout(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "slow-geocoder-handler", data: reading(10)})
+	sc.opts.geocoder = &fakeGeocoder{delay: time.Minute}
+	sc.opts.geocodeTimeout = 20 * time.Millisecond
+	if rec := serve(newRouter(sc), "/weather/Bucharest"); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("got %d, want 504", rec.Code)
+	}
+}
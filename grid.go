@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxGridPoints caps how many coordinates one /weather-grid request may ask for.
+const maxGridPoints = 100
+
+// gridResult is the answer for one point. Error is set instead of Temp when
+// the point couldn't be served.
+type gridResult struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"long"`
+	Temp      string  `json:"temp,omitempty"`
+	Partial   bool    `json:"partial,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// gridHandler serves POST /weather-grid, a JSON array of lat/long pairs answered
+// with an array of readings in the same order. At most parallelism points are
+// fetched at once, each with its own timeout.
+func gridHandler(providers *atomic.Pointer[multiWeatherProvider], opts aggregateOptions, timeout time.Duration, parallelism int, forwarded []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST with a JSON array of points", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var points []coordinates
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&points); err != nil {
+			http.Error(w, "invalid points: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(points) == 0 || len(points) > maxGridPoints {
+			http.Error(w, fmt.Sprintf("send between 1 and %d points", maxGridPoints), http.StatusBadRequest)
+			return
+		}
+
+		mw := *providers.Load()
+		results := make([]gridResult, len(points))
+		sem := make(chan struct{}, parallelism)
+		var wg sync.WaitGroup
+
+		for i, p := range points {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, p coordinates) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				ctx, cancel := context.WithTimeout(r.Context(), timeout)
+				defer cancel()
+				ctx = withForwardedHeaders(ctx, r, forwarded)
+
+				results[i] = gridWeather(ctx, mw, p, opts)
+			}(i, p)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// gridWeather fetches a single point using only its coordinates.
+func gridWeather(ctx context.Context, mw multiWeatherProvider, p coordinates, opts aggregateOptions) gridResult {
+	res := gridResult{Latitude: p.Latitude, Longitude: p.Longitude}
+
+	if err := validateCoords(p.Latitude, p.Longitude); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	agg, err := mw.temperature(ctx, "", p.Latitude, p.Longitude, opts)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Temp, err = formatTemperature("c", agg.Celsius)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Partial = len(agg.TimedOut) > 0
+	return res
+}
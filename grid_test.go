@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postGrid(h http.Handler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/weather-grid", strings.NewReader(body)))
+	return rec
+}
+
+func TestGridMixedPoints(t *testing.T) {
+	p := &fakeProvider{id: "grid", data: reading(10)}
+	h := newRouter(testRoutes(p))
+
+	rec := postGrid(h, `[{"lat":44.4268,"long":26.1025},{"lat":95,"long":10},{"lat":0,"long":0},{"lat":41.6267,"long":-93.7122}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", rec.Code, rec.Body.String())
+	}
+	var results []gridResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want 4", len(results))
+	}
+
+	for _, i := range []int{0, 3} {
+		if results[i].Temp != "10.00°C" || results[i].Error != "" {
+			t.Errorf("point %d: got %+v, want 10.00°C", i, results[i])
+		}
+	}
+	for _, i := range []int{1, 2} {
+		if results[i].Error == "" || results[i].Temp != "" {
+			t.Errorf("point %d: got %+v, want an error", i, results[i])
+		}
+	}
+	if results[3].Latitude != 41.6267 || results[3].Longitude != -93.7122 {
+		t.Errorf("point 3 is %.4f, %.4f, want the results in the order asked", results[3].Latitude, results[3].Longitude)
+	}
+	if n := p.calls.Load(); n != 2 {
+		t.Errorf("provider asked %d times, want once per valid point", n)
+	}
+}
+
+func TestGridRejectsBadRequests(t *testing.T) {
+	h := newRouter(testRoutes(&fakeProvider{id: "grid-bad", data: reading(10)}))
+
+	for _, body := range []string{`[]`, `{"lat":1}`, `[{"lat":"north"}]`} {
+		if rec := postGrid(h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", body, rec.Code)
+		}
+	}
+
+	if rec := serve(h, "/weather-grid"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got %d, want 405", rec.Code)
+	}
+}
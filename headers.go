@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type forwardedHeadersKey struct{}
+
+// parseHeaderList splits a comma-separated list of header names, e.g. "X-Trace-Id,X-Request-Id".
+func parseHeaderList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names
+}
+
+// withForwardedHeaders returns a context carrying the allowed headers of an
+// incoming request, to be copied onto every provider request made with it.
+func withForwardedHeaders(ctx context.Context, r *http.Request, allowed []string) context.Context {
+	h := http.Header{}
+	for _, name := range allowed {
+		if values := r.Header.Values(name); len(values) > 0 {
+			h[name] = values
+		}
+	}
+	if len(h) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, forwardedHeadersKey{}, h)
+}
+
+// forwardHeaders copies the headers carried by ctx onto an outgoing request.
+func forwardHeaders(ctx context.Context, req *http.Request) {
+	h, _ := ctx.Value(forwardedHeadersKey{}).(http.Header)
+	for name, values := range h {
+		req.Header[name] = append([]string(nil), values...)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	in := httptest.NewRequest(http.MethodGet, "/weather/Bucharest", nil)
+	in.Header.Set("X-Trace-Id", "abc123")
+	in.Header.Set("X-Secret", "hunter2")
+
+	ctx, transport := withCanned(map[string]cannedResponse{"example.com": {body: "{}"}})
+	ctx = withForwardedHeaders(ctx, in, parseHeaderList("x-trace-id, X-Missing"))
+	resp, err := get(ctx, "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	out := transport.sent()[0]
+	if got := out.Header.Get("X-Trace-Id"); got != "abc123" {
+		t.Errorf("X-Trace-Id is %q, want the forwarded abc123", got)
+	}
+	if got := out.Header.Get("X-Secret"); got != "" {
+		t.Errorf("X-Secret is %q, want it left behind", got)
+	}
+	if _, ok := out.Header["X-Missing"]; ok {
+		t.Error("X-Missing was sent though the request didn't have it")
+	}
+	if got := out.Header.Get("User-Agent"); got != userAgent {
+		t.Errorf("User-Agent is %q, want %q", got, userAgent)
+	}
+}
+
+func TestParseHeaderList(t *testing.T) {
+	got := parseHeaderList(" x-trace-id,,X-Request-ID ")
+	if len(got) != 2 || got[0] != "X-Trace-Id" || got[1] != "X-Request-Id" {
+		t.Errorf("got %q, want [X-Trace-Id X-Request-Id]", got)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reprobeInterval is how often the providers are probed while none is reachable.
+const reprobeInterval = 30 * time.Second
+
+// livezHandler answers 200 for as long as the process is able to serve at all.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler answers 200 once startup is done and at least one enabled provider
+// is reachable, which is to say its most recent call succeeded. Providers not
+// asked yet aren't reachable. Otherwise it answers 503.
+func readyzHandler(providers *atomic.Pointer[multiWeatherProvider], started *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !started.Load() {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		if !reachable((*providers.Load()).enabled()) {
+			http.Error(w, "no provider reachable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// reachable reports whether the most recent call to any of the providers succeeded.
+func reachable(mw multiWeatherProvider) bool {
+	for _, p := range mw {
+		if stats.provider(p.name()).lastSucceeded.Load() {
+			return true
+		}
+	}
+	return false
+}
+
+// probeProviders asks every provider about selfTestCity at once, through ask so
+// the answers count towards /readyz, and returns once they have all answered.
+func probeProviders(mw multiWeatherProvider, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, p := range mw {
+		wg.Add(1)
+		go func(p weatherProvider) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			ask(ctx, realClock{}, p, selfTestCity.name, selfTestCity.lat, selfTestCity.long)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// probeWhileUnreachable probes the enabled providers every interval for as long
+// as none of them is reachable. A server /readyz keeps out of rotation gets no
+// requests to ask providers with, so without it it would never be ready again.
+// It runs until the process exits.
+func probeWhileUnreachable(providers *atomic.Pointer[multiWeatherProvider], timeout time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if mw := (*providers.Load()).enabled(); !reachable(mw) {
+			probeProviders(mw, timeout)
+		}
+	}
+}
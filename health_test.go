@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLivez(t *testing.T) {
+	sc := testRoutes()
+	sc.started.Store(false)
+	if rec := serve(newRouter(sc), "/livez"); rec.Code != http.StatusOK {
+		t.Errorf("got %d while starting, want 200", rec.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	p := &fakeProvider{id: "readyz", err: errors.New("unreachable")}
+	sc := testRoutes(p)
+	h := newRouter(sc)
+
+	sc.started.Store(false)
+	if rec := serve(h, "/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("starting: got %d, want 503", rec.Code)
+	}
+
+	sc.started.Store(true)
+	if rec := serve(h, "/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("provider never asked: got %d, want 503", rec.Code)
+	}
+
+	probeProviders(multiWeatherProvider{p}, time.Second)
+	if rec := serve(h, "/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("provider unreachable: got %d, want 503", rec.Code)
+	}
+
+	p.err, p.data = nil, reading(10)
+	probeProviders(multiWeatherProvider{p}, time.Second)
+	if rec := serve(h, "/readyz"); rec.Code != http.StatusOK {
+		t.Errorf("provider reachable: got %d, want 200", rec.Code)
+	}
+
+	p.err = errors.New("unreachable again")
+	probeProviders(multiWeatherProvider{p}, time.Second)
+	if rec := serve(h, "/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("provider unreachable again: got %d, want 503", rec.Code)
+	}
+}
+
+func TestReadyzNeedsOneReachableProvider(t *testing.T) {
+	down := &fakeProvider{id: "readyz-down", err: errors.New("unreachable")}
+	up := &fakeProvider{id: "readyz-up", data: reading(10)}
+	h := newRouter(testRoutes(down, up))
+
+	probeProviders(multiWeatherProvider{down, up}, time.Second)
+	if rec := serve(h, "/readyz"); rec.Code != http.StatusOK {
+		t.Errorf("got %d with one of two providers reachable, want 200", rec.Code)
+	}
+	if down.calls.Load() != 1 || up.calls.Load() != 1 {
+		t.Errorf("probed %d and %d times, want each once", down.calls.Load(), up.calls.Load())
+	}
+}
+
+func TestReadyzIgnoresDisabledProviders(t *testing.T) {
+	t.Cleanup(func() { switches.set("metno", true) })
+	up := &fakeProvider{id: "metno", data: reading(10)}
+	h := newRouter(testRoutes(up))
+
+	probeProviders(multiWeatherProvider{up}, time.Second)
+	switches.set("metno", false)
+	if rec := serve(h, "/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d with the only reachable provider disabled, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type historyTimeKey struct{}
+
+// withHistoryTime returns a context under which providers give the reading at
+// the past time at instead of the current one.
+func withHistoryTime(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, historyTimeKey{}, at)
+}
+
+// historyTime is the past time ctx asks for, false when it asks for now.
+func historyTime(ctx context.Context) (time.Time, bool) {
+	at, ok := ctx.Value(historyTimeKey{}).(time.Time)
+	return at, ok && !at.IsZero()
+}
+
+// historian is a provider that can answer for a past time, see withHistoryTime.
+// hasHistory is a method rather than the interface alone so that wrappers can
+// say whether what they wrap has history.
+type historian interface {
+	hasHistory() bool
+}
+
+// hasHistory reports whether p can give past readings. Providers that can't are
+// skipped for ?at= requests rather than answering with the current weather.
+func hasHistory(p weatherProvider) bool {
+	h, ok := p.(historian)
+	return ok && h.hasHistory()
+}
+
+// requestedTime reads ?at=, an RFC 3339 time in the past to get the weather at.
+// It is zero when not given.
+func requestedTime(r *http.Request, now time.Time) (time.Time, error) {
+	v := r.URL.Query().Get("at")
+	if v == "" {
+		return time.Time{}, nil
+	}
+	at, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("at %q is not an RFC 3339 time, e.g. 2024-01-01T12:00:00Z", v)
+	}
+	if at.After(now) {
+		return time.Time{}, fmt.Errorf("at %s is in the future", v)
+	}
+	return at, nil
+}
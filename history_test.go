@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRequestedTime(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"2024-01-01T12:00:00Z", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), false},
+		{"2024-01-01", time.Time{}, true},
+		{"2025-01-01T12:00:00Z", time.Time{}, true}, // in the future
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, "/weather/Bucharest?at="+tt.value, nil)
+		got, err := requestedTime(r, now)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("%q: got %s, %v, want %s, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDarkSkyTimeMachineDecode(t *testing.T) {
+	const timeMachine = "https://api.darksky.net/forecast/key/44.4268,26.1025,1700000000?exclude=minutely,hourly,daily,alerts"
+	ctx, transport := withCanned(map[string]cannedResponse{
+		timeMachine: {body: `{"latitude":44.4268,"longitude":26.1025,"currently":{"time":1700000000,"temperature":41,"windSpeed":4.5},"flags":{"units":"us"}}`},
+	})
+	wd, err := darkSky{apiKey: "key"}.temperature(withHistoryTime(ctx, time.Unix(1700000000, 0)), "", 44.4268, 26.1025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.Celsius != 5 || !wd.Observed.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("got %.2f°C observed %s, want 5.00°C at 1700000000", wd.Celsius, wd.Observed)
+	}
+	if sent := transport.sent(); len(sent) != 1 || sent[0].URL.String() != timeMachine {
+		t.Errorf("sent %v, want the time machine request", sent)
+	}
+}
+
+func TestOpenMeteoArchiveDecode(t *testing.T) {
+	at := time.Date(2023, 11, 14, 13, 40, 0, 0, time.UTC)
+	const archive = "https://archive-api.open-meteo.com/v1/archive?latitude=44.4268&longitude=26.1025&start_date=2023-11-14&end_date=2023-11-14&hourly=temperature_2m,windspeed_10m,weathercode&windspeed_unit=ms&timeformat=unixtime&timezone=GMT"
+	tests := []struct {
+		name     string
+		body     string
+		want     float64
+		wantHour int64
+	}{
+		{"nearest hour", `{"hourly":{"time":[1699966800,1699970400,1699974000],"temperature_2m":[7.5,8.5,9.5],"windspeed_10m":[1,2,3],"weathercode":[0,3,61]}}`, 8.5, 1699970400},
+		{"missing hours passed over", `{"hourly":{"time":[1699966800,1699970400,1699974000],"temperature_2m":[null,null,9.5]}}`, 9.5, 1699974000},
+	}
+	for _, tt := range tests {
+		ctx, _ := withCanned(map[string]cannedResponse{archive: {body: tt.body}})
+		wd, err := openMeteo{}.temperature(withHistoryTime(ctx, at), "", 44.4268, 26.1025)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if wd.Celsius != tt.want || wd.Observed.Unix() != tt.wantHour {
+			t.Errorf("%s: got %.2f°C at %d, want %.2f°C at %d", tt.name, wd.Celsius, wd.Observed.Unix(), tt.want, tt.wantHour)
+		}
+	}
+
+	ctx, _ := withCanned(map[string]cannedResponse{archive: {body: `{"hourly":{"time":[1699970400],"temperature_2m":[null]}}`}})
+	wd, err := openMeteo{}.temperature(withHistoryTime(ctx, at), "", 44.4268, 26.1025)
+	if err != nil || wd.HasReading {
+		t.Errorf("got %+v, %v from an archive without the day yet, want no reading", wd, err)
+	}
+}
+
+func TestWeatherAtSkipsProvidersWithoutHistory(t *testing.T) {
+	ctx, transport := withCanned(map[string]cannedResponse{
+		"api.darksky.net": {body: `{"currently":{"time":1700000000,"temperature":41},"flags":{"units":"us"}}`},
+	})
+	noHistory := &fakeProvider{id: "openweathermap", data: reading(30)}
+	sc := testRoutes(noHistory, darkSky{apiKey: "key"})
+
+	resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025&at=2023-11-14T22:13:20Z&sources=1"))
+	if resp.Temp != "5.00°C" {
+		t.Errorf("temp is %s, want dark sky's past 5.00°C alone", resp.Temp)
+	}
+	if noHistory.calls.Load() != 0 {
+		t.Error("a provider without history was asked")
+	}
+	if len(resp.Skipped) != 1 || resp.Skipped[0] != (skippedProvider{Provider: "openweathermap", Reason: skipNoHistory}) {
+		t.Errorf("skipped %+v, want openweathermap for having no history", resp.Skipped)
+	}
+	if sent := transport.sent(); len(sent) != 1 {
+		t.Errorf("sent %d requests, want the one time machine request", len(sent))
+	}
+}
+
+func TestWeatherAtACityNeedsAGeocoder(t *testing.T) {
+	p := &fakeProvider{id: "at-city", data: reading(10)}
+	sc := testRoutes(p)
+	if rec := serve(newRouter(sc), "/weather/Bucharest?at=2023-11-14T22:13:20Z"); rec.Code != http.StatusBadRequest {
+		t.Errorf("without a geocoder got %d, want 400", rec.Code)
+	}
+
+	sc.opts.geocoder = &fakeGeocoder{lat: 44.4268, long: 26.1025}
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.darksky.net": {body: `{"currently":{"time":1700000000,"temperature":41},"flags":{"units":"us"}}`},
+	})
+	sc.providers.Store(&multiWeatherProvider{darkSky{apiKey: "key"}})
+	if resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/Bucharest?at=2023-11-14T22:13:20Z")); resp.Temp != "5.00°C" {
+		t.Errorf("with a geocoder temp is %s, want 5.00°C", resp.Temp)
+	}
+}
+
+func TestDarkSkyWithoutCoordinatesSendsNothing(t *testing.T) {
+	ctx, transport := withCanned(providerFixtures)
+	wd, err := darkSky{apiKey: "key"}.temperature(ctx, "Bucharest", 0, 0)
+	if err != nil || wd.HasReading {
+		t.Errorf("got %+v, %v, want no reading and no error", wd, err)
+	}
+	if len(transport.sent()) != 0 {
+		t.Errorf("sent %d requests without coordinates", len(transport.sent()))
+	}
+}
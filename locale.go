@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// decimalSeparators maps the languages we localize for to their decimal separator.
+var decimalSeparators = map[string]string{
+	"en": ".",
+	"de": ",",
+	"es": ",",
+	"fr": ",",
+	"it": ",",
+	"nl": ",",
+	"pl": ",",
+	"pt": ",",
+	"ro": ",",
+	"ru": ",",
+	"sv": ",",
+	"ja": ".",
+	"zh": ".",
+}
+
+// requestedLocale picks the language numbers are formatted for: ?locale= when
+// given, which must be supported, otherwise the first supported language in
+// Accept-Language, otherwise en.
+func requestedLocale(r *http.Request) (string, error) {
+	if v := r.URL.Query().Get("locale"); v != "" {
+		lang := baseLanguage(v)
+		if _, ok := decimalSeparators[lang]; !ok {
+			return "", fmt.Errorf("unsupported locale %q", v)
+		}
+		return lang, nil
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		lang := baseLanguage(tag)
+		if _, ok := decimalSeparators[lang]; ok {
+			return lang, nil
+		}
+	}
+	return "en", nil
+}
+
+// baseLanguage reduces a tag such as "de-AT" to "de".
+func baseLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	lang, _, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	return lang
+}
+
+// localizeNumbers rewrites the decimal point of the formatted number fields in
+// resp for lang.
+func localizeNumbers(resp *weatherResponse, lang string) {
+	sep := decimalSeparators[lang]
+	if sep == "" || sep == "." {
+		return
+	}
+
+	for _, field := range resp.numberFields() {
+		*field = strings.Replace(*field, ".", sep, 1)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestedLocale(t *testing.T) {
+	tests := []struct {
+		query, acceptLanguage string
+		want                  string
+	}{
+		{"", "", "en"},
+		{"?locale=de", "", "de"},
+		{"?locale=de-AT", "", "de"},
+		{"?locale=en", "de-DE", "en"},
+		{"", "de-DE,de;q=0.9,en;q=0.8", "de"},
+		{"", "xx, fr;q=0.5", "fr"},
+		{"", "xx", "en"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/weather/Bucharest"+tt.query, nil)
+		r.Header.Set("Accept-Language", tt.acceptLanguage)
+		got, err := requestedLocale(r)
+		if err != nil || got != tt.want {
+			t.Errorf("%q with Accept-Language %q: got %q, %v, want %q", tt.query, tt.acceptLanguage, got, err, tt.want)
+		}
+	}
+
+	if _, err := requestedLocale(httptest.NewRequest(http.MethodGet, "/weather/Bucharest?locale=xx", nil)); err == nil {
+		t.Error("unsupported ?locale= accepted")
+	}
+}
+
+func TestWeatherLocale(t *testing.T) {
+	wd := reading(12.34)
+	wd.WindSpeed = 3.5
+	sc := testRoutes(&fakeProvider{id: "locale", data: wd})
+	h := newRouter(sc)
+
+	tests := []struct {
+		locale                string
+		temp, lat, long, wind string
+	}{
+		{"en", "12.34°C", "44.4268", "26.1025", "3.5 m/s"},
+		{"de", "12,34°C", "44,4268", "26,1025", "3,5 m/s"},
+	}
+	for _, tt := range tests {
+		resp := decodeWeather(t, serve(h, "/weather/?lat=44.4268&long=26.1025&locale="+tt.locale))
+		if resp.Temp != tt.temp || resp.Lat != tt.lat || resp.Long != tt.long || resp.Wind != tt.wind {
+			t.Errorf("%s: got %s at %s, %s with wind %s, want %s at %s, %s with wind %s",
+				tt.locale, resp.Temp, resp.Lat, resp.Long, resp.Wind, tt.temp, tt.lat, tt.long, tt.wind)
+		}
+		if resp.TempC != 12.34 {
+			t.Errorf("%s: temp_c is %v, want the number 12.34 whatever the locale", tt.locale, resp.TempC)
+		}
+	}
+
+	if rec := serve(h, "/weather/Bucharest?locale=xx"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unsupported locale: got %d, want 400", rec.Code)
+	}
+}
@@ -1,105 +1,1006 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	neturl "net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 const KelvinShift = 273.15
 
+// errNoData means no provider had a usable reading for the request.
+var errNoData = errors.New("no weather data available")
+
+// ErrProviderUnavailable marks a transient provider failure, such as a connection
+// dropped mid-response. Such providers are skipped rather than failing the request.
+var ErrProviderUnavailable = errors.New("provider unavailable")
+
+// errResponseTooLarge means a provider sent more than maxResponseBytes.
+var errResponseTooLarge = errors.New("provider response too large")
+
+// errEnoughReadings is why providers still running are cancelled once
+// aggregateOptions.minReadings valid readings are in.
+var errEnoughReadings = errors.New("enough readings")
+
+// maxResponseBytes caps how much of a provider's response body is read.
+var maxResponseBytes int64 = 1 << 20
+
+// userAgent identifies us to providers, some of which refuse anonymous clients.
+const userAgent = "hello-weather/1.0 (+https://github.com/sef-tarbell/hello)"
+
 func main() {
-	mw := multiWeatherProvider {
-		openWeatherMap{},
-		darkSky{apiKey: "16f1d1a16039f72b7fb8af35ae20fe80"},
+	var timeouts serverTimeouts
+	flag.DurationVar(&timeouts.readHeader, "read-header-timeout", 5*time.Second, "how long a client may take to send request headers")
+	flag.DurationVar(&timeouts.read, "read-timeout", 10*time.Second, "how long a client may take to send a whole request")
+	flag.DurationVar(&timeouts.write, "write-timeout", 30*time.Second, "how long a response may take, keep it above -provider-timeout")
+	flag.DurationVar(&timeouts.idle, "idle-timeout", 60*time.Second, "how long an idle keep-alive connection is kept open")
+	transport := defaultTransportOptions
+	flag.IntVar(&transport.maxIdleConns, "max-idle-conns", transport.maxIdleConns, "idle provider connections kept open across all providers, 0 for no limit")
+	flag.IntVar(&transport.maxIdleConnsPerHost, "max-idle-conns-per-host", transport.maxIdleConnsPerHost, "idle connections kept open to each provider")
+	flag.DurationVar(&transport.idleConnTimeout, "idle-conn-timeout", transport.idleConnTimeout, "how long an idle provider connection is kept open, 0 for ever")
+	configPath := flag.String("config", "", "JSON file with provider settings, re-read on SIGHUP")
+	proxy := flag.String("proxy", "", "proxy URL for outbound requests, overriding HTTP_PROXY and HTTPS_PROXY")
+	stationFile := flag.String("station-file", "", "CSV or JSON file of local station readings to use as a provider")
+	cacheTTL := flag.Duration("cache-ttl", 0, "how long to cache a city's weather, 0 disables caching")
+	warmList := flag.String("warm-cities", "", "comma-separated cities to fetch into the cache on startup and keep fresh, needs -cache-ttl")
+	warmInterval := flag.Duration("warm-interval", 0, "how often -warm-cities are refreshed, 0 refreshes at 80% of -cache-ttl")
+	warmJitter := flag.Duration("warm-jitter", 200*time.Millisecond, "most a -warm-cities provider call is delayed by, at random, to spread them out")
+	staleWhileError := flag.Bool("stale-while-error", false, "serve an expired cache entry, marked stale, when fetching fresh weather fails")
+	maxStale := flag.Duration("max-stale", time.Hour, "how long past -cache-ttl an entry may still be served by -stale-while-error or -soft-timeout")
+	staleWhileRevalidate := flag.Duration("stale-while-revalidate", 0, "how long past -cache-ttl an entry is served straight away, marked stale, while it is refreshed in the background; 0 never does")
+	softTimeout := flag.Duration("soft-timeout", 0, "how long to wait for fresh weather before serving an expired cache entry, marked stale, while the fetch goes on to refresh it; 0 always waits")
+	providerCacheTTL := flag.Duration("provider-cache-ttl", 0, "how long to cache each provider's reading on its own, 0 disables it")
+	providerTimeout := flag.Duration("provider-timeout", 5*time.Second, "how long to wait for providers before answering with the ones that responded")
+	consensusTolerance := flag.Float64("consensus-tolerance", 1, "most the readings may be apart, in °C, for the response to flag a consensus")
+	dedupCoords := flag.Bool("dedup-coords", false, "average only one reading per reported coordinates")
+	gridParallelism := flag.Int("grid-parallelism", 4, "how many points of a /weather-grid request are fetched at once")
+	maxProviderCalls := flag.Int("max-provider-calls", 64, "most provider calls in flight at once across all requests, 0 for no limit")
+	duplicates := flag.String("duplicate-providers", "dedup", "what to do with a provider listed more than once: dedup asks only the first, allow asks them all")
+	concurrency := flag.String("concurrency", "parallel", "how providers are asked once coordinates are known: parallel or sequential")
+	maxCall := flag.Int("max-call", 0, "ask only this many providers, in order, keeping the rest as backups for when too few answer; 0 asks them all")
+	minProviders := flag.Int("min-providers", 0, "answer as soon as this many providers have valid readings, 0 waits for all")
+	geocoderName := flag.String("geocoder", "none", "where cities are resolved to coordinates: none (the first provider), nominatim or openmeteo")
+	geocodeTimeout := flag.Duration("geocode-timeout", 2*time.Second, "how long the -geocoder may take, 0 leaves it the -provider-timeout")
+	fallbackCoords := flag.String("fallback-coords", "", "lat,long to use when the -geocoder finds nothing for a city, e.g. a country centroid")
+	requiredProviders := flag.String("required-providers", "", "comma-separated providers whose failure fails the request")
+	aggregation := flag.String("aggregate", "mean", "how provider readings are combined: mean, median, first, trimmed, nearest or adaptive")
+	adminToken := flag.String("admin-token", "", "shared secret for the /admin and /preferences endpoints, which are locked when empty")
+	tempFormat := flag.String("temp-format", "degree", "how temperatures are displayed: degree (12.34°C), ascii (12.34C) or comma (12,34°C)")
+	rounding := flag.String("rounding", string(halfUp), "how temperatures halfway between two hundredths are rounded: half-up or half-even")
+	debugSampleRate := flag.Float64("debug-sample-rate", 0, "share of fetches, from 0 to 1, whose provider readings and bodies are logged")
+	selfTest := flag.Bool("selftest", false, "check every provider and the unit conversions, then exit non-zero if any failed")
+	debug := flag.Bool("debug", false, "allow debugging parameters such as ?raw=1 and the /debug/cache endpoint")
+	failureLogInterval := flag.Duration("failure-log-interval", time.Minute, "how often a provider failing the same way is logged again, with a count of those suppressed; 0 logs every failure")
+	flag.Float64Var(&plausibleTemps.min, "min-temp", plausibleTemps.min, "coldest reading in °C taken as real, colder ones are skipped as implausible")
+	flag.Float64Var(&plausibleTemps.max, "max-temp", plausibleTemps.max, "hottest reading in °C taken as real, hotter ones are skipped as implausible")
+	flag.IntVar(&providerRetries, "retries", providerRetries, "how many more times a provider is asked after a retryable status such as 503, see retry_statuses")
+	flag.Int64Var(&maxResponseBytes, "max-response-bytes", maxResponseBytes, "largest provider response body that will be read")
+	maxCityLength := flag.Int("max-city-length", 100, "longest city name accepted, in characters")
+	forward := flag.String("forward-headers", "", "comma-separated request headers to pass on to providers, e.g. X-Trace-Id")
+	noDataStatus := flag.Int("no-data-status", http.StatusInternalServerError, "status returned when no provider has data for a city: 204, 404 or 500")
+	flag.Parse()
+
+	if *consensusTolerance < 0 {
+		log.Fatalf("-consensus-tolerance can't be negative, got %g", *consensusTolerance)
 	}
 
-	// Urbandale 41.6267° N, 93.7122° W
-	// Bucharest 44.4268° N, 26.1025° E
+	if *minProviders < 0 {
+		log.Fatalf("-min-providers can't be negative, got %d", *minProviders)
+	}
+
+	if *duplicates != "dedup" && *duplicates != "allow" {
+		log.Fatalf("-duplicate-providers must be dedup or allow, got %q", *duplicates)
+	}
+	if *concurrency != "parallel" && *concurrency != "sequential" {
+		log.Fatalf("-concurrency must be parallel or sequential, got %q", *concurrency)
+	}
+
+	if *maxProviderCalls < 0 {
+		log.Fatalf("-max-provider-calls can't be negative, got %d", *maxProviderCalls)
+	}
+	if *maxProviderCalls > 0 {
+		providerSlots = make(chan struct{}, *maxProviderCalls)
+	}
+
+	if plausibleTemps.min >= plausibleTemps.max || plausibleTemps.min < -KelvinShift {
+		log.Fatalf("-min-temp must be below -max-temp and above absolute zero, got %g and %g", plausibleTemps.min, plausibleTemps.max)
+	}
+
+	if providerRetries < 0 {
+		log.Fatalf("-retries can't be negative, got %d", providerRetries)
+	}
+
+	if *failureLogInterval < 0 {
+		log.Fatalf("-failure-log-interval can't be negative, got %s", *failureLogInterval)
+	}
+	failureLog = newFailureLimiter(realClock{}, *failureLogInterval)
+
+	if *maxCall < 0 {
+		log.Fatalf("-max-call can't be negative, got %d", *maxCall)
+	}
+
+	if *gridParallelism < 1 {
+		log.Fatalf("-grid-parallelism must be at least 1, got %d", *gridParallelism)
+	}
+
+	if *debugSampleRate < 0 || *debugSampleRate > 1 {
+		log.Fatalf("-debug-sample-rate must be between 0 and 1, got %g", *debugSampleRate)
+	}
+
+	switch *noDataStatus {
+	case http.StatusNoContent, http.StatusNotFound, http.StatusInternalServerError:
+	default:
+		log.Fatalf("-no-data-status must be 204, 404 or 500, got %d", *noDataStatus)
+	}
+
+	formatter, ok := temperatureFormatters[*tempFormat]
+	if !ok {
+		log.Fatalf("unknown -temp-format %q, expected degree, ascii or comma", *tempFormat)
+	}
+	displayFormatter = formatter
+
+	mode, err := parseRoundingMode(*rounding)
+	if err != nil {
+		log.Fatal(err)
+	}
+	displayRounding = mode
+
+	aggregator, err := aggregatorByName(*aggregation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	geocoder, err := geocoderByName(*geocoderName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := aggregateOptions{dedupCoords: *dedupCoords, aggregator: aggregator, geocoder: geocoder, minReadings: *minProviders}
+	if *fallbackCoords != "" {
+		// without a geocoder no city is ever found missing, so they'd never be used
+		if geocoder == nil {
+			log.Fatal("-fallback-coords needs a -geocoder")
+		}
+		fallback, err := parseCoords(*fallbackCoords)
+		if err != nil {
+			log.Fatalf("-fallback-coords: %s", err)
+		}
+		opts.fallbackCoords = &fallback
+	}
+	opts.geocodeTimeout = *geocodeTimeout
+	opts.maxCall = *maxCall
+	opts.sequential = *concurrency == "sequential"
+	opts.required, err = parseProviderSet(*requiredProviders)
+	if err != nil {
+		log.Fatalf("-required-providers: %s", err)
+	}
+	forwarded := parseHeaderList(*forward)
+
+	transport.proxy, err = parseProxy(*proxy)
+	if err != nil {
+		log.Fatalf("-proxy: %s", err)
+	}
+	if transport.maxIdleConns < 0 || transport.maxIdleConnsPerHost < 0 || transport.idleConnTimeout < 0 {
+		log.Fatal("-max-idle-conns, -max-idle-conns-per-host and -idle-conn-timeout can't be negative")
+	}
+	providerClient.Transport = newProviderTransport(transport)
+
+	clock := Clock(realClock{})
+	cache := newWeatherCache(clock, *cacheTTL)
+	if *softTimeout < 0 {
+		log.Fatalf("-soft-timeout can't be negative, got %s", *softTimeout)
+	}
+	if *staleWhileRevalidate < 0 {
+		log.Fatalf("-stale-while-revalidate can't be negative, got %s", *staleWhileRevalidate)
+	}
+	if *staleWhileError || *softTimeout > 0 {
+		cache.maxStale = *maxStale
+	}
+	// entries are kept for as long as they may be revalidated
+	cache.maxStale = max(cache.maxStale, *staleWhileRevalidate)
+	opts.readings = newReadingCache(clock, *providerCacheTTL)
+	opts.clock = clock
+	stats.watchCache(cache)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// build makes the providers from cfg, the same way on startup and on reload
+	build := func(cfg config) (multiWeatherProvider, error) {
+		mw, err := buildProviders(cfg, *stationFile)
+		if err != nil || *duplicates == "allow" {
+			return mw, err
+		}
+		mw, dropped := mw.dedup()
+		for _, name := range dropped {
+			log.Printf("provider %s is listed more than once, only the first is asked", name)
+		}
+		return mw, nil
+	}
+
+	mw, err := build(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *selfTest {
+		if !runSelfTest(os.Stdout, mw.enabled(), *providerTimeout) {
+			os.Exit(1)
+		}
+		return
+	}
 
-	http.HandleFunc("/weather/", func(w http.ResponseWriter, r *http.Request) {
-		begin := time.Now()
-		city := strings.SplitN(r.URL.Path, "/", 3)[2]
-		lat := 0.0
-		long := 0.0
+	reverse := ReverseGeocoder(openWeatherMapReverse{apiKey: defaultAPIKeys["openweathermap"]})
+	if key := cfg.Providers["openweathermap"].APIKey; key != "" {
+		reverse = openWeatherMapReverse{apiKey: key}
+	}
 
-		lat, long, temp, err := mw.temperature(city, lat, long)
+	var providers atomic.Pointer[multiWeatherProvider]
+	providers.Store(&mw)
+	// started once every provider was probed and the cache warmed, so /readyz
+	// doesn't let traffic in before there's anything to answer it with
+	var started atomic.Bool
+	var startup sync.WaitGroup
+	startup.Add(1)
+	go func() {
+		defer startup.Done()
+		probeProviders(mw.enabled(), *providerTimeout)
+	}()
+	go probeWhileUnreachable(&providers, *providerTimeout, reprobeInterval)
+	go reloadOnHangup(func() (multiWeatherProvider, error) {
+		cfg, err := loadConfig(*configPath)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		displayLat := fmt.Sprintf("%.4f", lat)
-		displayLong := fmt.Sprintf("%.4f", long)
-		displayTemp := fmt.Sprintf("%.2f°C", temp)
-		log.Printf("city:%s, latitude:%s, longitude:%s, temperature:%s", city, displayLat, displayLong, displayTemp)
-
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"city": city,
-			"lat": displayLat,
-			"long": displayLong,
-			"temp": displayTemp,
-			"took": time.Since(begin).String(),
-		})
+			return nil, err
+		}
+		return build(cfg)
+	}, &providers)
+
+	if cities := parseCityList(*warmList); len(cities) > 0 {
+		interval := *warmInterval
+		if interval <= 0 {
+			interval = *cacheTTL * 4 / 5
+		}
+		if *cacheTTL <= 0 || interval <= 0 {
+			log.Fatal("-warm-cities needs -cache-ttl")
+		}
+		if *warmJitter < 0 {
+			log.Fatalf("-warm-jitter can't be negative, got %s", *warmJitter)
+		}
+		startup.Add(1)
+		go warmCache(cities, &providers, opts, cache, *providerTimeout, interval, *warmJitter, startup.Done)
+	}
+	go func() {
+		startup.Wait()
+		started.Store(true)
+	}()
+
+	// Urbandale 41.6267° N, 93.7122° W
+	// Bucharest 44.4268° N, 26.1025° E
+
+	router := newRouter(routes{
+		providers: &providers,
+		started: &started,
+		opts: opts,
+		cache: cache,
+		clock: clock,
+		reverse: reverse,
+		forwarded: forwarded,
+		providerTimeout: *providerTimeout,
+		gridParallelism: *gridParallelism,
+		maxCityLength: *maxCityLength,
+		noDataStatus: *noDataStatus,
+		debugSampleRate: *debugSampleRate,
+		staleWhileError: *staleWhileError,
+		softTimeout: *softTimeout,
+		staleWhileRevalidate: *staleWhileRevalidate,
+		consensusTolerance: *consensusTolerance,
+		debug: *debug,
+		adminToken: *adminToken,
 	})
 
-	http.ListenAndServe(":8080", nil)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, ":8080", router, timeouts); err != nil {
+		log.Fatal(err)
+	}
 }
 
 type weatherData struct {
+	HasReading bool `json:"has_reading"` // set by a provider that answered with a reading, see valid
 	Celsius float64 `json:"c"`
-	Fahrenheit float64 `json:"k"`
+	Fahrenheit float64 `json:"f"`
 	Kelvin float64 `json:"k"`
-	Latitude float64 `json:"lat"`
+	Latitude float64 `json:"lat"` // where the provider says the reading is from, zero if it didn't say rather than the coordinates it was asked for
 	Longitude float64 `json:"long"`
+	Observed time.Time `json:"observed"` // when the reading was taken, zero if the provider didn't say
+	WindSpeed float64 `json:"wind"` // metres per second
+	Pressure float64 `json:"pressure"` // hectopascals, zero if the provider didn't say
+	FeelsLike float64 `json:"feels_like"` // apparent temperature in celsius, only set with HasFeelsLike
+	HasFeelsLike bool `json:"has_feels_like"` // since a real feels-like can be 0.00°C
+	CloudCover float64 `json:"cloud_cover"` // percent of the sky, 0 to 100, only set with HasCloudCover
+	HasCloudCover bool `json:"has_cloud_cover"` // since a clear sky's cover is zero too
+	NativeUnit string `json:"native_unit"` // the temperature unit the provider reported in: c, f or k
+	Conditions string `json:"conditions"` // e.g. "light rain", empty if the provider didn't say
+	Sunrise time.Time `json:"sunrise"` // zero if the provider didn't say, in the place's time zone when known
+	Sunset time.Time `json:"sunset"`
+	LocationName string `json:"location_name"` // the provider's name for the place, empty if it didn't say
+	Quality map[string]string `json:"quality,omitempty"` // the provider's own data-quality hints, such as dark sky's flags
+	Source string `json:"source,omitempty"` // the provider that actually answered, when it isn't the one asked, such as a fallback
+	HTTPStatus int `json:"-"` // the status of the provider's response, zero if it made no request
+	Took time.Duration `json:"-"` // how long the provider took to answer, zero if it wasn't asked
 }
 
 type weatherProvider interface {
-	temperature(city string, lat float64, long float64) (weatherData, error) // returns temp in celsius
+	temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) // returns temp in celsius
+	name() string // identifies the provider in config, responses, logs and stats
 }
 
 type multiWeatherProvider []weatherProvider
 
-func (w multiWeatherProvider) temperature(city string, lat float64, long float64) (float64, float64, float64, error) {
-	sum := 0.0
-	n := 0
+// aggregateOptions tune how multiWeatherProvider combines readings.
+type aggregateOptions struct {
+	// dedupCoords keeps only the first reading for any pair of coordinates,
+	// so two providers resolving to the same station aren't counted twice.
+	// Readings without coordinates of their own are never duplicates.
+	dedupCoords bool
+
+	// aggregator combines the valid readings, the mean when nil
+	aggregator Aggregator
+
+	// geocoder resolves a city to coordinates before any provider is asked,
+	// when nil the first provider to report coordinates resolves them
+	geocoder Geocoder
+
+	// fallbackCoords stand in when the geocoder finds nothing for a city
+	fallbackCoords *coordinates
+
+	// geocodeTimeout limits the geocoder on its own, zero leaves it the
+	// request's deadline
+	geocodeTimeout time.Duration
+
+	// minReadings stops the wait for providers once this many valid readings
+	// are in, zero waits for all of them
+	minReadings int
 
+	// readings caches each provider's reading on its own, nil asks every time
+	readings *readingCache
+
+	// sequential asks providers one at a time in order instead of all at once,
+	// for -concurrency=sequential
+	sequential bool
+
+	// maxCall asks only the first maxCall providers, in order, and the others
+	// only when fewer than minReadings valid readings (or none) came back.
+	// Zero asks them all.
+	maxCall int
+
+	// exclude names providers to leave out, for one request's ?exclude=
+	exclude map[string]bool
+
+	// required names the providers whose failure, timeout or missing reading
+	// fails the whole request, however many others answered. When it is set
+	// the failures of the other providers are skipped like unavailable ones.
+	required map[string]bool
+
+	// at asks for the readings at that past time, from the providers that have
+	// history, instead of the current ones
+	at time.Time
+
+	// clock times the provider calls, the real clock when nil
+	clock Clock
+}
+
+// enabled leaves out the providers switched off through /admin/providers.
+func (w multiWeatherProvider) enabled() multiWeatherProvider {
+	if !switches.anyDisabled() {
+		return w
+	}
+
+	var on multiWeatherProvider
 	for _, provider := range w {
-		wd, err := provider.temperature(city, lat, long)
-		if err != nil {
-			return 0, 0, 0, err
+		if switches.enabled(provider.name()) {
+			on = append(on, provider)
+		}
+	}
+	return on
+}
+
+// dedup keeps only the first of any providers with the same name, which would
+// otherwise each count towards the average. The names dropped are returned.
+func (w multiWeatherProvider) dedup() (multiWeatherProvider, []string) {
+	seen := make(map[string]bool, len(w))
+	var kept multiWeatherProvider
+	var dropped []string
+	for _, provider := range w {
+		if seen[provider.name()] {
+			dropped = append(dropped, provider.name())
+			continue
+		}
+		seen[provider.name()] = true
+		kept = append(kept, provider)
+	}
+	return kept, dropped
+}
+
+// aggregate is the combined answer of a multiWeatherProvider.
+type aggregate struct {
+	weatherData // the combined reading at the requested, or else the first resolved, coordinates
+
+	// TimedOut names the providers that hadn't answered by the deadline
+	TimedOut []string
+
+	// Readings are the valid readings that went into the aggregate, in provider order
+	Readings []providerReading
+
+	// Skipped are the providers that didn't contribute a reading, and why
+	Skipped []skippedProvider
+
+	// Timings are how long each provider that was asked took, answer or not
+	Timings map[string]time.Duration
+
+	// Queried counts the providers that were asked, and Succeeded those of them
+	// that had a reading, whether or not it was used
+	Queried int
+	Succeeded int
+
+	// CoordFallback is set when the configured fallback coordinates were used
+	// because the city couldn't be geocoded
+	CoordFallback bool
+
+	// City is the place nearest the coordinates of a request that didn't name one
+	City string
+
+	// ResolvedLatitude and ResolvedLongitude are the coordinates the first provider
+	// reported for the readings, zero when none did
+	ResolvedLatitude float64
+	ResolvedLongitude float64
+}
+
+type providerResult struct {
+	index int
+	data weatherData
+	cached bool
+	err error
+}
+
+// temperature combines the providers' readings with opts.aggregator. Providers that
+// haven't answered by the time ctx is done are left out and their names returned.
+// With opts.minReadings set it stops waiting as soon as that many readings are in,
+// and the opts.required providers have all answered. When the caller cancels ctx,
+// rather than it running out of time, every provider still running is cancelled
+// and the caller's error returned.
+func (w multiWeatherProvider) temperature(ctx context.Context, city string, lat float64, long float64, opts aggregateOptions) (aggregate, error) {
+	var skipped []skippedProvider
+	past := !opts.at.IsZero()
+	if switches.anyDisabled() || len(opts.exclude) > 0 || past {
+		var asked multiWeatherProvider
+		for _, p := range w {
+			switch name := p.name(); {
+			case !switches.enabled(name):
+				skipped = append(skipped, skippedProvider{Provider: name, Reason: skipDisabled})
+			case opts.exclude[name]:
+				skipped = append(skipped, skippedProvider{Provider: name, Reason: skipExcluded})
+			case past && !hasHistory(p):
+				skipped = append(skipped, skippedProvider{Provider: name, Reason: skipNoHistory})
+			default:
+				asked = append(asked, p)
+			}
+		}
+		w = asked
+	}
+
+	// callerGone reports whether the caller gave up, such as a client that
+	// disconnected, as opposed to ctx timing out
+	parent := ctx
+	callerGone := func() bool {
+		return errors.Is(parent.Err(), context.Canceled)
+	}
+
+	clock := opts.clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	// providers still running when we return early are cancelled on the way out
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if past {
+		ctx = withHistoryTime(ctx, opts.at)
+	}
+
+	coordFallback := false
+	if opts.geocoder != nil && city != "" && lat == 0.0 && long == 0.0 {
+		var err error
+		lat, long, err = geocode(ctx, opts.geocoder, city, opts.geocodeTimeout)
+		if errors.Is(err, errNotGeocoded) && opts.fallbackCoords != nil {
+			log.Printf("using fallback coordinates for %s: %s", city, err)
+			lat, long = opts.fallbackCoords.Latitude, opts.fallbackCoords.Longitude
+			coordFallback = true
+		} else if err != nil {
+			return aggregate{}, &geocodeError{city: city, err: err}
+		}
+	}
+
+	var timedOut []string
+	valid := make([]weatherData, len(w))
+	isValid := make([]bool, len(w))
+	fromCache := make([]bool, len(w))
+	skipReason := make([]string, len(w))  // why each provider didn't contribute, if it didn't
+	statuses := make([]int, len(w))       // the HTTP status each provider got
+	took := make([]time.Duration, len(w)) // how long each provider asked took
+	count := 0
+	queried, succeeded := 0, 0 // providers asked, and those that had a reading
+	var seen map[coordKey]bool
+	if opts.dedupCoords {
+		seen = make(map[coordKey]bool, len(w))
+	}
+
+	// pending counts the required providers yet to give a valid reading
+	pending := 0
+	for _, p := range w {
+		if opts.required[p.name()] {
+			pending++
 		}
+	}
 
-		// we will assume that 0 degrees kelvin is a bad measurement ;)
-		if (wd.Kelvin > 0) {
-			n += 1
-			sum += wd.Celsius
+	// enough reports whether opts.minReadings valid readings are in, at which
+	// point the remaining providers are no longer waited for
+	enough := func() bool {
+		return opts.minReadings > 0 && count >= opts.minReadings && pending == 0
+	}
+
+	// requiredFailed is the error for a required provider that didn't give a reading
+	requiredFailed := func(p weatherProvider, err error) error {
+		return fmt.Errorf("required provider %s: %w", p.name(), err)
+	}
+
+	// duplicate reports whether a reading from the same coordinates was already counted
+	duplicate := func(wd weatherData) bool {
+		if !opts.dedupCoords || (wd.Latitude == 0.0 && wd.Longitude == 0.0) {
+			return false
+		}
+		at := roundedCoords(wd.Latitude, wd.Longitude)
+		if seen[at] {
+			return true
+		}
+		seen[at] = true
+		return false
+	}
+
+	// fetch answers from opts.readings when the provider was asked recently. A
+	// cached reading made no request this time, so it has no status or timing.
+	fetch := func(p weatherProvider, lat float64, long float64) (weatherData, bool, error) {
+		key := readingKey(p.name(), city, lat, long)
+		if wd, ok := opts.readings.get(key); ok {
+			wd.HTTPStatus, wd.Took = 0, 0
+			return wd, true, nil
+		}
+		wd, err := ask(ctx, clock, p, city, lat, long)
+		if err == nil && wd.valid() {
+			opts.readings.set(key, wd)
+		}
+		return wd, false, err
+	}
+
+	add := func(index int, wd weatherData, cached bool) error {
+		if opts.required[w[index].name()] {
+			if !wd.valid() {
+				return requiredFailed(w[index], errNoData)
+			}
+			pending--
+		}
+
+		if wd.valid() {
+			succeeded++
+		}
+		if !wd.HasReading {
+			skipReason[index] = skipNoReading
+		} else if !wd.valid() {
+			log.Printf("skipping %s: %.2f°C is outside %s", w[index].name(), wd.Celsius, plausibleTemps)
+			skipReason[index] = skipImplausible
+		} else if duplicate(wd) {
+			skipReason[index] = skipDuplicate
+		} else {
+			valid[index] = wd
+			isValid[index] = true
+			fromCache[index] = cached
+			count += 1
 		}
 
 		if (wd.Latitude != 0.0 && wd.Longitude != 0.0 && lat == 0.0 && long == 0.0) {
 			lat = wd.Latitude
 			long = wd.Longitude
 		}
+		return nil
+	}
+
+	// later providers may only work with coordinates, so ask one at a time
+	// until somebody has resolved them and then ask the rest all at once
+	i := 0
+	for ; i < len(w) && lat == 0.0 && long == 0.0 && !enough(); i++ {
+		wd, cached, err := fetch(w[i], lat, long)
+		if !cached {
+			queried++
+		}
+		statuses[i], took[i] = wd.HTTPStatus, wd.Took
+		if err != nil && callerGone() {
+			return aggregate{}, parent.Err()
+		}
+		if err != nil && opts.required[w[i].name()] {
+			return aggregate{}, requiredFailed(w[i], err)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				timedOut = append(timedOut, w[i].name())
+				skipReason[i] = skipTimedOut
+				continue
+			}
+			if errors.Is(err, ErrProviderUnavailable) || len(opts.required) > 0 {
+				failureLog.skipping(w[i].name(), err)
+				skipReason[i] = failureReason(err)
+				continue
+			}
+			return aggregate{}, err
+		}
+		if err := add(i, wd, cached); err != nil {
+			return aggregate{}, err
+		}
+	}
+
+	// askBatch asks w[from:to] all at once, or one after the other with
+	// opts.sequential, and collects their answers until they are all in, enough
+	// are in or ctx is done
+	askBatch := func(from int, to int) error {
+		batch := w[from:to]
+		results := make(chan providerResult, len(batch))
+		start := func(j int) {
+			queried++
+			go func(j int, provider weatherProvider, lat float64, long float64) {
+				wd, cached, err := fetch(provider, lat, long)
+				results <- providerResult{index: j, data: wd, cached: cached, err: err}
+			}(j, batch[j], lat, long)
+		}
+		if !opts.sequential {
+			for j := range batch {
+				start(j)
+			}
+		}
+
+		answered := make([]bool, len(batch))
+		for j := range batch {
+			if opts.sequential {
+				start(j)
+			}
+			select {
+			case res := <-results:
+				answered[res.index] = true
+				if res.cached {
+					queried-- // counted when started, but the cache answered for it
+				}
+				statuses[from+res.index], took[from+res.index] = res.data.HTTPStatus, res.data.Took
+				if res.err != nil && callerGone() {
+					return parent.Err()
+				}
+				if res.err != nil && opts.required[batch[res.index].name()] {
+					return requiredFailed(batch[res.index], res.err)
+				}
+				if res.err != nil {
+					if ctx.Err() != nil {
+						timedOut = append(timedOut, batch[res.index].name())
+						skipReason[from+res.index] = skipTimedOut
+						continue
+					}
+					if errors.Is(res.err, ErrProviderUnavailable) || len(opts.required) > 0 {
+						failureLog.skipping(batch[res.index].name(), res.err)
+						skipReason[from+res.index] = failureReason(res.err)
+						continue
+					}
+					return res.err
+				}
+				if err := add(from+res.index, res.data, res.cached); err != nil {
+					return err
+				}
+				if enough() {
+					// release the stragglers' connections now rather than on return
+					cancel(errEnoughReadings)
+					for j, ok := range answered {
+						if !ok {
+							skipReason[from+j] = skipNotNeeded
+						}
+					}
+					return nil
+				}
+			case <-ctx.Done():
+				if callerGone() {
+					return parent.Err()
+				}
+				for j, ok := range answered {
+					if !ok && opts.required[batch[j].name()] {
+						return requiredFailed(batch[j], ctx.Err())
+					}
+					if !ok {
+						timedOut = append(timedOut, batch[j].name())
+						skipReason[from+j] = skipTimedOut
+					}
+				}
+				return nil
+			}
+		}
+		return nil
+	}
+
+	// with opts.maxCall only that many providers are asked, counting those asked
+	// above, and the rest are backups asked only to make up for a shortfall
+	next := i
+	if !enough() {
+		to := len(w)
+		if opts.maxCall > 0 {
+			to = min(len(w), max(next, opts.maxCall))
+		}
+		if err := askBatch(next, to); err != nil {
+			return aggregate{}, err
+		}
+		next = to
+
+		for next < len(w) && ctx.Err() == nil && (count < max(opts.minReadings, 1) || pending > 0) {
+			to := min(len(w), next+max(max(opts.minReadings, 1)-count, 1))
+			if err := askBatch(next, to); err != nil {
+				return aggregate{}, err
+			}
+			next = to
+		}
+	}
+	for j := next; j < len(w); j++ {
+		skipReason[j] = skipNotNeeded
+	}
+
+	// readings go to the aggregator in provider order, whatever order they arrived in
+	readings := make([]providerReading, 0, count)
+	var resolved aggregate
+	for index, wd := range valid {
+		if !isValid[index] {
+			if skipReason[index] != "" {
+				skipped = append(skipped, skippedProvider{Provider: w[index].name(), Reason: skipReason[index], HTTPStatus: statuses[index]})
+			}
+			continue
+		}
+		readings = append(readings, providerReading{provider: w[index].name(), data: wd, fromCache: fromCache[index]})
+
+		// the first provider to report coordinates says where the readings are from
+		if resolved.ResolvedLatitude == 0.0 && resolved.ResolvedLongitude == 0.0 {
+			resolved.ResolvedLatitude, resolved.ResolvedLongitude = wd.Latitude, wd.Longitude
+		}
+	}
+
+	if len(readings) == 0 && len(timedOut) > 0 {
+		return aggregate{}, ctx.Err()
+	}
+	if len(readings) == 0 {
+		return aggregate{}, errNoData
+	}
+
+	aggregator := opts.aggregator
+	if aggregator == nil {
+		aggregator = meanAggregator{}
+	}
+
+	var result weatherData
+	var err error
+	if near, ok := aggregator.(nearAggregator); ok {
+		result, err = near.AggregateNear(readings, lat, long)
+	} else {
+		result, err = aggregator.Aggregate(readings)
+	}
+	if err != nil {
+		return aggregate{}, err
+	}
+	// log.Printf("DEBUG aggregate temp %.2f°C", result.Celsius)
+
+	if result.Conditions == "" {
+		result.Conditions = commonConditions(readings)
+	}
+	if result.Sunrise.IsZero() && result.Sunset.IsZero() {
+		result.Sunrise, result.Sunset = sunTimes(readings)
+	}
+	if !result.HasFeelsLike {
+		result.FeelsLike, result.HasFeelsLike = meanFeelsLike(readings)
+	}
+	if !result.HasCloudCover {
+		result.CloudCover, result.HasCloudCover = meanCloudCover(readings)
+	}
+
+	result.Latitude = lat
+	result.Longitude = long
+
+	resolved.weatherData = result
+	resolved.TimedOut = timedOut
+	resolved.Readings = readings
+	resolved.CoordFallback = coordFallback
+	resolved.Skipped = skipped
+	resolved.Queried, resolved.Succeeded = queried, succeeded
+	for index, d := range took {
+		if d > 0 {
+			if resolved.Timings == nil {
+				resolved.Timings = make(map[string]time.Duration, len(w))
+			}
+			resolved.Timings[w[index].name()] = d
+		}
+	}
+	return resolved, nil
+}
+
+// geocode asks g for the city's coordinates, giving up with ErrGeocodeTimeout
+// after timeout unless that is zero.
+func geocode(ctx context.Context, g Geocoder, city string, timeout time.Duration) (float64, float64, error) {
+	if timeout <= 0 {
+		return g.Geocode(ctx, city)
+	}
+
+	gctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	lat, long, err := g.Geocode(gctx, city)
+	if err != nil && ctx.Err() == nil && errors.Is(gctx.Err(), context.DeadlineExceeded) {
+		return 0, 0, ErrGeocodeTimeout
+	}
+	return lat, long, err
+}
+
+// ask calls a single provider, timing it on clock, and records the outcome in its stats.
+func ask(ctx context.Context, clock Clock, p weatherProvider, city string, lat float64, long float64) (weatherData, error) {
+	if err := waitStartJitter(ctx); err != nil {
+		return weatherData{}, err
+	}
+	release, err := acquireProviderSlot(ctx)
+	if err != nil {
+		return weatherData{}, err
+	}
+	defer release()
+
+	name := p.name()
+	ps := stats.provider(name)
+	ps.calls.Add(1)
+
+	ctx, done := captureRaw(ctx, name)
+	defer done()
+	ctx, status := withStatusRecorder(ctx)
+
+	began := clock.Now()
+	wd, err := p.temperature(ctx, city, lat, long)
+	wd.HTTPStatus = int(status.Load())
+	wd.Took = clock.Since(began)
+	if err != nil {
+		// a provider cut short because the others were enough didn't fail
+		if !errors.Is(context.Cause(ctx), errEnoughReadings) {
+			ps.failures.Add(1)
+			ps.lastSucceeded.Store(false)
+		}
+	} else {
+		ps.successes.Add(1)
+		ps.lastSucceeded.Store(true)
+	}
+	return wd, err
+}
+
+// decodeJSON decodes a provider's response body into v, reading at most maxResponseBytes.
+// The body is also copied for ?raw=1 when ctx asks for it. A status other than 2xx
+// is a statusError and the body isn't read.
+//
+// The transport only decompresses gzip bodies it asked for itself, so when a
+// forwarded Accept-Encoding asked instead the body is decompressed here. The
+// limit then applies to the decompressed body.
+func decodeJSON(ctx context.Context, resp *http.Response, v interface{}) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{code: resp.StatusCode}
+	}
+
+	raw := resp.Body
+	if strings.EqualFold(strings.TrimSpace(resp.Header.Get("Content-Encoding")), "gzip") {
+		zr, err := gzip.NewReader(resp.Body)
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: truncated response: %v", ErrProviderUnavailable, err)
+		}
+		if err != nil {
+			return fmt.Errorf("gzip response: %w", err)
+		}
+		defer zr.Close()
+		raw = zr
+	}
+
+	body := io.Reader(http.MaxBytesReader(nil, raw, maxResponseBytes))
+	if buf := rawBuffer(ctx); buf != nil {
+		body = io.TeeReader(body, buf)
+	}
+
+	err := json.NewDecoder(body).Decode(v)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: over %d bytes", errResponseTooLarge, tooLarge.Limit)
+	}
+
+	// a body that ends early means the connection went away, not that the provider is broken
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: truncated response: %v", ErrProviderUnavailable, err)
+	}
+	return err
+}
+
+// getWithKeys gets the URL urlFor makes with the primary key, and when the
+// provider rejects that with a 401 or 403 gets it again with the secondary key,
+// so keys can be rotated without dropping requests. The last response is
+// returned either way.
+func getWithKeys(ctx context.Context, name string, primary string, secondary string, urlFor func(key string) string) (*http.Response, error) {
+	resp, err := get(ctx, urlFor(primary))
+	if err != nil || secondary == "" || !keyRejected(resp.StatusCode) {
+		return resp, err
+	}
+	primaryStatus := resp.StatusCode
+	resp.Body.Close()
+
+	resp, err = get(ctx, urlFor(secondary))
+	if err != nil {
+		return nil, err
+	}
+	if keyRejected(resp.StatusCode) {
+		log.Printf("%s rejected both keys, %d and %d", name, primaryStatus, resp.StatusCode)
+	} else {
+		log.Printf("%s rejected the primary key with %d, the secondary key worked", name, primaryStatus)
 	}
+	return resp, nil
+}
 
-	avg := sum / float64(n)
-	// log.Printf("DEBUG avg temp %.2f°C", avg)
+func keyRejected(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
 
-	return lat, long, avg, nil
+// get issues a GET request that is abandoned once ctx is done.
+func get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	forwardHeaders(ctx, req)
+	resp, err := clientFor(ctx).Do(req)
+	if err == nil {
+		recordStatus(ctx, resp.StatusCode)
+	}
+	return resp, err
 }
 
-type openWeatherMap struct{}
+type openWeatherMap struct {
+	apiKey string
+	secondaryKey string // tried when apiKey is rejected, during a rotation
+}
 
-func (w openWeatherMap) temperature(city string, lat float64, long float64) (weatherData, error) {
-	// NOTE this api only uses the city string
-	url := fmt.Sprintf("http://api.openweathermap.org/data/2.5/weather?APPID=aa863cffe90108e0d8be0840d87de50f&q=%s", city)
-	// log.Printf("DEBUG openWeatherMap url: %s", url)
-	resp, err := http.Get(url)
+func (w openWeatherMap) name() string { return "openweathermap" }
+
+func (w openWeatherMap) temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+	// NOTE this api uses the city string, or the coordinates when there is no city
+	urlFor := func(key string) string {
+		if city == "" && (lat != 0.0 || long != 0.0) {
+			return fmt.Sprintf("http://api.openweathermap.org/data/2.5/weather?APPID=%s&lat=%.4f&lon=%.4f", key, lat, long)
+		}
+		return fmt.Sprintf("http://api.openweathermap.org/data/2.5/weather?APPID=%s&q=%s", key, neturl.QueryEscape(city))
+	}
+	// log.Printf("DEBUG openWeatherMap url: %s", urlFor(w.apiKey))
+	resp, err := getWithKeys(ctx, "openweathermap", w.apiKey, w.secondaryKey, urlFor)
 	if err != nil {
 		return weatherData{}, err
 	}
@@ -109,8 +1010,26 @@ func (w openWeatherMap) temperature(city string, lat float64, long float64) (wea
 	// define the "query"
 	var d struct {
 		Main struct {
-			Kelvin float64 `json:"temp"`
+			Kelvin flexFloat `json:"temp"`
+			FeelsLike *flexFloat `json:"feels_like"`
+			Pressure float64 `json:"pressure"`
 		} `json:"main"`
+		Wind struct {
+			Speed float64 `json:"speed"`
+		} `json:"wind"`
+		Clouds struct {
+			All *flexFloat `json:"all"` // percent
+		} `json:"clouds"`
+		Weather []struct {
+			Description string `json:"description"`
+		} `json:"weather"`
+		Time int64 `json:"dt"`
+		Timezone int `json:"timezone"` // seconds east of UTC
+		Sys struct {
+			Sunrise int64 `json:"sunrise"`
+			Sunset int64 `json:"sunset"`
+		} `json:"sys"`
+		Name string `json:"name"`
 		Coord struct {
 			Latitude float64 `json:"lat"`
 			Longitude float64 `json:"lon"`
@@ -118,12 +1037,12 @@ func (w openWeatherMap) temperature(city string, lat float64, long float64) (wea
 	}
 
 	// grab the data
-	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+	if err := decodeJSON(ctx, resp, &d); err != nil {
 		return weatherData{}, err
 	}
 
 	// do the conversions
-	k := d.Main.Kelvin
+	k := float64(d.Main.Kelvin)
 	c, err := kelvinToCelsius(k)
 	if err != nil {
 		return weatherData{}, err
@@ -135,19 +1054,54 @@ func (w openWeatherMap) temperature(city string, lat float64, long float64) (wea
 
 	// build the data response
 	wd := weatherData {
+		HasReading: k > 0, // a missing temp decodes as 0 K
 		Celsius: c,
 		Fahrenheit: f,
 		Kelvin: k,
+		WindSpeed: d.Wind.Speed,
+		Pressure: d.Main.Pressure,
+		NativeUnit: "k",
+		LocationName: d.Name,
+	}
+
+	if len(d.Weather) > 0 {
+		wd.Conditions = d.Weather[0].Description
+	}
+
+	if d.Clouds.All != nil {
+		wd.CloudCover = math.Min(math.Max(float64(*d.Clouds.All), 0), 100)
+		wd.HasCloudCover = true
+	}
+
+	if d.Main.FeelsLike != nil {
+		if wd.FeelsLike, err = kelvinToCelsius(float64(*d.Main.FeelsLike)); err != nil {
+			return weatherData{}, err
+		}
+		wd.HasFeelsLike = true
+	}
+
+	if d.Time > 0 {
+		wd.Observed = time.Unix(d.Time, 0)
+	}
+
+	// sunrise and sunset are given in UTC, shown in local time
+	zone := time.FixedZone("", d.Timezone)
+	if d.Sys.Sunrise > 0 {
+		wd.Sunrise = time.Unix(d.Sys.Sunrise, 0).In(zone)
+	}
+	if d.Sys.Sunset > 0 {
+		wd.Sunset = time.Unix(d.Sys.Sunset, 0).In(zone)
 	}
 
-	if lat == 0 && long == 0 {
-		if d.Coord.Latitude != 0.0 && d.Coord.Longitude != 0.0 {
-			wd.Latitude = d.Coord.Latitude
-			wd.Longitude = d.Coord.Longitude
+	// asked by coordinates it sends them back, which isn't where the reading is from
+	if d.Coord.Latitude != 0.0 && d.Coord.Longitude != 0.0 && !echoesRequest(lat, long, d.Coord.Latitude, d.Coord.Longitude) {
+		wd.Latitude = d.Coord.Latitude
+		wd.Longitude = d.Coord.Longitude
+		if lat == 0 && long == 0 {
 			log.Printf("Latitude %.4f and longitude %.4f returned for %s", d.Coord.Latitude, d.Coord.Longitude, city)
-		} else {
-			log.Printf("No latitude and longitude returned for %s", city)
 		}
+	} else if lat == 0 && long == 0 {
+		log.Printf("No latitude and longitude returned for %s", city)
 	}
 
 	// log.Printf("DEBUG openWeatherMap: %s: %.2f°C (%.4f, %.4f)", city, wd.Celsius, wd.Latitude, wd.Longitude)
@@ -156,56 +1110,80 @@ func (w openWeatherMap) temperature(city string, lat float64, long float64) (wea
 
 type darkSky struct {
 	apiKey string
+	secondaryKey string // tried when apiKey is rejected, during a rotation
 }
 
-func (w darkSky) temperature(city string, lat float64, long float64) (weatherData, error) {
-	// NOTE this api only uses the latitude and longitude
-	url := fmt.Sprintf("https://api.darksky.net/forecast/%s/%.4f,%.4f?exclude=minutely,hourly,daily,alerts", w.apiKey, lat, long)
-	// log.Printf(url)
-	resp, err := http.Get(url)
-	if err != nil {
-		return weatherData{}, err
-	}
+func (w darkSky) name() string { return "darksky" }
 
+// hasHistory is true since dark sky's time machine requests give the readings
+// at a past time in the same shape as the current ones.
+func (w darkSky) hasHistory() bool { return true }
+
+func (w darkSky) temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
 	// can't use this api without latitude and longitude
 	if lat == 0.0 && long == 0.0 {
-		log.Printf("No lat and long canceling darkSky call")
-		return weatherData{}, err
+		log.Printf("No lat and long skipping darkSky call for %s", city)
+		return weatherData{}, nil
 	}
 
+	// NOTE this api only uses the latitude and longitude
+	urlFor := func(key string) string {
+		if at, past := historyTime(ctx); past {
+			return fmt.Sprintf("https://api.darksky.net/forecast/%s/%.4f,%.4f,%d?exclude=minutely,hourly,daily,alerts", key, lat, long, at.Unix())
+		}
+		return fmt.Sprintf("https://api.darksky.net/forecast/%s/%.4f,%.4f?exclude=minutely,hourly,daily,alerts", key, lat, long)
+	}
+	// log.Printf(urlFor(w.apiKey))
+	resp, err := getWithKeys(ctx, "darksky", w.apiKey, w.secondaryKey, urlFor)
+	if err != nil {
+		return weatherData{}, err
+	}
 	defer resp.Body.Close()
 
 	// define the "query"
 	var d struct {
 		Currently struct {
-			Temperature float64 `json:"temperature"`
+			Temperature flexFloat `json:"temperature"`
+			WindSpeed float64 `json:"windSpeed"`
+			Pressure float64 `json:"pressure"`
+			Time int64 `json:"time"`
 		} `json:"currently"`
 		Flags struct {
 			Units string `json:"units"`
+			Sources []string `json:"sources"`
+			NearestStation *float64 `json:"nearest-station"` // km
 		} `json:"flags"`
 	}
 
 	// grab the data
-	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+	if err := decodeJSON(ctx, resp, &d); err != nil {
 		return weatherData{}, err
 	}
 
 	// do the conversions
 	c := 0.0
 	f := 0.0
+	ws := d.Currently.WindSpeed // m/s unless the units are us
+	native := "c"
 	u := d.Flags.Units
 
 	// working around a bug in dark sky's algorithm seems to always return us units
 	// but i want to plan for it maybe being fixed at some point
 	if strings.Compare(u, "us") == 0 {
-		f = d.Currently.Temperature
+		native = "f"
+		f = float64(d.Currently.Temperature)
 		c, err = fahrenheitToCelsius(f)
 		if err != nil {
 			log.Printf("failed to convert %.4f°F to Celsius. %s", f, err)
 			return weatherData{}, err
 		}
+		ws, err = mphToMetresPerSecond(d.Currently.WindSpeed)
+		if err != nil {
+			log.Printf("failed to convert %.4fmph to m/s. %s", d.Currently.WindSpeed, err)
+			return weatherData{}, err
+		}
 	} else if strings.Compare(u, "si") == 0 {
-		c = d.Currently.Temperature
+		c = float64(d.Currently.Temperature)
 		f, err = celsiusToFahrenheit(c)
 		if err != nil {
 			log.Printf("failed to convert %.4f°C to Fahrenheit. %s", c, err)
@@ -222,17 +1200,114 @@ func (w darkSky) temperature(city string, lat float64, long float64) (weatherDat
 	}
 
 	wd := weatherData {
+		HasReading: true,
 		Celsius: c,
 		Fahrenheit: f,
 		Kelvin: k,
-		Latitude: lat,
-		Longitude: long,
+		WindSpeed: ws,
+		Pressure: d.Currently.Pressure,
+		NativeUnit: native,
+		Quality: map[string]string{"units": u},
+	}
+
+	if d.Currently.Time > 0 {
+		wd.Observed = time.Unix(d.Currently.Time, 0)
+	}
+	if len(d.Flags.Sources) > 0 {
+		wd.Quality["sources"] = strings.Join(d.Flags.Sources, ",")
+	}
+	if d.Flags.NearestStation != nil {
+		wd.Quality["nearest-station"] = fmt.Sprintf("%.2f", *d.Flags.NearestStation)
 	}
 
 	// log.Printf("DEBUG dark sky: %s: %.2f°C (%.4f, %.4f)", city, wd.Celsius, wd.Latitude, wd.Longitude)
 	return wd, nil
 }
 
+type weatherBit struct {
+	apiKey string
+	secondaryKey string // tried when apiKey is rejected, during a rotation
+}
+
+func (w weatherBit) name() string { return "weatherbit" }
+
+func (w weatherBit) temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+	// NOTE this api uses the city string, or the coordinates when there is no city
+	urlFor := func(key string) string {
+		if city == "" && (lat != 0.0 || long != 0.0) {
+			return fmt.Sprintf("https://api.weatherbit.io/v2.0/current?lat=%.4f&lon=%.4f&key=%s", lat, long, key)
+		}
+		return fmt.Sprintf("https://api.weatherbit.io/v2.0/current?city=%s&key=%s", neturl.QueryEscape(city), key)
+	}
+	resp, err := getWithKeys(ctx, "weatherbit", w.apiKey, w.secondaryKey, urlFor)
+	if err != nil {
+		return weatherData{}, err
+	}
+
+	defer resp.Body.Close()
+
+	// define the "query"
+	var d struct {
+		Data []struct {
+			Temp flexFloat `json:"temp"`
+			Latitude float64 `json:"lat"`
+			Longitude float64 `json:"lon"`
+			WindSpeed float64 `json:"wind_spd"`
+			Pressure float64 `json:"pres"`
+			Time int64 `json:"ts"`
+			CityName string `json:"city_name"`
+			Weather struct {
+				Description string `json:"description"`
+			} `json:"weather"`
+		} `json:"data"`
+	}
+
+	// grab the data
+	if err := decodeJSON(ctx, resp, &d); err != nil {
+		return weatherData{}, err
+	}
+	if len(d.Data) == 0 {
+		log.Printf("No weatherBit observation returned for %s", city)
+		return weatherData{}, nil
+	}
+	obs := d.Data[0]
+
+	// do the conversions
+	c := float64(obs.Temp)
+	f, err := celsiusToFahrenheit(c)
+	if err != nil {
+		return weatherData{}, err
+	}
+	k, err := celsiusToKelvin(c)
+	if err != nil {
+		return weatherData{}, err
+	}
+
+	wd := weatherData {
+		HasReading: true,
+		Celsius: c,
+		Fahrenheit: f,
+		Kelvin: k,
+		WindSpeed: obs.WindSpeed,
+		Pressure: obs.Pressure,
+		NativeUnit: "c",
+		Conditions: obs.Weather.Description,
+		LocationName: obs.CityName,
+	}
+
+	if obs.Time > 0 {
+		wd.Observed = time.Unix(obs.Time, 0)
+	}
+
+	// reported coordinates let the providers asked after us work from coordinates
+	if obs.Latitude != 0.0 && obs.Longitude != 0.0 {
+		wd.Latitude = obs.Latitude
+		wd.Longitude = obs.Longitude
+	}
+
+	return wd, nil
+}
+
 func celsiusToKelvin(c float64) (float64, error) {
 	if c < -KelvinShift {
 		return 0, errors.New("celsiusToKelvin: Out of Range")
@@ -240,6 +1315,13 @@ func celsiusToKelvin(c float64) (float64, error) {
 	return c + KelvinShift, nil
 }
 
+func celsiusToRankine(c float64) (float64, error) {
+	if c < -KelvinShift {
+		return 0, errors.New("celsiusToRankine: Out of Range")
+	}
+	return (c + KelvinShift) * 9 / 5, nil
+}
+
 func kelvinToCelsius(k float64) (float64, error) {
 	if k < 0 {
 		return 0, errors.New("kelvinToCelsius: Out of Range")
@@ -267,3 +1349,24 @@ func kelvinToFahrenheit(k float64) (float64, error) {
 	}
 	return ((k - KelvinShift) * 9 / 5) + 32, nil
 }
+
+func metresPerSecondToMph(mps float64) (float64, error) {
+	if mps < 0 {
+		return 0, errors.New("metresPerSecondToMph: Out of Range")
+	}
+	return mps * 3600 / 1609.344, nil
+}
+
+func mphToMetresPerSecond(mph float64) (float64, error) {
+	if mph < 0 {
+		return 0, errors.New("mphToMetresPerSecond: Out of Range")
+	}
+	return mph * 1609.344 / 3600, nil
+}
+
+func hectopascalsToInchesOfMercury(hpa float64) (float64, error) {
+	if hpa < 0 {
+		return 0, errors.New("hectopascalsToInchesOfMercury: Out of Range")
+	}
+	return hpa / 33.8639, nil
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeProvider answers with data and err, or with answer when it is set, after
+// delay unless ctx is done first. It counts the times it was asked.
+type fakeProvider struct {
+	id     string
+	data   weatherData
+	err    error
+	delay  time.Duration
+	answer func(ctx context.Context, city string, lat float64, long float64) (weatherData, error)
+
+	calls atomic.Int32
+}
+
+func (p *fakeProvider) name() string { return p.id }
+
+func (p *fakeProvider) temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+	p.calls.Add(1)
+	if p.delay > 0 {
+		select {
+		case <-time.After(p.delay):
+		case <-ctx.Done():
+			return weatherData{}, ctx.Err()
+		}
+	}
+	if p.answer != nil {
+		return p.answer(ctx, city, lat, long)
+	}
+	return p.data, p.err
+}
+
+// reading is a valid reading of c degrees celsius.
+func reading(c float64) weatherData {
+	return weatherData{HasReading: true, Celsius: c, Fahrenheit: c*9/5 + 32, Kelvin: c + KelvinShift, NativeUnit: "c"}
+}
+
+func TestAskTimesTheProviderOnTheClock(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	p := &fakeProvider{id: "ask-timing", answer: func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+		clock.Advance(3 * time.Second)
+		return reading(10), nil
+	}}
+
+	wd, err := ask(context.Background(), clock, p, "Bucharest", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.Took != 3*time.Second {
+		t.Errorf("took %s, want 3s", wd.Took)
+	}
+}
+
+func TestDedupCoordsCountsSharedCoordinatesOnce(t *testing.T) {
+	at := func(c float64, lat float64, long float64) weatherData {
+		wd := reading(c)
+		wd.Latitude, wd.Longitude = lat, long
+		return wd
+	}
+	mw := multiWeatherProvider{
+		&fakeProvider{id: "dedup-a", data: at(10, 44.4268, 26.1025)},
+		&fakeProvider{id: "dedup-b", data: at(20, 44.42681, 26.10249)},
+		&fakeProvider{id: "dedup-c", data: at(30, 44.5, 26.2)},
+	}
+
+	agg, err := mw.temperature(context.Background(), "Bucharest", 0, 0, aggregateOptions{dedupCoords: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg.Celsius != 20 || len(agg.Readings) != 2 {
+		t.Errorf("got %.2f°C from %d readings, want 20.00°C from dedup-a and dedup-c", agg.Celsius, len(agg.Readings))
+	}
+	if len(agg.Skipped) != 1 || agg.Skipped[0] != (skippedProvider{Provider: "dedup-b", Reason: skipDuplicate}) {
+		t.Errorf("skipped %+v, want dedup-b as a duplicate", agg.Skipped)
+	}
+
+	agg, err = mw.temperature(context.Background(), "Bucharest", 0, 0, aggregateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agg.Readings) != 3 {
+		t.Errorf("got %d readings without dedupCoords, want 3", len(agg.Readings))
+	}
+}
+
+func TestDedupCoordsKeepsReadingsWithoutCoordinates(t *testing.T) {
+	mw := multiWeatherProvider{
+		&fakeProvider{id: "dedup-echo-a", data: reading(10)},
+		&fakeProvider{id: "dedup-echo-b", data: reading(20)},
+	}
+	agg, err := mw.temperature(context.Background(), "", 44.4268, 26.1025, aggregateOptions{dedupCoords: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agg.Readings) != 2 {
+		t.Errorf("got %d readings, want both: neither resolved coordinates of its own", len(agg.Readings))
+	}
+}
+
+func TestCoordinateProvidersDontEchoTheirCoordinates(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.darksky.net":        {body: `{"latitude":44.4268,"longitude":26.1025,"currently":{"temperature":50,"windSpeed":3,"time":1700000000},"flags":{"units":"us"}}`},
+		"api.met.no":             {body: `{"geometry":{"coordinates":[26.1025,44.4268,80]},"properties":{"timeseries":[{"time":"2024-01-01T12:00:00Z","data":{"instant":{"details":{"air_temperature":3.5}}}}]}}`},
+		"api.openweathermap.org": {body: `{"coord":{"lon":26.1025,"lat":44.4268},"main":{"temp":285.65}}`},
+		"api.open-meteo.com":     {body: `{"latitude":44.4268,"longitude":26.1025,"current_weather":{"temperature":12.4,"time":1700000000}}`},
+	})
+	for _, p := range []weatherProvider{darkSky{apiKey: "key"}, metNo{}, openWeatherMap{apiKey: "key"}, openMeteo{}} {
+		wd, err := p.temperature(ctx, "", 44.4268, 26.1025)
+		if err != nil {
+			t.Errorf("%s: %s", p.name(), err)
+			continue
+		}
+		if !wd.valid() {
+			t.Errorf("%s: no reading", p.name())
+		}
+		if wd.Latitude != 0 || wd.Longitude != 0 {
+			t.Errorf("%s: reported %.4f, %.4f, the coordinates it was asked for", p.name(), wd.Latitude, wd.Longitude)
+		}
+	}
+}
+
+// limitResponses sets maxResponseBytes for the rest of the test.
+func limitResponses(t *testing.T, n int64) {
+	saved := maxResponseBytes
+	maxResponseBytes = n
+	t.Cleanup(func() { maxResponseBytes = saved })
+}
+
+func TestDecodeJSONRejectsOversizedBodies(t *testing.T) {
+	limitResponses(t, 64)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":%q}`, strings.Repeat("x", 1024))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	resp, err := get(ctx, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var v struct{ Name string }
+	err = decodeJSON(ctx, resp, &v)
+	if !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("got %v, want errResponseTooLarge", err)
+	}
+}
+
+func TestProviderRejectsOversizedBodies(t *testing.T) {
+	limitResponses(t, 64)
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.open-meteo.com": {body: `{"current_weather":{"temperature":3.5},"padding":"` + strings.Repeat("x", 1024) + `"}`},
+	})
+
+	_, err := openMeteo{}.temperature(ctx, "", 44.4268, 26.1025)
+	if !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("got %v, want errResponseTooLarge", err)
+	}
+}
+
+func TestDecodeJSONTruncatedBodyIsUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// promise more than is sent, so the connection closes mid-body
+		w.Header().Set("Content-Length", "100")
+		fmt.Fprint(w, `{"current_weather":{"tempera`)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	resp, err := get(ctx, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var v map[string]interface{}
+	err = decodeJSON(ctx, resp, &v)
+	if !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("got %v, want ErrProviderUnavailable", err)
+	}
+}
+
+// gzipped is body compressed with gzip.
+func gzipped(body string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(body))
+	zw.Close()
+	return buf.String()
+}
+
+func TestDecodeJSONGzipBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		body := gzipped(`{"current_weather":{"temperature":3.5}}`)
+		if r.URL.Path == "/large" {
+			body = gzipped(`{"padding":"` + strings.Repeat("x", 1024) + `"}`)
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	// asking for gzip ourselves, as a forwarded Accept-Encoding does, stops
+	// the transport decompressing the body
+	fetch := func(path string, v interface{}) error {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return decodeJSON(context.Background(), resp, v)
+	}
+
+	var v struct {
+		CurrentWeather struct{ Temperature float64 } `json:"current_weather"`
+	}
+	if err := fetch("/", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.CurrentWeather.Temperature != 3.5 {
+		t.Errorf("decoded %v, want 3.5", v.CurrentWeather.Temperature)
+	}
+
+	// the limit applies to the decompressed body
+	limitResponses(t, 64)
+	if err := fetch("/large", &v); !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("got %v, want errResponseTooLarge", err)
+	}
+}
+
+func TestProviderDecodesGzipBody(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.open-meteo.com": {body: gzipped(`{"current_weather":{"temperature":3.5}}`), header: http.Header{"Content-Encoding": {"gzip"}}},
+	})
+
+	wd, err := openMeteo{}.temperature(ctx, "", 44.4268, 26.1025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wd.valid() || wd.Celsius != 3.5 {
+		t.Errorf("got %.2f°C, valid %t, want 3.50°C", wd.Celsius, wd.valid())
+	}
+}
+
+func TestTruncatedProviderIsSkipped(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.open-meteo.com": {body: `{"current_weather":{"temperature":3`},
+	})
+	mw := multiWeatherProvider{&fakeProvider{id: "truncated-ok", data: reading(10)}, openMeteo{}}
+
+	agg, err := mw.temperature(ctx, "", 44.4268, 26.1025, aggregateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agg.Readings) != 1 || agg.Celsius != 10 {
+		t.Errorf("got %.2f°C from %d readings, want the 10.00°C of the provider that answered", agg.Celsius, len(agg.Readings))
+	}
+	if len(agg.Skipped) != 1 || agg.Skipped[0].Provider != "openmeteo" || agg.Skipped[0].Reason != skipUnavailable {
+		t.Errorf("skipped %+v, want openmeteo as unavailable", agg.Skipped)
+	}
+}
+
+func TestMinReadingsReturnsAfterEnoughAnswers(t *testing.T) {
+	cut := make(chan error, 1)
+	mw := multiWeatherProvider{
+		&fakeProvider{id: "early-first", data: reading(10)},
+		&fakeProvider{id: "early-second", data: reading(20), delay: 20 * time.Millisecond},
+		&fakeProvider{id: "early-straggler", answer: func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+			<-ctx.Done()
+			cut <- context.Cause(ctx)
+			return weatherData{}, ctx.Err()
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// with coordinates all three are asked at once
+	agg, err := mw.temperature(ctx, "", 44.4268, 26.1025, aggregateOptions{minReadings: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("waited for the straggler")
+	}
+	if agg.Celsius != 15 || len(agg.Readings) != 2 {
+		t.Errorf("got %.2f°C from %d readings, want 15.00°C from the first two", agg.Celsius, len(agg.Readings))
+	}
+	if len(agg.Skipped) != 1 || agg.Skipped[0] != (skippedProvider{Provider: "early-straggler", Reason: skipNotNeeded}) {
+		t.Errorf("skipped %+v, want early-straggler as not needed", agg.Skipped)
+	}
+	if cause := <-cut; !errors.Is(cause, errEnoughReadings) {
+		t.Errorf("straggler cut short by %v, want errEnoughReadings", cause)
+	}
+}
+
+func TestEnoughReadingsCancelsSlowProviders(t *testing.T) {
+	exited := make(chan error)
+	mw := multiWeatherProvider{
+		&fakeProvider{id: "cancel-fast-a", data: reading(10)},
+		&fakeProvider{id: "cancel-fast-b", data: reading(20)},
+		&fakeProvider{id: "cancel-slow", answer: func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+			defer func() { exited <- ctx.Err() }()
+			select {
+			case <-ctx.Done():
+				return weatherData{}, ctx.Err()
+			case <-time.After(time.Minute):
+				return reading(30), nil
+			}
+		}},
+	}
+
+	if _, err := mw.temperature(context.Background(), "", 44.4268, 26.1025, aggregateOptions{minReadings: 2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-exited:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("slow provider's context ended with %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow provider still running after the aggregate returned")
+	}
+}
+
+func TestEnoughReadingsCancelsSlowRequests(t *testing.T) {
+	ctx, transport := withCanned(map[string]cannedResponse{
+		"api.open-meteo.com": {body: `{"current_weather":{"temperature":3.5}}`, delay: time.Minute},
+	})
+	mw := multiWeatherProvider{
+		// slow enough for open-meteo's request to be on its way
+		&fakeProvider{id: "cancel-request-a", data: reading(10), delay: 50 * time.Millisecond},
+		&fakeProvider{id: "cancel-request-b", data: reading(20), delay: 50 * time.Millisecond},
+		openMeteo{},
+	}
+
+	if _, err := mw.temperature(ctx, "", 44.4268, 26.1025, aggregateOptions{minReadings: 2}); err != nil {
+		t.Fatal(err)
+	}
+	sent := transport.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d requests, want the one to open-meteo", len(sent))
+	}
+	for _, req := range sent {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+			t.Errorf("request to %s still open after the aggregate returned", req.URL.Host)
+		}
+	}
+}
+
+func TestRequiredProviders(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name     string
+		required *fakeProvider
+		wantErr  bool
+	}{
+		{"required answers", &fakeProvider{id: "required-ok", data: reading(20)}, false},
+		{"required fails", &fakeProvider{id: "required-fails", err: boom}, true},
+		{"required has no reading", &fakeProvider{id: "required-empty"}, true},
+		{"required times out", &fakeProvider{id: "required-slow", data: reading(20), delay: time.Minute}, true},
+	}
+	for _, tt := range tests {
+		optional := &fakeProvider{id: "optional-fails", err: boom}
+		other := &fakeProvider{id: "optional-ok", data: reading(10)}
+		mw := multiWeatherProvider{other, optional, tt.required}
+		opts := aggregateOptions{required: map[string]bool{tt.required.id: true}}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		agg, err := mw.temperature(ctx, "", 44.4268, 26.1025, opts)
+		cancel()
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), tt.required.id) {
+				t.Errorf("%s: got %v, want an error naming %s", tt.name, err, tt.required.id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		// the optional provider's failure is passed over
+		if agg.Celsius != 15 {
+			t.Errorf("%s: got %.2f°C, want 15.00°C", tt.name, agg.Celsius)
+		}
+		if len(agg.Skipped) != 1 || agg.Skipped[0] != (skippedProvider{Provider: "optional-fails", Reason: skipFailed}) {
+			t.Errorf("%s: skipped %+v, want optional-fails as failed", tt.name, agg.Skipped)
+		}
+	}
+}
+
+func TestMaxCallKeepsTheRestAsBackups(t *testing.T) {
+	tests := []struct {
+		name      string
+		first     *fakeProvider
+		wantCalls []int32
+	}{
+		{"happy path", &fakeProvider{id: "maxcall-a", data: reading(10)}, []int32{1, 1, 0, 0}},
+		{"one fails", &fakeProvider{id: "maxcall-a", err: ErrProviderUnavailable}, []int32{1, 1, 1, 0}},
+	}
+	for _, tt := range tests {
+		mw := multiWeatherProvider{
+			tt.first,
+			&fakeProvider{id: "maxcall-b", data: reading(12)},
+			&fakeProvider{id: "maxcall-c", data: reading(14)},
+			&fakeProvider{id: "maxcall-d", data: reading(16)},
+		}
+		opts := aggregateOptions{maxCall: 2, minReadings: 2}
+		if _, err := mw.temperature(context.Background(), "", 44.4268, 26.1025, opts); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		for i, p := range mw {
+			if got := p.(*fakeProvider).calls.Load(); got != tt.wantCalls[i] {
+				t.Errorf("%s: %s asked %d times, want %d", tt.name, p.name(), got, tt.wantCalls[i])
+			}
+		}
+	}
+}
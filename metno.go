@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// metNo uses MET Norway's Locationforecast API, which needs no key but only
+// takes coordinates and refuses requests without a User-Agent, which get sets.
+type metNo struct{}
+
+func (w metNo) name() string { return "metno" }
+
+func (w metNo) temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+	// can't use this api without latitude and longitude
+	if lat == 0.0 && long == 0.0 {
+		log.Printf("No lat and long skipping metNo call for %s", city)
+		return weatherData{}, nil
+	}
+
+	// MET asks for no more than four decimals, so that responses can be cached
+	u := fmt.Sprintf("https://api.met.no/weatherapi/locationforecast/2.0/compact?lat=%.4f&lon=%.4f", lat, long)
+	resp, err := get(ctx, u)
+	if err != nil {
+		return weatherData{}, err
+	}
+	defer resp.Body.Close()
+
+	var d struct {
+		Properties struct {
+			Timeseries []struct {
+				Time time.Time `json:"time"`
+				Data struct {
+					Instant struct {
+						Details struct {
+							AirTemperature *flexFloat `json:"air_temperature"`
+							WindSpeed      float64    `json:"wind_speed"`
+							Pressure       float64    `json:"air_pressure_at_sea_level"`
+						} `json:"details"`
+					} `json:"instant"`
+				} `json:"data"`
+			} `json:"timeseries"`
+		} `json:"properties"`
+	}
+	if err := decodeJSON(ctx, resp, &d); err != nil {
+		return weatherData{}, err
+	}
+
+	// the first entry of the forecast is the current hour
+	if len(d.Properties.Timeseries) == 0 || d.Properties.Timeseries[0].Data.Instant.Details.AirTemperature == nil {
+		log.Printf("No metNo reading returned for %.4f, %.4f", lat, long)
+		return weatherData{}, nil
+	}
+	now := d.Properties.Timeseries[0]
+	details := now.Data.Instant.Details
+
+	c := float64(*details.AirTemperature)
+	f, err := celsiusToFahrenheit(c)
+	if err != nil {
+		return weatherData{}, err
+	}
+	k, err := celsiusToKelvin(c)
+	if err != nil {
+		return weatherData{}, err
+	}
+
+	return weatherData{
+		HasReading: true,
+		Celsius:    c,
+		Fahrenheit: f,
+		Kelvin:     k,
+		Observed:   now.Time,
+		WindSpeed:  details.WindSpeed,
+		Pressure:   details.Pressure,
+		NativeUnit: "c",
+	}, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readMsgpack decodes the subset of MessagePack writeMsgpack writes.
+func readMsgpack(r *bytes.Reader) (interface{}, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := func(size int) (int, error) {
+		var n uint64
+		for i := 0; i < size; i++ {
+			c, err := r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			n = n<<8 | uint64(c)
+		}
+		return int(n), nil
+	}
+
+	var n int
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b == 0xc0:
+		return nil, nil
+	case b == 0xc2, b == 0xc3:
+		return b == 0xc3, nil
+	case b == 0xd3:
+		var v int64
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	case b == 0xcb:
+		var bits uint64
+		err := binary.Read(r, binary.BigEndian, &bits)
+		return math.Float64frombits(bits), err
+	case b&0xe0 == 0xa0, b == 0xd9, b == 0xda, b == 0xdb:
+		switch b {
+		case 0xd9:
+			n, err = length(1)
+		case 0xda:
+			n, err = length(2)
+		case 0xdb:
+			n, err = length(4)
+		default:
+			n = int(b & 0x1f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s := make([]byte, n)
+		_, err := io.ReadFull(r, s)
+		return string(s), err
+	case b&0xf0 == 0x90, b == 0xdc, b == 0xdd:
+		switch b {
+		case 0xdc:
+			n, err = length(2)
+		case 0xdd:
+			n, err = length(4)
+		default:
+			n = int(b & 0x0f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			if a[i], err = readMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case b&0xf0 == 0x80, b == 0xde, b == 0xdf:
+		switch b {
+		case 0xde:
+			n, err = length(2)
+		case 0xdf:
+			n, err = length(4)
+		default:
+			n = int(b & 0x0f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := readMsgpack(r)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key %#v is not a string", k)
+			}
+			if m[key], err = readMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unexpected msgpack byte %#x", b)
+}
+
+// unmarshalMsgpack decodes data into v by way of JSON, the reverse of marshalMsgpack.
+func unmarshalMsgpack(data []byte, v interface{}) error {
+	r := bytes.NewReader(data)
+	generic, err := readMsgpack(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%d bytes left over", r.Len())
+	}
+	js, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	many := make([]interface{}, 20)
+	for i := range many {
+		many[i] = i - 10
+	}
+	want := map[string]interface{}{
+		"small":    float64(7),
+		"negative": float64(-5),
+		"big":      float64(-100000),
+		"float":    12.34,
+		"nothing":  nil,
+		"yes":      true,
+		"no":       false,
+		"short":    "Bucharest",
+		"long":     strings.Repeat("x", 300),
+		"longer":   strings.Repeat("y", 70000),
+		"list":     many,
+		"nested":   map[string]interface{}{"a": "b"},
+	}
+	encoded, err := marshalMsgpack(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := unmarshalMsgpack(encoded, &got); err != nil {
+		t.Fatal(err)
+	}
+	// compare as JSON sees them, which is how marshalMsgpack reads them
+	js, _ := json.Marshal(want)
+	var wantJSON map[string]interface{}
+	json.Unmarshal(js, &wantJSON)
+	if !reflect.DeepEqual(got, wantJSON) {
+		t.Errorf("round trip gave %v, want %v", got, wantJSON)
+	}
+}
+
+func TestWeatherMsgpack(t *testing.T) {
+	wd := reading(12.34)
+	wd.Conditions = "light rain"
+	h := newRouter(testRoutes(&fakeProvider{id: "msgpack", data: wd}))
+
+	req := httptest.NewRequest(http.MethodGet, "/weather/Bucharest?sources=1", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != msgpackContentType {
+		t.Fatalf("got %d as %q, want 200 as %s", rec.Code, rec.Header().Get("Content-Type"), msgpackContentType)
+	}
+	var got weatherResponse
+	if err := unmarshalMsgpack(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := decodeWeather(t, serve(h, "/weather/Bucharest?sources=1"))
+	got.Took, want.Took = "", ""
+	got.Timings, want.Timings = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("msgpack gave %+v, json %+v", got, want)
+	}
+	if got.Temp != "12.34°C" || got.TempC != 12.34 || got.Conditions != "light rain" || len(got.Sources) != 1 {
+		t.Errorf("got %+v, want 12.34°C in light rain from one source", got)
+	}
+}
+
+func TestWeatherFallsBackToJSON(t *testing.T) {
+	h := newRouter(testRoutes(&fakeProvider{id: "msgpack-fallback", data: reading(10)}))
+	for _, accept := range []string{"", "text/html", "application/cbor, */*", "not a media type"} {
+		req := httptest.NewRequest(http.MethodGet, "/weather/Bucharest", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("Accept %q: got %q, want JSON", accept, ct)
+		}
+		decodeWeather(t, rec)
+	}
+}
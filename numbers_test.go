@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFlexFloatDecodesNumbersAndStrings(t *testing.T) {
+	tests := []struct {
+		body string
+		want flexFloat
+	}{
+		{`{"temperature": 12.3}`, 12.3},
+		{`{"temperature": "12.3"}`, 12.3},
+		{`{"temperature": -4}`, -4},
+		{`{"temperature": ""}`, 0},
+		{`{"temperature": null}`, 0},
+		{`{}`, 0},
+	}
+	for _, tt := range tests {
+		var v struct {
+			Temperature flexFloat `json:"temperature"`
+		}
+		if err := json.Unmarshal([]byte(tt.body), &v); err != nil {
+			t.Errorf("%s: %s", tt.body, err)
+			continue
+		}
+		if v.Temperature != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.body, v.Temperature, tt.want)
+		}
+	}
+}
+
+func TestFlexFloatRejectsNonNumbers(t *testing.T) {
+	for _, body := range []string{`{"temperature": "warm"}`, `{"temperature": true}`, `{"temperature": "12.3C"}`} {
+		var v struct {
+			Temperature flexFloat `json:"temperature"`
+		}
+		if err := json.Unmarshal([]byte(body), &v); err == nil {
+			t.Errorf("%s: decoded as %v, want an error", body, v.Temperature)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+)
+
+// openAPIHandler serves /openapi.json, an OpenAPI 3 description of /weather/.
+// The response schema is generated from weatherResponse, so it can't fall out
+// of step with what is served.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(openAPISpec())
+}
+
+// weatherParams are the query parameters /weather/ reads, in the order the
+// handler reads them.
+var weatherParams = []struct {
+	name        string
+	schema      map[string]interface{}
+	description string
+}{
+	{"lat", schemaOf("number"), "latitude, given with long instead of a city"},
+	{"long", schemaOf("number"), "longitude, given with lat instead of a city"},
+	{"coordprecision", schemaOf("integer"), "decimals in lat and long, 0 to 6"},
+	{"coordformat", enumOf(string(signedDecimal), string(hemisphere)), "how lat and long are written"},
+	{"units", schemaOf("string"), "comma-separated units, c, f, k or r, each given a field of its own in place of temp"},
+	{"system", enumOf(string(metric), string(imperial)), "the units of every measurement"},
+	{"locale", schemaOf("string"), "the language numbers are formatted for, otherwise taken from Accept-Language"},
+	{"exclude", schemaOf("string"), "comma-separated providers to leave out"},
+	{"at", map[string]interface{}{"type": "string", "format": "date-time"}, "a past time to give the weather at, RFC 3339, which needs lat and long unless there is a -geocoder"},
+	{"minproviders", schemaOf("integer"), "answer once this many providers have readings, 1 to the number enabled, lowering -min-providers but never raising it"},
+	{"nocache", enumOf("1"), "fetch fresh weather rather than answer from the cache"},
+	{"sources", enumOf("1"), "list the readings behind the answer and the providers skipped"},
+	{"raw", enumOf("1"), "include the providers' response bodies, with -debug only"},
+	{"fields", schemaOf("string"), "comma-separated response fields, the only ones returned"},
+	{"exclude_fields", schemaOf("string"), "comma-separated response fields to leave out"},
+}
+
+// openAPISpec builds the document served by openAPIHandler.
+func openAPISpec() map[string]interface{} {
+	params := []interface{}{
+		map[string]interface{}{
+			"name":        "city",
+			"in":          "path",
+			"required":    true,
+			"description": "the city, empty when lat and long are given",
+			"schema":      schemaOf("string"),
+		},
+	}
+	for _, p := range weatherParams {
+		params = append(params, map[string]interface{}{
+			"name":        p.name,
+			"in":          "query",
+			"description": p.description,
+			"schema":      p.schema,
+		})
+	}
+
+	body := map[string]interface{}{"$ref": "#/components/schemas/weatherResponse"}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "hello weather",
+			"version": "1.0",
+		},
+		"paths": map[string]interface{}{
+			"/weather/{city}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":    "the weather in a city or at coordinates, averaged over the providers",
+					"parameters": params,
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "the weather",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{"schema": body},
+								msgpackContentType: map[string]interface{}{"schema": body},
+							},
+						},
+						"204": map[string]interface{}{"description": "no provider had data, with -no-data-status=204"},
+						"304": map[string]interface{}{"description": "the response matches If-None-Match"},
+						"400": map[string]interface{}{"description": "a parameter is invalid"},
+						"404": map[string]interface{}{"description": "no provider had data, with -no-data-status=404"},
+						"500": map[string]interface{}{"description": "no provider had data, or fetching failed"},
+						"502": map[string]interface{}{"description": "the city couldn't be geocoded"},
+						"504": map[string]interface{}{"description": "geocoding the city timed out"},
+					},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"weatherResponse": schemaFor(reflect.TypeOf(weatherResponse{})),
+			},
+		},
+	}
+}
+
+func schemaOf(typ string) map[string]interface{} {
+	return map[string]interface{}{"type": typ}
+}
+
+func enumOf(values ...string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "enum": values}
+}
+
+// schemaFor describes how encoding/json writes a value of type t. Fields
+// without omitempty are required.
+func schemaFor(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return schemaOf("string")
+	case reflect.Bool:
+		return schemaOf("boolean")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schemaOf("integer")
+	case reflect.Float32, reflect.Float64:
+		return schemaOf("number")
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, omitEmpty, ok := jsonField(f)
+			if !ok {
+				continue
+			}
+			props[name] = schemaFor(f.Type)
+			if !omitEmpty {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	// interface{} values, such as the raw provider bodies, can be anything
+	return map[string]interface{}{}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// specSchemas is the part of the served spec the tests look at.
+type specSchemas struct {
+	OpenAPI string `json:"openapi"`
+	Paths   map[string]map[string]struct {
+		Parameters []struct {
+			Name string `json:"name"`
+			In   string `json:"in"`
+		} `json:"parameters"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]struct {
+				Type  string `json:"type"`
+				Items *struct {
+					Properties map[string]interface{} `json:"properties"`
+				} `json:"items"`
+			} `json:"properties"`
+			Required []string `json:"required"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestOpenAPISpecParses(t *testing.T) {
+	rec := serve(newRouter(testRoutes()), "/openapi.json")
+	var spec specSchemas
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("%s: %q", err, rec.Body.String())
+	}
+	if spec.OpenAPI != "3.0.3" {
+		t.Errorf("openapi %q, want 3.0.3", spec.OpenAPI)
+	}
+
+	params := map[string]string{}
+	for _, p := range spec.Paths["/weather/{city}"]["get"].Parameters {
+		params[p.Name] = p.In
+	}
+	for name, in := range map[string]string{"city": "path", "lat": "query", "units": "query", "at": "query", "sources": "query"} {
+		if params[name] != in {
+			t.Errorf("parameter %s is in %q, want %q", name, params[name], in)
+		}
+	}
+
+	resp := spec.Components.Schemas["weatherResponse"]
+	for name, typ := range map[string]string{"temp": "string", "temp_c": "number", "single_source": "boolean", "providers_queried": "integer", "sources": "array", "timings": "object"} {
+		if got := resp.Properties[name].Type; got != typ {
+			t.Errorf("property %s is %q, want %q", name, got, typ)
+		}
+	}
+	if sources := resp.Properties["sources"].Items; sources == nil || sources.Properties["quality"] == nil {
+		t.Error("sources items don't describe quality")
+	}
+	required := map[string]bool{}
+	for _, name := range resp.Required {
+		required[name] = true
+	}
+	if !required["temp_c"] || required["temp"] {
+		t.Errorf("required %v, want temp_c but not the omitempty temp", resp.Required)
+	}
+}
+
+func TestOpenAPISpecCoversTheResponse(t *testing.T) {
+	wd := reading(10)
+	wd.FeelsLike, wd.HasFeelsLike = 8, true
+	wd.LocationName = "Bucharest"
+	sc := testRoutes(&fakeProvider{id: "spec-a", data: wd}, &fakeProvider{id: "spec-b", err: ErrProviderUnavailable})
+	rec := serve(newRouter(sc), "/weather/Bucharest?sources=1")
+	var served map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+
+	var spec specSchemas
+	if err := json.Unmarshal(serve(newRouter(sc), "/openapi.json").Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	props := spec.Components.Schemas["weatherResponse"].Properties
+	for name := range served {
+		if _, ok := props[name]; !ok {
+			t.Errorf("served %s isn't in the spec", name)
+		}
+	}
+}
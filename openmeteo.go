@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// openMeteo uses Open-Meteo's forecast API, which needs no key but only takes
+// coordinates.
+type openMeteo struct{}
+
+func (w openMeteo) name() string { return "openmeteo" }
+
+// hasHistory is true since Open-Meteo's archive API has hourly readings going
+// back decades, if not for the last few days.
+func (w openMeteo) hasHistory() bool { return true }
+
+func (w openMeteo) temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+	// can't use this api without latitude and longitude
+	if lat == 0.0 && long == 0.0 {
+		log.Printf("No lat and long skipping openMeteo call for %s", city)
+		return weatherData{}, nil
+	}
+	if at, past := historyTime(ctx); past {
+		return w.temperatureAt(ctx, lat, long, at)
+	}
+
+	u := fmt.Sprintf("https://api.open-meteo.com/v1/forecast?latitude=%.4f&longitude=%.4f&current_weather=true&windspeed_unit=ms&timeformat=unixtime", lat, long)
+	resp, err := get(ctx, u)
+	if err != nil {
+		return weatherData{}, err
+	}
+	defer resp.Body.Close()
+
+	var d struct {
+		Latitude       float64 `json:"latitude"`
+		Longitude      float64 `json:"longitude"`
+		CurrentWeather struct {
+			Temperature flexFloat `json:"temperature"`
+			WindSpeed   float64   `json:"windspeed"`
+			WeatherCode *int      `json:"weathercode"`
+			Time        int64     `json:"time"`
+		} `json:"current_weather"`
+	}
+	if err := decodeJSON(ctx, resp, &d); err != nil {
+		return weatherData{}, err
+	}
+
+	c := float64(d.CurrentWeather.Temperature)
+	f, err := celsiusToFahrenheit(c)
+	if err != nil {
+		return weatherData{}, err
+	}
+	k, err := celsiusToKelvin(c)
+	if err != nil {
+		return weatherData{}, err
+	}
+
+	wd := weatherData{
+		HasReading: true,
+		Celsius:    c,
+		Fahrenheit: f,
+		Kelvin:     k,
+		WindSpeed:  d.CurrentWeather.WindSpeed,
+		NativeUnit: "c",
+	}
+	if d.CurrentWeather.WeatherCode != nil {
+		wd.Conditions = wmoConditions(*d.CurrentWeather.WeatherCode)
+	}
+	if d.CurrentWeather.Time > 0 {
+		wd.Observed = time.Unix(d.CurrentWeather.Time, 0)
+	}
+	wd.Latitude, wd.Longitude = gridCoords(lat, long, d.Latitude, d.Longitude)
+	return wd, nil
+}
+
+// temperatureAt is the archive's hourly reading nearest at. The archive lags a
+// few days behind, and hours it has nothing for yet give no reading.
+func (w openMeteo) temperatureAt(ctx context.Context, lat float64, long float64, at time.Time) (weatherData, error) {
+	day := at.UTC().Format("2006-01-02")
+	u := fmt.Sprintf("https://archive-api.open-meteo.com/v1/archive?latitude=%.4f&longitude=%.4f&start_date=%s&end_date=%s&hourly=temperature_2m,windspeed_10m,weathercode&windspeed_unit=ms&timeformat=unixtime&timezone=GMT", lat, long, day, day)
+	resp, err := get(ctx, u)
+	if err != nil {
+		return weatherData{}, err
+	}
+	defer resp.Body.Close()
+
+	var d struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Hourly    struct {
+			Time        []int64    `json:"time"`
+			Temperature []*float64 `json:"temperature_2m"`
+			WindSpeed   []*float64 `json:"windspeed_10m"`
+			WeatherCode []*int     `json:"weathercode"`
+		} `json:"hourly"`
+	}
+	if err := decodeJSON(ctx, resp, &d); err != nil {
+		return weatherData{}, err
+	}
+
+	// the hour nearest at that has a temperature
+	hour := -1
+	for i, t := range d.Hourly.Time {
+		if i >= len(d.Hourly.Temperature) || d.Hourly.Temperature[i] == nil {
+			continue
+		}
+		if hour < 0 || absDuration(time.Unix(t, 0).Sub(at)) < absDuration(time.Unix(d.Hourly.Time[hour], 0).Sub(at)) {
+			hour = i
+		}
+	}
+	if hour < 0 {
+		log.Printf("openMeteo archive has no reading for %.4f, %.4f at %s", lat, long, at.Format(time.RFC3339))
+		return weatherData{}, nil
+	}
+
+	c := *d.Hourly.Temperature[hour]
+	f, err := celsiusToFahrenheit(c)
+	if err != nil {
+		return weatherData{}, err
+	}
+	k, err := celsiusToKelvin(c)
+	if err != nil {
+		return weatherData{}, err
+	}
+
+	wd := weatherData{
+		HasReading: true,
+		Celsius:    c,
+		Fahrenheit: f,
+		Kelvin:     k,
+		Observed:   time.Unix(d.Hourly.Time[hour], 0),
+		NativeUnit: "c",
+	}
+	if hour < len(d.Hourly.WindSpeed) && d.Hourly.WindSpeed[hour] != nil {
+		wd.WindSpeed = *d.Hourly.WindSpeed[hour]
+	}
+	if hour < len(d.Hourly.WeatherCode) && d.Hourly.WeatherCode[hour] != nil {
+		wd.Conditions = wmoConditions(*d.Hourly.WeatherCode[hour])
+	}
+	wd.Latitude, wd.Longitude = gridCoords(lat, long, d.Latitude, d.Longitude)
+	return wd, nil
+}
+
+// gridCoords are the coordinates of the grid cell Open-Meteo answered for,
+// which is rarely exactly the point asked about. They are zero when it didn't
+// say or only echoed lat and long.
+func gridCoords(lat float64, long float64, gridLat float64, gridLong float64) (float64, float64) {
+	if (gridLat == 0.0 && gridLong == 0.0) || echoesRequest(lat, long, gridLat, gridLong) {
+		return 0, 0
+	}
+	return gridLat, gridLong
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// wmoCodes are the conditions for the WMO weather interpretation codes
+// Open-Meteo reports.
+var wmoCodes = map[int]string{
+	0:  "clear",
+	1:  "mainly clear",
+	2:  "partly cloudy",
+	3:  "overcast",
+	45: "fog",
+	48: "depositing rime fog",
+	51: "light drizzle",
+	53: "drizzle",
+	55: "dense drizzle",
+	56: "light freezing drizzle",
+	57: "freezing drizzle",
+	61: "light rain",
+	63: "rain",
+	65: "heavy rain",
+	66: "light freezing rain",
+	67: "freezing rain",
+	71: "light snow",
+	73: "snow",
+	75: "heavy snow",
+	77: "snow grains",
+	80: "light rain showers",
+	81: "rain showers",
+	82: "violent rain showers",
+	85: "light snow showers",
+	86: "snow showers",
+	95: "thunderstorm",
+	96: "thunderstorm with hail",
+	99: "thunderstorm with heavy hail",
+}
+
+// wmoConditions describes a WMO weather code. Codes missing from the table give
+// no conditions, so other providers' conditions win the vote.
+func wmoConditions(code int) string {
+	conditions, ok := wmoCodes[code]
+	if !ok {
+		log.Printf("unknown WMO weather code %d", code)
+	}
+	return conditions
+}
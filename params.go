@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultCoordPrecision = 4
+	maxCoordPrecision     = 6
+)
+
+// coordPrecision reads ?coordprecision=, the number of decimals used for lat/long.
+// Values outside 0-6 are clamped, anything that isn't a number is an error.
+func coordPrecision(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("coordprecision")
+	if v == "" {
+		return defaultCoordPrecision, nil
+	}
+
+	p, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("coordprecision must be a whole number between 0 and %d", maxCoordPrecision)
+	}
+
+	if p < 0 {
+		p = 0
+	} else if p > maxCoordPrecision {
+		p = maxCoordPrecision
+	}
+	return p, nil
+}
+
+// requestedUnits reads ?units=c,f,k, or any other codes in temperatureUnits. It returns nil when the parameter is absent,
+// and an error when it is present but empty or names an unknown unit.
+func requestedUnits(r *http.Request) ([]string, error) {
+	values, ok := r.URL.Query()["units"]
+	if !ok {
+		return nil, nil
+	}
+	return parseUnits(values)
+}
+
+// parseUnits reads comma-separated unit codes, dropping repeats.
+func parseUnits(values []string) ([]string, error) {
+	var units []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		for _, u := range strings.Split(v, ",") {
+			u = strings.ToLower(strings.TrimSpace(u))
+			if u == "" {
+				continue
+			}
+			if _, ok := temperatureUnits[u]; !ok {
+				return nil, fmt.Errorf("unknown unit %q, expected one of %s", u, unitCodes())
+			}
+			if !seen[u] {
+				seen[u] = true
+				units = append(units, u)
+			}
+		}
+	}
+
+	if len(units) == 0 {
+		return nil, fmt.Errorf("units must list at least one of %s", unitCodes())
+	}
+	return units, nil
+}
+
+// requestedSystem reads ?system=metric|imperial, defaulting to metric.
+func requestedSystem(r *http.Request) (measurementSystem, error) {
+	switch v := strings.ToLower(r.URL.Query().Get("system")); v {
+	case "", string(metric):
+		return metric, nil
+	case string(imperial):
+		return imperial, nil
+	default:
+		return "", fmt.Errorf("unknown system %q, expected metric or imperial", v)
+	}
+}
+
+// requestedCoords reads ?lat=&long=, which must be given together. given is
+// false when neither is set.
+func requestedCoords(r *http.Request) (lat float64, long float64, given bool, err error) {
+	q := r.URL.Query()
+	latValue, longValue := q.Get("lat"), q.Get("long")
+	if latValue == "" && longValue == "" {
+		return 0, 0, false, nil
+	}
+	if latValue == "" || longValue == "" {
+		return 0, 0, false, errors.New("lat and long must be given together")
+	}
+
+	lat, err = strconv.ParseFloat(latValue, 64)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("lat %q is not a number", latValue)
+	}
+	long, err = strconv.ParseFloat(longValue, 64)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("long %q is not a number", longValue)
+	}
+
+	if err := validateCoords(lat, long); err != nil {
+		return 0, 0, false, err
+	}
+	return lat, long, true, nil
+}
+
+// requestedExclusions reads ?exclude=darksky,weatherbit, the providers to leave
+// out of this one request. Names are matched ignoring case and an unknown
+// name is an error, so a typo doesn't quietly exclude nothing.
+func requestedExclusions(r *http.Request) (map[string]bool, error) {
+	v := r.URL.Query().Get("exclude")
+	if v == "" {
+		return nil, nil
+	}
+	excluded, err := parseProviderSet(v)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+	return excluded, nil
+}
+
+// requestedMinProviders reads ?minproviders=, which stands in for -min-providers
+// on one request. It must be between 1 and the number of enabled providers, and
+// can only lower the minimum: a value above configured is capped at it, unless
+// configured is zero. ok is false when it isn't given.
+func requestedMinProviders(r *http.Request, configured int, providers int) (n int, ok bool, err error) {
+	v := r.URL.Query().Get("minproviders")
+	if v == "" {
+		return 0, false, nil
+	}
+	n, err = strconv.Atoi(v)
+	if err != nil {
+		return 0, false, fmt.Errorf("minproviders %q is not a number", v)
+	}
+	if n < 1 || n > providers {
+		return 0, false, fmt.Errorf("minproviders must be between 1 and %d, got %d", providers, n)
+	}
+	if configured > 0 {
+		n = min(n, configured)
+	}
+	return n, true, nil
+}
+
+// noCache reports whether the request asks for fresh weather, with
+// Cache-Control: no-cache or ?nocache=1.
+func noCache(r *http.Request) bool {
+	if r.URL.Query().Get("nocache") == "1" {
+		return true
+	}
+	for _, v := range r.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errNoCity means a /weather/ path names no city, which is only fine when the
+// request gives coordinates instead.
+var errNoCity = errors.New("a city, or lat and long, is required")
+
+// cityFromPath takes the city out of a /weather/{city} path and checks it is
+// something a provider can be asked about. A path without a city, such as
+// /weather/?lat=..&long=.., is errNoCity.
+func cityFromPath(path string, max int) (string, error) {
+	city, ok := strings.CutPrefix(path, "/weather/")
+	if !ok {
+		return "", fmt.Errorf("path %q is not under /weather/", path)
+	}
+	if !utf8.ValidString(city) {
+		return "", errors.New("city is not valid UTF-8")
+	}
+	if strings.Contains(city, "/") {
+		return "", fmt.Errorf("city %q can't contain /", city)
+	}
+	if strings.IndexFunc(city, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("city %q can't contain control characters", city)
+	}
+	if err := checkCityLength(city, max); err != nil {
+		return "", err
+	}
+	city = strings.TrimSpace(city)
+	if city == "" {
+		return "", errNoCity
+	}
+	return city, nil
+}
+
+// checkCityLength rejects city names longer than max characters before they
+// reach a provider URL or the logs.
+func checkCityLength(city string, max int) error {
+	if n := utf8.RuneCountInString(city); n > max {
+		return fmt.Errorf("city is %d characters, the limit is %d", n, max)
+	}
+	return nil
+}
+
+// requestedCoordFormat reads ?coordformat=decimal|hemisphere, defaulting to decimal.
+func requestedCoordFormat(r *http.Request) (coordFormat, error) {
+	switch v := strings.ToLower(r.URL.Query().Get("coordformat")); v {
+	case "", string(signedDecimal):
+		return signedDecimal, nil
+	case string(hemisphere):
+		return hemisphere, nil
+	default:
+		return "", fmt.Errorf("unknown coordformat %q, expected decimal or hemisphere", v)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestCoordPrecisionClamps(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", defaultCoordPrecision},
+		{"0", 0},
+		{"2", 2},
+		{"6", 6},
+		{"-3", 0},
+		{"9", maxCoordPrecision},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/weather/Bucharest?coordprecision="+tt.value, nil)
+		got, err := coordPrecision(r)
+		if err != nil {
+			t.Errorf("%q: %s", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMinProvidersOnlyLowersTheMinimum(t *testing.T) {
+	tests := []struct {
+		value      string
+		configured int
+		want       int
+	}{
+		{"2", 3, 2},
+		{"4", 3, 3}, // capped at -min-providers
+		{"4", 0, 4}, // which, when unset, doesn't cap it
+		{"1", 3, 1},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/weather/Bucharest?minproviders="+tt.value, nil)
+		got, ok, err := requestedMinProviders(r, tt.configured, 4)
+		if err != nil || !ok {
+			t.Errorf("%q with %d configured: got ok %t, %v", tt.value, tt.configured, ok, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q with %d configured: got %d, want %d", tt.value, tt.configured, got, tt.want)
+		}
+	}
+
+	// outside 1 to the number of providers is rejected, not clamped
+	for _, v := range []string{"two", "0", "-2", "5"} {
+		r := httptest.NewRequest("GET", "/weather/Bucharest?minproviders="+v, nil)
+		if _, _, err := requestedMinProviders(r, 3, 4); err == nil {
+			t.Errorf("minproviders=%s accepted", v)
+		}
+	}
+	r := httptest.NewRequest("GET", "/weather/Bucharest", nil)
+	if _, ok, err := requestedMinProviders(r, 3, 4); ok || err != nil {
+		t.Errorf("without minproviders got ok %t, %v", ok, err)
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	got, err := parseUnits([]string{" F , c,f", "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"f", "c", "k"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	for _, bad := range [][]string{{""}, {"c,celsius"}} {
+		if _, err := parseUnits(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestCityFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		err  error // nil when any error will do
+		ok   bool
+	}{
+		{"/weather/Bucharest", "Bucharest", nil, true},
+		{"/weather/ São Paulo ", "São Paulo", nil, true},
+		{"/weather/", "", errNoCity, false},
+		{"/weather/ ", "", errNoCity, false},
+		{"/weather/\u00a0", "", errNoCity, false},
+		{"/weather/a/b", "", nil, false},
+		{"/weather/a\tb", "", nil, false},
+		{"/weather/\xff", "", nil, false},
+		{"/other/Bucharest", "", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := cityFromPath(tt.path, 100)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("%q: got %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+		if !tt.ok && (err == nil || (tt.err != nil && !errors.Is(err, tt.err))) {
+			t.Errorf("%q: got %q, %v, want an error", tt.path, got, err)
+		}
+	}
+}
+
+func FuzzCityFromPath(f *testing.F) {
+	for _, seed := range []string{"/weather/Bucharest", "/weather/", "/weather/%20", "/weather/ ", "/weather/São Paulo", "/weather/a/b", "/weather/\x00", "/weather/\xff", "/", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, path string) {
+		city, err := cityFromPath(path, 100)
+		if err != nil {
+			if city != "" {
+				t.Errorf("%q: got %q along with %v", path, city, err)
+			}
+			return
+		}
+		if city == "" || city != strings.TrimSpace(city) {
+			t.Errorf("%q: got untrimmed or empty city %q", path, city)
+		}
+		if !utf8.ValidString(city) || strings.Contains(city, "/") || strings.IndexFunc(city, unicode.IsControl) >= 0 {
+			t.Errorf("%q: got city %q, which no provider can be asked about", path, city)
+		}
+		if utf8.RuneCountInString(city) > 100 {
+			t.Errorf("%q: got %d characters, over the limit", path, utf8.RuneCountInString(city))
+		}
+	})
+}
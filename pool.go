@@ -0,0 +1,22 @@
+package main
+
+import "context"
+
+// providerSlots bounds how many provider calls are in flight at once across every
+// request, set from -max-provider-calls. Nil leaves them unbounded.
+var providerSlots chan struct{}
+
+// acquireProviderSlot waits for room to make a provider call, giving up when ctx
+// is done. The returned function gives the slot back.
+func acquireProviderSlot(ctx context.Context) (func(), error) {
+	if providerSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case providerSlots <- struct{}{}:
+		return func() { <-providerSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
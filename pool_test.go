@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProviderSlotsCapConcurrentCalls(t *testing.T) {
+	old := providerSlots
+	providerSlots = make(chan struct{}, 2)
+	t.Cleanup(func() { providerSlots = old })
+
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	counted := func(id string) *fakeProvider {
+		return &fakeProvider{id: id, answer: func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+			mu.Lock()
+			inFlight++
+			most = max(most, inFlight)
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return reading(10), nil
+		}}
+	}
+	mw := multiWeatherProvider{counted("pool-a"), counted("pool-b"), counted("pool-c")}
+
+	// several requests at once, each asking every provider in parallel
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := mw.temperature(context.Background(), "", 44.4268, 26.1025, aggregateOptions{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if most != 2 {
+		t.Errorf("at most %d calls in flight, want the 2 slots full but never more", most)
+	}
+}
+
+func TestAcquireProviderSlotGivesUpWithTheContext(t *testing.T) {
+	old := providerSlots
+	providerSlots = make(chan struct{}, 1)
+	t.Cleanup(func() { providerSlots = old })
+
+	release, err := acquireProviderSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := acquireProviderSlot(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v with every slot taken, want the deadline", err)
+	}
+
+	release()
+	if release, err = acquireProviderSlot(context.Background()); err != nil {
+		t.Errorf("got %v once the slot was given back", err)
+	}
+	release()
+}
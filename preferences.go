@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// preferences holds the units set per city through /preferences, used by
+// /weather requests for that city without ?units=. They are kept in memory only.
+var preferences = newUnitPreferences()
+
+type unitPreferences struct {
+	mu    sync.RWMutex
+	units map[string][]string
+}
+
+func newUnitPreferences() *unitPreferences {
+	return &unitPreferences{units: make(map[string][]string)}
+}
+
+func preferenceKey(city string) string {
+	return strings.ToLower(strings.TrimSpace(city))
+}
+
+// get returns the preferred units for city, nil when there are none.
+func (p *unitPreferences) get(city string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.units[preferenceKey(city)]
+}
+
+func (p *unitPreferences) set(city string, units []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.units[preferenceKey(city)] = units
+}
+
+// preferencesHandler serves PUT /preferences/{city} with a body of
+// {"units": "c,f"}, authorized like the admin endpoints.
+func preferencesHandler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, secret) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodPut {
+			w.Header().Set("Allow", http.MethodPut)
+			http.Error(w, "use PUT", http.StatusMethodNotAllowed)
+			return
+		}
+
+		city := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/preferences/"))
+		if city == "" || strings.Contains(city, "/") {
+			http.Error(w, "use /preferences/{city}", http.StatusNotFound)
+			return
+		}
+
+		var body struct {
+			Units string `json:"units"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+			http.Error(w, `send {"units": "c,f"}`, http.StatusBadRequest)
+			return
+		}
+		units, err := parseUnits([]string{body.Units})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		preferences.set(city, units)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"city":  city,
+			"units": units,
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func putPreference(h http.Handler, city string, body string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/preferences/"+city, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPreferencesApplyToLaterRequests(t *testing.T) {
+	t.Cleanup(func() { preferences = newUnitPreferences() })
+	sc := testRoutes(&fakeProvider{id: "preferences", data: reading(10)})
+	sc.adminToken = "secret"
+	h := newRouter(sc)
+
+	if rec := putPreference(h, "Bucharest", `{"units": "f,k"}`, "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", rec.Code, rec.Body.String())
+	}
+
+	resp := decodeWeather(t, serve(h, "/weather/bucharest"))
+	if resp.Temp != "" || resp.Celsius != "" || resp.Fahrenheit != "50.00°F" || resp.Kelvin != "283.15K" {
+		t.Errorf("got temp %q, c %q, f %q, k %q, want the preferred f and k only", resp.Temp, resp.Celsius, resp.Fahrenheit, resp.Kelvin)
+	}
+
+	// an explicit ?units= wins over the preference
+	resp = decodeWeather(t, serve(h, "/weather/Bucharest?units=c"))
+	if resp.Celsius != "10.00°C" || resp.Fahrenheit != "" || resp.Kelvin != "" {
+		t.Errorf("got c %q, f %q, k %q, want c alone", resp.Celsius, resp.Fahrenheit, resp.Kelvin)
+	}
+
+	// other cities keep the default
+	if resp := decodeWeather(t, serve(h, "/weather/Paris")); resp.Temp != "10.00°C" || resp.Fahrenheit != "" {
+		t.Errorf("Paris got temp %q, f %q, want the default temp", resp.Temp, resp.Fahrenheit)
+	}
+}
+
+func TestPreferencesRejectsBadRequests(t *testing.T) {
+	t.Cleanup(func() { preferences = newUnitPreferences() })
+	sc := testRoutes()
+	sc.adminToken = "secret"
+	h := newRouter(sc)
+
+	tests := []struct {
+		name, city, body, token string
+		want                    int
+	}{
+		{"no token", "Bucharest", `{"units": "f"}`, "", http.StatusUnauthorized},
+		{"wrong token", "Bucharest", `{"units": "f"}`, "guess", http.StatusUnauthorized},
+		{"unknown unit", "Bucharest", `{"units": "x"}`, "secret", http.StatusBadRequest},
+		{"not json", "Bucharest", `f`, "secret", http.StatusBadRequest},
+		{"no city", "", `{"units": "f"}`, "secret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := putPreference(h, tt.city, tt.body, tt.token); rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+	if got := preferences.get("Bucharest"); got != nil {
+		t.Errorf("rejected requests set %v", got)
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// providerFixtures are a captured response from each network provider, by the
+// host it is served from.
+var providerFixtures = map[string]cannedResponse{
+	"api.openweathermap.org": {body: `{"coord":{"lon":26.1063,"lat":44.4323},"weather":[{"id":800,"main":"Clear","description":"clear sky"}],"main":{"temp":285.65,"feels_like":284.62,"pressure":1021},"wind":{"speed":2.57},"clouds":{"all":0},"dt":1700000000,"sys":{"sunrise":1699937532,"sunset":1699972855},"timezone":7200,"name":"Bucharest"}`},
+	"api.darksky.net":        {body: `{"latitude":44.4268,"longitude":26.1025,"currently":{"time":1700000000,"temperature":54.5,"windSpeed":5.75,"pressure":1021.3},"flags":{"sources":["isd","cmc"],"nearest-station":3.4,"units":"us"}}`},
+	"api.weatherbit.io":      {body: `{"data":[{"city_name":"Bucharest","lat":44.43,"lon":26.11,"temp":"12.5","wind_spd":2.6,"pres":1021,"ts":1700000000,"weather":{"description":"Clear sky"}}],"count":1}`},
+	"api.open-meteo.com":     {body: `{"latitude":44.43,"longitude":26.1,"current_weather":{"temperature":12.4,"windspeed":9.4,"weathercode":0,"time":1700000000}}`},
+	"api.met.no":             {body: `{"geometry":{"coordinates":[26.1025,44.4268,80]},"properties":{"timeseries":[{"time":"2023-11-14T22:00:00Z","data":{"instant":{"details":{"air_temperature":12.1,"wind_speed":2.4,"air_pressure_at_sea_level":1021.5}}}}]}}`},
+}
+
+func TestProviderNames(t *testing.T) {
+	tests := []struct {
+		p    weatherProvider
+		want string
+	}{
+		{openWeatherMap{}, "openweathermap"},
+		{darkSky{}, "darksky"},
+		{weatherBit{}, "weatherbit"},
+		{openMeteo{}, "openmeteo"},
+		{metNo{}, "metno"},
+	}
+	for _, tt := range tests {
+		if got := tt.p.name(); got != tt.want {
+			t.Errorf("%T is named %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestProvidersRecordTheirNativeUnit(t *testing.T) {
+	ctx, _ := withCanned(providerFixtures)
+	si, _ := withCanned(map[string]cannedResponse{
+		"api.darksky.net": {body: `{"currently":{"time":1700000000,"temperature":12.5},"flags":{"units":"si"}}`},
+	})
+	tests := []struct {
+		ctx  context.Context
+		p    weatherProvider
+		want string
+	}{
+		{ctx, openWeatherMap{apiKey: "key"}, "k"},
+		{ctx, darkSky{apiKey: "key"}, "f"},
+		{si, darkSky{apiKey: "key"}, "c"},
+		{ctx, weatherBit{apiKey: "key"}, "c"},
+		{ctx, openMeteo{}, "c"},
+		{ctx, metNo{}, "c"},
+	}
+	for _, tt := range tests {
+		wd, err := tt.p.temperature(tt.ctx, "", 44.4268, 26.1025)
+		if err != nil {
+			t.Errorf("%s: %s", tt.p.name(), err)
+			continue
+		}
+		if wd.NativeUnit != tt.want {
+			t.Errorf("%s: native unit %q, want %q", tt.p.name(), wd.NativeUnit, tt.want)
+		}
+	}
+}
+
+func TestSourcesShowNativeUnits(t *testing.T) {
+	ctx, _ := withCanned(providerFixtures)
+	sc := testRoutes(openWeatherMap{apiKey: "key"}, darkSky{apiKey: "key"})
+
+	resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025&sources=1"))
+	units := map[string]string{}
+	for _, s := range resp.Sources {
+		units[s.Provider] = s.NativeUnit
+	}
+	if units["openweathermap"] != "k" || units["darksky"] != "f" || len(units) != 2 {
+		t.Errorf("native units %v, want openweathermap k and darksky f", units)
+	}
+}
+
+func TestDarkSkyQualityFlags(t *testing.T) {
+	ctx, _ := withCanned(providerFixtures)
+	wd, err := darkSky{apiKey: "key"}.temperature(ctx, "", 44.4268, 26.1025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"units": "us", "sources": "isd,cmc", "nearest-station": "3.40"}
+	if fmt.Sprint(wd.Quality) != fmt.Sprint(want) {
+		t.Errorf("quality %v, want %v", wd.Quality, want)
+	}
+
+	sc := testRoutes(darkSky{apiKey: "key"}, openMeteo{})
+	resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025&sources=1"))
+	if len(resp.Sources) != 2 {
+		t.Fatalf("sources %+v, want darksky and openmeteo", resp.Sources)
+	}
+	for _, s := range resp.Sources {
+		switch {
+		case s.Provider == "darksky" && fmt.Sprint(s.Quality) != fmt.Sprint(want):
+			t.Errorf("darksky's source has quality %v, want %v", s.Quality, want)
+		case s.Provider == "openmeteo" && s.Quality != nil:
+			t.Errorf("openmeteo's source has quality %v, want none", s.Quality)
+		}
+	}
+}
+
+func TestSourcesShowHTTPStatus(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.open-meteo.com": providerFixtures["api.open-meteo.com"],
+		"api.darksky.net":    {status: http.StatusServiceUnavailable},
+		"api.met.no":         {status: http.StatusNotFound},
+	})
+	sc := testRoutes(openMeteo{}, darkSky{apiKey: "key"}, metNo{})
+
+	resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025&sources=1"))
+	statuses := map[string]int{}
+	for _, s := range resp.Sources {
+		statuses[s.Provider] = s.HTTPStatus
+	}
+	for _, s := range resp.Skipped {
+		statuses[s.Provider] = s.HTTPStatus
+	}
+	want := map[string]int{"openmeteo": 200, "darksky": 503, "metno": 404}
+	for provider, status := range want {
+		if statuses[provider] != status {
+			t.Errorf("%s reported status %d, want %d", provider, statuses[provider], status)
+		}
+	}
+}
+
+func TestOpenWeatherMapConditions(t *testing.T) {
+	ctx, _ := withCanned(providerFixtures)
+	wd, err := openWeatherMap{apiKey: "key"}.temperature(ctx, "Bucharest", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.Conditions != "clear sky" {
+		t.Errorf("conditions %q, want clear sky", wd.Conditions)
+	}
+
+	ctx, _ = withCanned(map[string]cannedResponse{
+		"api.openweathermap.org": {body: `{"main":{"temp":285.65},"weather":[]}`},
+	})
+	wd, err = openWeatherMap{apiKey: "key"}.temperature(ctx, "Bucharest", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wd.valid() || wd.Conditions != "" {
+		t.Errorf("got conditions %q, valid %t, want a reading without conditions", wd.Conditions, wd.valid())
+	}
+}
+
+func TestOpenMeteoWeatherCodes(t *testing.T) {
+	tests := []struct {
+		code int
+		want string
+	}{
+		{0, "clear"},
+		{3, "overcast"},
+		{61, "light rain"},
+		{75, "heavy snow"},
+		{99, "thunderstorm with heavy hail"},
+		{42, ""}, // not a WMO code
+	}
+	for _, tt := range tests {
+		ctx, _ := withCanned(map[string]cannedResponse{
+			"api.open-meteo.com": {body: fmt.Sprintf(`{"current_weather":{"temperature":12.4,"weathercode":%d,"time":1700000000}}`, tt.code)},
+		})
+		wd, err := openMeteo{}.temperature(ctx, "Bucharest", 44.4268, 26.1025)
+		if err != nil {
+			t.Errorf("code %d: %s", tt.code, err)
+			continue
+		}
+		if !wd.valid() || wd.Conditions != tt.want {
+			t.Errorf("code %d: got conditions %q, valid %t, want %q", tt.code, wd.Conditions, wd.valid(), tt.want)
+		}
+	}
+}
+
+func TestOpenWeatherMapLocationName(t *testing.T) {
+	ctx, _ := withCanned(providerFixtures)
+	wd, err := openWeatherMap{apiKey: "key"}.temperature(ctx, "bucuresti", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.LocationName != "Bucharest" {
+		t.Errorf("location name %q, want Bucharest from the payload", wd.LocationName)
+	}
+}
+
+func TestOpenWeatherMapSunTimes(t *testing.T) {
+	ctx, _ := withCanned(providerFixtures)
+	wd, err := openWeatherMap{apiKey: "key"}.temperature(ctx, "Bucharest", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sunrise, sunset := wd.Sunrise.Format(time.RFC3339), wd.Sunset.Format(time.RFC3339)
+	if sunrise != "2023-11-14T06:52:12+02:00" || sunset != "2023-11-14T16:40:55+02:00" {
+		t.Errorf("sunrise %s and sunset %s, want 06:52:12 and 16:40:55 at +02:00", sunrise, sunset)
+	}
+
+	// without a timezone they are in UTC, and missing ones stay zero
+	ctx, _ = withCanned(map[string]cannedResponse{
+		"api.openweathermap.org": {body: `{"main":{"temp":285.65},"sys":{"sunrise":1699937532}}`},
+	})
+	wd, err = openWeatherMap{apiKey: "key"}.temperature(ctx, "Bucharest", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wd.Sunrise.Format(time.RFC3339); got != "2023-11-14T04:52:12Z" || !wd.Sunset.IsZero() {
+		t.Errorf("sunrise %s and sunset %s, want 04:52:12Z and none", got, wd.Sunset)
+	}
+
+	sc := testRoutes(openWeatherMap{apiKey: "key"})
+	ctx, _ = withCanned(providerFixtures)
+	if resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/Bucharest")); resp.Sunrise != "2023-11-14T06:52:12+02:00" {
+		t.Errorf("response sunrise %q, want 2023-11-14T06:52:12+02:00", resp.Sunrise)
+	}
+}
+
+func TestOpenWeatherMapFeelsLike(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    float64
+		wantHas bool
+	}{
+		{"given", `{"main":{"temp":285.65,"feels_like":284.65}}`, 11.5, true},
+		{"exactly freezing", `{"main":{"temp":275.15,"feels_like":273.15}}`, 0, true},
+		{"missing", `{"main":{"temp":285.65}}`, 0, false},
+	}
+	for _, tt := range tests {
+		ctx, _ := withCanned(map[string]cannedResponse{"api.openweathermap.org": {body: tt.body}})
+		wd, err := openWeatherMap{apiKey: "key"}.temperature(ctx, "Bucharest", 0, 0)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if wd.HasFeelsLike != tt.wantHas || math.Abs(wd.FeelsLike-tt.want) > 1e-9 {
+			t.Errorf("%s: got %.2f°C, %t, want %.2f°C, %t", tt.name, wd.FeelsLike, wd.HasFeelsLike, tt.want, tt.wantHas)
+		}
+	}
+}
+
+func TestOpenWeatherMapCloudCover(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    float64
+		wantHas bool
+	}{
+		{"overcast", `{"main":{"temp":285.65},"clouds":{"all":75}}`, 75, true},
+		{"a clear sky", `{"main":{"temp":285.65},"clouds":{"all":0}}`, 0, true},
+		{"above 100", `{"main":{"temp":285.65},"clouds":{"all":120}}`, 100, true},
+		{"below 0", `{"main":{"temp":285.65},"clouds":{"all":-5}}`, 0, true},
+		{"missing", `{"main":{"temp":285.65}}`, 0, false},
+	}
+	for _, tt := range tests {
+		ctx, _ := withCanned(map[string]cannedResponse{"api.openweathermap.org": {body: tt.body}})
+		wd, err := openWeatherMap{apiKey: "key"}.temperature(ctx, "Bucharest", 0, 0)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if wd.HasCloudCover != tt.wantHas || wd.CloudCover != tt.want {
+			t.Errorf("%s: got %v%%, %t, want %v%%, %t", tt.name, wd.CloudCover, wd.HasCloudCover, tt.want, tt.wantHas)
+		}
+	}
+}
+
+func TestWeatherLocationNames(t *testing.T) {
+	named := func(id string, name string) *fakeProvider {
+		wd := reading(10)
+		wd.LocationName = name
+		return &fakeProvider{id: id, data: wd}
+	}
+	sc := testRoutes(
+		named("names-a", "Bucharest"),
+		named("names-b", "BUCHAREST"),
+		named("names-c", ""),
+		named("names-d", "Bucuresti"),
+	)
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest"))
+	if got := fmt.Sprint(resp.LocationNames); got != "[Bucharest Bucuresti]" {
+		t.Errorf("location names %s, want [Bucharest Bucuresti]", got)
+	}
+}
+
+func TestOpenMeteoReportsGridCoordinates(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.open-meteo.com":         providerFixtures["api.open-meteo.com"],
+		"archive-api.open-meteo.com": {body: `{"latitude":44.45,"longitude":26.08,"hourly":{"time":[1700000000],"temperature_2m":[8.5]}}`},
+	})
+	wd, err := openMeteo{}.temperature(ctx, "", 44.4268, 26.1025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.Latitude != 44.43 || wd.Longitude != 26.1 {
+		t.Errorf("current reading at %.4f, %.4f, want the grid cell's 44.43, 26.1", wd.Latitude, wd.Longitude)
+	}
+
+	wd, err = openMeteo{}.temperature(withHistoryTime(ctx, time.Unix(1700000000, 0)), "", 44.4268, 26.1025)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.Celsius != 8.5 || wd.Latitude != 44.45 || wd.Longitude != 26.08 {
+		t.Errorf("archive reading %.2f°C at %.4f, %.4f, want 8.50°C at the grid cell's 44.45, 26.08", wd.Celsius, wd.Latitude, wd.Longitude)
+	}
+}
+
+func TestWeatherConditions(t *testing.T) {
+	rain, clear := reading(10), reading(12)
+	rain.Conditions, clear.Conditions = "light rain", "clear sky"
+	sc := testRoutes(
+		&fakeProvider{id: "conditions-a", data: clear},
+		&fakeProvider{id: "conditions-b", data: rain},
+		&fakeProvider{id: "conditions-c", data: reading(11)},
+		&fakeProvider{id: "conditions-d", data: rain},
+	)
+	if resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest")); resp.Conditions != "light rain" {
+		t.Errorf("conditions %q, want light rain", resp.Conditions)
+	}
+}
+
+// metNoSample is a trimmed Locationforecast compact response for Bucharest.
+const metNoSample = `{"type":"Feature","geometry":{"type":"Point","coordinates":[26.1025,44.4268,80]},
+"properties":{"meta":{"updated_at":"2023-11-14T21:33:07Z","units":{"air_pressure_at_sea_level":"hPa","air_temperature":"celsius","wind_speed":"m/s"}},
+"timeseries":[
+{"time":"2023-11-14T22:00:00Z","data":{"instant":{"details":{"air_pressure_at_sea_level":1021.5,"air_temperature":12.1,"cloud_area_fraction":99.2,"relative_humidity":81.3,"wind_from_direction":236.6,"wind_speed":2.4}},"next_1_hours":{"summary":{"symbol_code":"cloudy"},"details":{"precipitation_amount":0.0}}}},
+{"time":"2023-11-14T23:00:00Z","data":{"instant":{"details":{"air_pressure_at_sea_level":1021.9,"air_temperature":11.4,"cloud_area_fraction":100.0,"relative_humidity":84.0,"wind_from_direction":241.2,"wind_speed":2.1}}}}]}}`
+
+func TestMetNoDecode(t *testing.T) {
+	ctx, transport := withCanned(map[string]cannedResponse{"api.met.no": {body: metNoSample}})
+	wd, err := metNo{}.temperature(ctx, "Bucharest", 44.42681, 26.10253)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wd.valid() || wd.Celsius != 12.1 || math.Abs(wd.Kelvin-285.25) > 1e-9 {
+		t.Errorf("got %v°C, %vK, want the first hour's 12.1°C, 285.25K", wd.Celsius, wd.Kelvin)
+	}
+	if wd.WindSpeed != 2.4 || wd.Pressure != 1021.5 || wd.NativeUnit != "c" {
+		t.Errorf("got wind %v, pressure %v, native unit %q", wd.WindSpeed, wd.Pressure, wd.NativeUnit)
+	}
+	if want := time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC); !wd.Observed.Equal(want) {
+		t.Errorf("observed %s, want %s", wd.Observed, want)
+	}
+
+	sent := transport.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(sent))
+	}
+	if want := "https://api.met.no/weatherapi/locationforecast/2.0/compact?lat=44.4268&lon=26.1025"; sent[0].URL.String() != want {
+		t.Errorf("asked %s, want %s", sent[0].URL, want)
+	}
+	if ua := sent[0].Header.Get("User-Agent"); ua != userAgent {
+		t.Errorf("User-Agent %q, want %q", ua, userAgent)
+	}
+}
+
+func TestMetNoNeedsCoordinates(t *testing.T) {
+	ctx, transport := withCanned(map[string]cannedResponse{"api.met.no": {body: metNoSample}})
+	wd, err := metNo{}.temperature(ctx, "Bucharest", 0, 0)
+	if err != nil || wd.valid() {
+		t.Errorf("got %+v, %v, want no reading and no error", wd, err)
+	}
+	if n := len(transport.sent()); n != 0 {
+		t.Errorf("sent %d requests without coordinates, want none", n)
+	}
+
+	ctx, _ = withCanned(map[string]cannedResponse{"api.met.no": {body: `{"properties":{"timeseries":[]}}`}})
+	if wd, err := (metNo{}).temperature(ctx, "Bucharest", 44.4268, 26.1025); err != nil || wd.valid() {
+		t.Errorf("got %+v, %v for an empty forecast, want no reading and no error", wd, err)
+	}
+}
+
+func TestWeatherBitDecode(t *testing.T) {
+	ctx, transport := withCanned(providerFixtures)
+	wd, err := weatherBit{apiKey: "key"}.temperature(ctx, "São Paulo", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wd.valid() || wd.Celsius != 12.5 || wd.Fahrenheit != 54.5 {
+		t.Errorf("got %v°C, %v°F, want 12.5°C, 54.5°F", wd.Celsius, wd.Fahrenheit)
+	}
+	if wd.Latitude != 44.43 || wd.Longitude != 26.11 {
+		t.Errorf("got %v, %v, want the reported 44.43, 26.11", wd.Latitude, wd.Longitude)
+	}
+	if wd.WindSpeed != 2.6 || wd.Pressure != 1021 || wd.Conditions != "Clear sky" || wd.LocationName != "Bucharest" {
+		t.Errorf("got wind %v, pressure %v, conditions %q, name %q", wd.WindSpeed, wd.Pressure, wd.Conditions, wd.LocationName)
+	}
+	if want := "https://api.weatherbit.io/v2.0/current?city=S%C3%A3o+Paulo&key=key"; transport.sent()[0].URL.String() != want {
+		t.Errorf("asked %s, want %s", transport.sent()[0].URL, want)
+	}
+
+	weatherBit{apiKey: "key"}.temperature(ctx, "", 44.4268, 26.1025)
+	if want := "https://api.weatherbit.io/v2.0/current?lat=44.4268&lon=26.1025&key=key"; transport.sent()[1].URL.String() != want {
+		t.Errorf("asked %s, want %s", transport.sent()[1].URL, want)
+	}
+}
+
+func TestWeatherBitWithoutObservations(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{"api.weatherbit.io": {body: `{"data":[],"count":0}`}})
+	wd, err := weatherBit{apiKey: "key"}.temperature(ctx, "Atlantis", 0, 0)
+	if err != nil || wd.valid() {
+		t.Errorf("got %+v, %v, want no reading and no error", wd, err)
+	}
+}
+
+func TestWeatherBitCoordinatesReachLaterProviders(t *testing.T) {
+	ctx, _ := withCanned(providerFixtures)
+	var got coordinates
+	mw := multiWeatherProvider{weatherBit{apiKey: "key"}, askedAt("after-weatherbit", &got)}
+
+	agg, err := mw.temperature(ctx, "Bucharest", 0, 0, aggregateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (coordinates{Latitude: 44.43, Longitude: 26.11}) {
+		t.Errorf("next provider asked at %+v, want weatherbit's 44.43, 26.11", got)
+	}
+	if agg.ResolvedLatitude != 44.43 || agg.ResolvedLongitude != 26.11 {
+		t.Errorf("resolved %v, %v, want 44.43, 26.11", agg.ResolvedLatitude, agg.ResolvedLongitude)
+	}
+}
+
+func TestOpenWeatherMapEscapesTheCity(t *testing.T) {
+	ctx, transport := withCanned(providerFixtures)
+	if _, err := (openWeatherMap{apiKey: "key"}).temperature(ctx, "São Paulo&units=metric", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := "http://api.openweathermap.org/data/2.5/weather?APPID=key&q=S%C3%A3o+Paulo%26units%3Dmetric"
+	if got := transport.sent()[0].URL.String(); got != want {
+		t.Errorf("asked %s, want %s", got, want)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	owm := "http://api.openweathermap.org/data/2.5/weather?APPID=%s&q=Bucharest"
+	tests := []struct {
+		name      string
+		p         weatherProvider
+		responses map[string]cannedResponse
+		asked     int
+		wantErr   bool
+	}{
+		{
+			"primary rejected, secondary accepted",
+			openWeatherMap{apiKey: "old", secondaryKey: "new"},
+			map[string]cannedResponse{
+				fmt.Sprintf(owm, "old"): {status: http.StatusUnauthorized, body: `{"cod":401}`},
+				fmt.Sprintf(owm, "new"): providerFixtures["api.openweathermap.org"],
+			},
+			2, false,
+		},
+		{
+			"primary forbidden, secondary accepted",
+			darkSky{apiKey: "old", secondaryKey: "new"},
+			map[string]cannedResponse{
+				"https://api.darksky.net/forecast/old/44.4268,26.1025?exclude=minutely,hourly,daily,alerts": {status: http.StatusForbidden},
+				"https://api.darksky.net/forecast/new/44.4268,26.1025?exclude=minutely,hourly,daily,alerts": providerFixtures["api.darksky.net"],
+			},
+			2, false,
+		},
+		{
+			"primary accepted",
+			openWeatherMap{apiKey: "new", secondaryKey: "old"},
+			map[string]cannedResponse{fmt.Sprintf(owm, "new"): providerFixtures["api.openweathermap.org"]},
+			1, false,
+		},
+		{
+			"both rejected",
+			openWeatherMap{apiKey: "old", secondaryKey: "older"},
+			map[string]cannedResponse{"api.openweathermap.org": {status: http.StatusUnauthorized}},
+			2, true,
+		},
+		{
+			"no secondary",
+			openWeatherMap{apiKey: "old"},
+			map[string]cannedResponse{"api.openweathermap.org": {status: http.StatusUnauthorized}},
+			1, true,
+		},
+	}
+	for _, tt := range tests {
+		ctx, transport := withCanned(tt.responses)
+		city := "Bucharest"
+		if tt.p.name() == "darksky" {
+			city = ""
+		}
+		wd, err := tt.p.temperature(ctx, city, 44.4268, 26.1025)
+		if tt.wantErr {
+			if !errors.Is(err, ErrProviderUnavailable) {
+				t.Errorf("%s: got %v, want the provider unavailable", tt.name, err)
+			}
+		} else if err != nil || !wd.valid() {
+			t.Errorf("%s: got %+v, %v, want a reading", tt.name, wd, err)
+		}
+		if n := len(transport.sent()); n != tt.asked {
+			t.Errorf("%s: sent %d requests, want %d", tt.name, n, tt.asked)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+// weatherResponse is the JSON body of /weather/. Measurements are display
+// strings formatted for the requested units, system and locale.
+type weatherResponse struct {
+	City string `json:"city"`
+	ICAO string `json:"icao,omitempty"` // the airport code asked for, in place of a city
+	Lat  string `json:"lat"`
+	Long string `json:"long"`
+
+	// Temp is replaced by one field per unit when ?units= is given
+	Temp       string `json:"temp,omitempty"`
+	Celsius    string `json:"c,omitempty"`
+	Fahrenheit string `json:"f,omitempty"`
+	Kelvin     string `json:"k,omitempty"`
+	Rankine    string `json:"r,omitempty"`
+
+	// TempC is the temperature in celsius as a number, rounded like the display
+	// strings, for clients that would otherwise parse them
+	TempC float64 `json:"temp_c"`
+
+	// the 95% confidence interval of the mean reading, in the system's unit
+	CILow  string `json:"ci_low,omitempty"`
+	CIHigh string `json:"ci_high,omitempty"`
+
+	Wind       string `json:"wind,omitempty"`
+	Pressure   string `json:"pressure,omitempty"`
+	FeelsLike  string `json:"feels_like,omitempty"` // apparent temperature, in the system's unit
+	Conditions string `json:"conditions,omitempty"`
+
+	At      string `json:"at,omitempty"`      // the past time asked for by ?at=, RFC 3339
+	Sunrise string `json:"sunrise,omitempty"` // RFC 3339, in the place's time zone when known
+	Sunset  string `json:"sunset,omitempty"`
+
+	// LocationNames are the providers' own names for the place, to check the
+	// right one was matched
+	LocationNames []string `json:"location_names,omitempty"`
+
+	// Attribution credits the providers behind the readings, as their terms ask
+	Attribution []attribution `json:"attribution,omitempty"`
+
+	Age           *int64   `json:"age,omitempty"` // seconds, absent when unknown
+	CoordDeltaKm  *float64 `json:"coord_delta_km,omitempty"`
+	CoordFallback bool     `json:"coord_fallback,omitempty"`
+	SpreadPct     *float64 `json:"spread_pct,omitempty"`  // how far the readings are apart, absent near 0°C
+	CloudCover    *float64 `json:"cloud_cover,omitempty"` // percent of the sky, absent when no provider said
+	Partial       bool     `json:"partial,omitempty"`
+	SingleSource  bool     `json:"single_source,omitempty"` // the "average" is one provider's reading
+	Consensus     bool     `json:"consensus"`               // the readings agree to within -consensus-tolerance
+	Stale         bool     `json:"stale,omitempty"`         // an expired cache entry, served because fetching failed
+	TimedOut      []string `json:"timed_out,omitempty"`
+
+	ProvidersQueried   int `json:"providers_queried"`
+	ProvidersSucceeded int `json:"providers_succeeded"` // had a reading, even one that wasn't used
+
+	Sources []sourceEntry          `json:"sources,omitempty"`
+	Skipped []skippedProvider      `json:"skipped,omitempty"`
+	Raw     map[string]interface{} `json:"raw,omitempty"`
+
+	Timings map[string]string `json:"timings,omitempty"` // per provider, like took
+	Took    string            `json:"took"`
+}
+
+// setUnit fills the field for one of the ?units= codes.
+func (resp *weatherResponse) setUnit(unit string, value string) {
+	switch unit {
+	case "c":
+		resp.Celsius = value
+	case "f":
+		resp.Fahrenheit = value
+	case "k":
+		resp.Kelvin = value
+	case "r":
+		resp.Rankine = value
+	}
+}
+
+// numberFields are the fields holding formatted numbers, for localization.
+func (resp *weatherResponse) numberFields() []*string {
+	return []*string{
+		&resp.Lat, &resp.Long,
+		&resp.Temp, &resp.Celsius, &resp.Fahrenheit, &resp.Kelvin, &resp.Rankine,
+		&resp.CILow, &resp.CIHigh,
+		&resp.Wind, &resp.Pressure, &resp.FeelsLike,
+	}
+}
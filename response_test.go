@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWeatherResponseRoundTrip(t *testing.T) {
+	age, km := int64(30), 1.25
+	want := weatherResponse{
+		City:               "Bucharest",
+		Lat:                "44.4268",
+		Long:               "26.1025",
+		Temp:               "12.34°C",
+		TempC:              12.34,
+		Wind:               "3.5 m/s",
+		Conditions:         "light rain",
+		Attribution:        []attribution{{Provider: "openmeteo", Text: "Weather data by Open-Meteo.com, CC BY 4.0", URL: "https://open-meteo.com/"}},
+		Age:                &age,
+		CoordDeltaKm:       &km,
+		Consensus:          true,
+		TimedOut:           []string{"darksky"},
+		Sources:            []sourceEntry{{Provider: "openmeteo", Temp: "12.34°C", NativeUnit: "c"}},
+		Skipped:            []skippedProvider{{Provider: "darksky", Reason: skipTimedOut}},
+		ProvidersQueried:   2,
+		ProvidersSucceeded: 1,
+		Took:               "12ms",
+	}
+
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got weatherResponse
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip gave %+v, want %+v", got, want)
+	}
+}
+
+func TestWeatherResponseKeys(t *testing.T) {
+	b, err := json.Marshal(weatherResponse{City: "Bucharest", Lat: "44.4268", Long: "26.1025", Temp: "12.34°C", Took: "12ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys map[string]interface{}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"city", "lat", "long", "temp", "took", "temp_c", "consensus"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("%s missing from %s", key, b)
+		}
+	}
+	// optional fields are left out when unset
+	for _, key := range []string{"age", "coord_delta_km", "sources", "skipped", "raw", "timed_out"} {
+		if _, ok := keys[key]; ok {
+			t.Errorf("unset %s written in %s", key, b)
+		}
+	}
+}
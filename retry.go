@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// providerRetries is how many more times a provider is asked after answering
+// with one of its retry statuses.
+var providerRetries = 1
+
+// retryBackoff is the wait before the first retry, doubled for each one after.
+const retryBackoff = 100 * time.Millisecond
+
+// defaultRetryStatuses are the statuses a provider is retried on unless its
+// config lists its own.
+var defaultRetryStatuses = []int{500, 502, 503, 504, 429}
+
+// retryStatusSet checks a config's retry_statuses, falling back to
+// defaultRetryStatuses when there are none.
+func retryStatusSet(statuses []int) (map[int]bool, error) {
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	set := make(map[int]bool, len(statuses))
+	for _, code := range statuses {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("retry status %d is not an HTTP status", code)
+		}
+		set[code] = true
+	}
+	return set, nil
+}
+
+// retryingProvider asks a provider again, up to providerRetries times, when it
+// answers with one of statuses. Any other failure is returned as it is. The
+// backoff between attempts is waited out on clock.
+type retryingProvider struct {
+	weatherProvider
+	statuses map[int]bool
+	clock    Clock
+}
+
+func (p retryingProvider) hasHistory() bool { return hasHistory(p.weatherProvider) }
+
+func (p retryingProvider) temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+	wait := retryBackoff
+	for attempt := 0; ; attempt++ {
+		wd, err := p.weatherProvider.temperature(ctx, city, lat, long)
+
+		var se *statusError
+		if attempt >= providerRetries || !errors.As(err, &se) || !p.statuses[se.code] {
+			return wd, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return wd, err
+		case <-p.clock.After(wait):
+		}
+		wait *= 2
+	}
+}
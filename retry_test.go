@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRetryingProviderBacksOffOnTheClock(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	inner := &fakeProvider{id: "retry-backoff"}
+	inner.answer = func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+		if inner.calls.Load() == 1 {
+			return weatherData{}, &statusError{code: 503}
+		}
+		return reading(7), nil
+	}
+	p := retryingProvider{weatherProvider: inner, statuses: map[int]bool{503: true}, clock: clock}
+
+	type result struct {
+		wd  weatherData
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		wd, err := p.temperature(context.Background(), "Bucharest", 0, 0)
+		done <- result{wd, err}
+	}()
+
+	for clock.waiters() == 0 {
+		runtime.Gosched()
+	}
+	if n := inner.calls.Load(); n != 1 {
+		t.Fatalf("asked %d times before the backoff was up, want 1", n)
+	}
+
+	clock.Advance(retryBackoff)
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.wd.Celsius != 7 || inner.calls.Load() != 2 {
+		t.Errorf("got %.2f°C after %d calls, want 7.00°C after 2", res.wd.Celsius, inner.calls.Load())
+	}
+}
+
+func TestRetryStatusesFromConfig(t *testing.T) {
+	statuses, err := retryStatusSet([]int{408})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		status    int
+		wantCalls int32
+	}{
+		{408, 2},
+		{418, 1},
+		{503, 1}, // a default left out by the config's own list
+	}
+	for _, tt := range tests {
+		clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		inner := &fakeProvider{id: "retry-statuses", err: &statusError{code: tt.status}}
+		p := retryingProvider{weatherProvider: inner, statuses: statuses, clock: clock}
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := p.temperature(context.Background(), "Bucharest", 0, 0)
+			done <- err
+		}()
+		// run out the backoff, if there is one, without waiting for it
+		for clock.waiters() == 0 && len(done) == 0 {
+			runtime.Gosched()
+		}
+		clock.Advance(retryBackoff)
+		err := <-done
+
+		var se *statusError
+		if !errors.As(err, &se) || se.code != tt.status {
+			t.Errorf("%d: got %v, want the status error", tt.status, err)
+		}
+		if n := inner.calls.Load(); n != tt.wantCalls {
+			t.Errorf("%d: asked %d times, want %d", tt.status, n, tt.wantCalls)
+		}
+	}
+
+	defaults, err := retryStatusSet(nil)
+	if err != nil || !defaults[503] || !defaults[429] || defaults[408] {
+		t.Errorf("defaults %v, %v, want 500, 502, 503, 504 and 429", defaults, err)
+	}
+	if _, err := retryStatusSet([]int{42}); err == nil {
+		t.Error("retry status 42 accepted")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// roundingMode decides which way a value exactly halfway between two roundings goes.
+type roundingMode string
+
+const (
+	halfUp   roundingMode = "half-up"   // 1.005 → 1.01, -1.005 → -1.01
+	halfEven roundingMode = "half-even" // 1.005 → 1.00, 1.015 → 1.02
+)
+
+// displayRounding is how temperatures are rounded for display, set by -rounding.
+var displayRounding = halfUp
+
+func parseRoundingMode(s string) (roundingMode, error) {
+	switch m := roundingMode(strings.ToLower(s)); m {
+	case halfUp, halfEven:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown rounding mode %q, expected half-up or half-even", s)
+	}
+}
+
+// roundDecimal rounds v to places decimals in the given mode. It works on the
+// shortest decimal form of v, so 1.005 is treated as the 1.005 it was written as
+// rather than the 1.00499.. it is stored as, and the result is the same on every
+// platform.
+func roundDecimal(v float64, places int, mode roundingMode) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+
+	s := strconv.FormatFloat(math.Abs(v), 'f', -1, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) <= places {
+		return v
+	}
+
+	digits, rest := whole+frac[:places], frac[places:]
+	up := rest[0] > '5' || (rest[0] == '5' && strings.TrimRight(rest[1:], "0") != "")
+	if rest[0] == '5' && !up {
+		// exactly halfway
+		last := digits[len(digits)-1] - '0'
+		up = mode == halfUp || last%2 == 1
+	}
+	if up {
+		digits = incrementDigits(digits)
+	}
+
+	r, err := strconv.ParseFloat(digits+"e-"+strconv.Itoa(places), 64)
+	if err != nil {
+		return v
+	}
+	return math.Copysign(r, v)
+}
+
+// incrementDigits adds one to a string of decimal digits, "199" → "200".
+func incrementDigits(d string) string {
+	b := []byte(d)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < '9' {
+			b[i]++
+			return string(b)
+		}
+		b[i] = '0'
+	}
+	return "1" + string(b)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRoundDecimal(t *testing.T) {
+	tests := []struct {
+		v    float64
+		mode roundingMode
+		want float64
+	}{
+		{1.005, halfUp, 1.01},
+		{1.005, halfEven, 1.00},
+		{1.015, halfUp, 1.02},
+		{1.015, halfEven, 1.02},
+		{1.025, halfEven, 1.02},
+		{-1.005, halfUp, -1.01},
+		{-1.005, halfEven, -1.00},
+		{2.675, halfUp, 2.68},
+		{0.995, halfUp, 1.00},
+		{9.995, halfUp, 10.00},
+		{-9.995, halfUp, -10.00},
+		{99.995, halfEven, 100.00},
+		{1.0050001, halfEven, 1.01}, // past halfway rounds up either way
+		{1.0049999, halfUp, 1.00},
+		{12.3, halfUp, 12.3}, // already short enough
+		{0, halfEven, 0},
+	}
+	for _, tt := range tests {
+		if got := roundDecimal(tt.v, 2, tt.mode); got != tt.want {
+			t.Errorf("%v %s: got %v, want %v", tt.v, tt.mode, got, tt.want)
+		}
+	}
+
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if got := roundDecimal(v, 2, halfUp); !math.IsNaN(got) && got != v {
+			t.Errorf("%v: got %v, want it unchanged", v, got)
+		}
+	}
+}
+
+func TestDisplayRounding(t *testing.T) {
+	saved := displayRounding
+	t.Cleanup(func() { displayRounding = saved })
+
+	for mode, want := range map[roundingMode]string{halfUp: "1.01°C", halfEven: "1.00°C"} {
+		displayRounding = mode
+		if got, err := formatTemperature("c", 1.005); err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %s", mode, got, err, want)
+		}
+	}
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	for s, want := range map[string]roundingMode{"half-up": halfUp, "HALF-EVEN": halfEven} {
+		if got, err := parseRoundingMode(s); err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %s", s, got, err, want)
+		}
+	}
+	if _, err := parseRoundingMode("banker"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// routes are what the endpoints are served from. main fills them in from the
+// flags and config, so that newRouter can be built without either.
+type routes struct {
+	providers *atomic.Pointer[multiWeatherProvider]
+	started   *atomic.Bool // whether startup is done, for /readyz
+	opts      aggregateOptions
+	cache     *weatherCache
+	clock     Clock
+	reverse   ReverseGeocoder // names the place for coordinate requests
+	forwarded []string        // request headers passed on to providers
+
+	providerTimeout time.Duration
+	gridParallelism int
+	maxCityLength   int
+	noDataStatus    int     // returned when no provider has data for a city
+	debugSampleRate float64 // share of fetches whose readings are logged
+	staleWhileError bool
+	softTimeout     time.Duration // how long to wait before serving an expired entry instead, 0 waits for the providers
+
+	// staleWhileRevalidate is how long past its ttl an entry is served while
+	// it is refreshed in the background, 0 never does
+	staleWhileRevalidate time.Duration
+
+	consensusTolerance float64 // °C the readings may be apart and still agree
+	debug              bool    // allows ?raw=1 and /debug/cache
+	adminToken         string  // locks /admin and /preferences when empty
+}
+
+// newRouter registers every endpoint on a mux of its own.
+func newRouter(sc routes) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/weather/", weatherHandler(sc))
+	mux.Handle("/weather-grid", gridHandler(sc.providers, sc.opts, sc.providerTimeout, sc.gridParallelism, sc.forwarded))
+	mux.Handle("/stats", stats)
+	mux.Handle("/admin/providers/", adminProvidersHandler(sc.adminToken))
+	mux.Handle("/preferences/", preferencesHandler(sc.adminToken))
+	mux.HandleFunc("/livez", livezHandler)
+	mux.HandleFunc("/openapi.json", openAPIHandler)
+	if sc.debug {
+		mux.Handle("/debug/cache", cacheDebugHandler(sc.cache))
+	}
+	mux.Handle("/readyz", readyzHandler(sc.providers, sc.started))
+	return mux
+}
+
+// weatherHandler serves /weather/{city}, and /weather/?lat=&long= for coordinates.
+func weatherHandler(sc routes) http.HandlerFunc {
+	refreshes := newRefreshGroup()
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := sc.clock.Now()
+		mw := *sc.providers.Load()
+		city, err := cityFromPath(r.URL.Path, sc.maxCityLength)
+		if err != nil && !errors.Is(err, errNoCity) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lat, long, coordsGiven, err := requestedCoords(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if city == "" && !coordsGiven {
+			http.Error(w, errNoCity.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// an airport's ICAO code is asked for by the airport's coordinates, and
+		// the place named like any other coordinates
+		var icao string
+		if airport, ok := airportByICAO(city); ok && !coordsGiven {
+			icao, city = city, ""
+			lat, long, coordsGiven = airport.Latitude, airport.Longitude, true
+		}
+
+		precision, err := coordPrecision(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		coords, err := requestedCoordFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		units, err := requestedUnits(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if units == nil && city != "" {
+			units = preferences.get(city)
+		}
+
+		system, err := requestedSystem(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		locale, err := requestedLocale(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		fields, err := requestedFields(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		excluded, err := requestedExclusions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reqOpts := sc.opts
+		reqOpts.exclude = excluded
+
+		reqOpts.at, err = requestedTime(r, sc.clock.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		past := !reqOpts.at.IsZero()
+		// the providers with history only take coordinates, and the ones that
+		// resolve a city's aren't asked about the past
+		if past && !coordsGiven && sc.opts.geocoder == nil {
+			http.Error(w, "at needs lat and long, or a -geocoder to find the city's coordinates", http.StatusBadRequest)
+			return
+		}
+		if past {
+			// the per-provider cache holds current readings only
+			reqOpts.readings = nil
+		}
+
+		minReadings, overridden, err := requestedMinProviders(r, sc.opts.minReadings, len(mw.enabled()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if overridden {
+			reqOpts.minReadings = minReadings
+		}
+
+		// raw bodies only exist for a fresh fetch, so ?raw=1 skips the cache
+		var raw *rawBodies
+		if sc.debug && r.URL.Query().Get("raw") == "1" {
+			raw = &rawBodies{}
+		}
+
+		// cached answers are current and from every provider, so ?at= and ?exclude=
+		// skip the cache both ways, while no-cache only skips reading it and
+		// refreshes the entry
+		key := cacheKey(city, lat, long)
+		var entry cacheEntry
+		cached := false
+		if raw == nil && len(excluded) == 0 && !past && !noCache(r) {
+			entry, cached = sc.cache.get(key)
+		}
+		agg := entry.data
+		stale := false
+		if !cached {
+			ctx, cancel := context.WithTimeout(r.Context(), sc.providerTimeout)
+			defer cancel()
+			ctx = withForwardedHeaders(ctx, r, sc.forwarded)
+			if raw != nil {
+				ctx = withRawBodies(ctx, raw)
+			}
+			var sample *rawBodies
+			if sampled(sc.debugSampleRate) {
+				sample = raw
+				if sample == nil {
+					sample = &rawBodies{}
+					ctx = withRawBodies(ctx, sample)
+				}
+			}
+
+			// fetch asks the providers and, when the answer is complete, names the
+			// place for coordinate requests and caches it. It takes its own copies
+			// of city, lat and long since it may outlive the handler.
+			fetch := func(ctx context.Context, city string, lat float64, long float64) (aggregate, error) {
+				agg, err := mw.temperature(ctx, city, lat, long, reqOpts)
+				if sample != nil {
+					logSample(city, lat, long, agg, err, sample)
+				}
+				if err != nil {
+					return agg, err
+				}
+
+				// coordinate requests get the name of the nearest place
+				if city == "" && coordsGiven {
+					agg.City, err = sc.reverse.ReverseGeocode(ctx, lat, long)
+					if err != nil {
+						log.Printf("reverse geocoding %.4f, %.4f failed: %s", lat, long, err)
+					}
+				}
+
+				// an incomplete average shouldn't outlive the request that produced it
+				if len(agg.TimedOut) == 0 && len(excluded) == 0 && !past {
+					sc.cache.set(key, agg)
+				}
+				return agg, nil
+			}
+
+			// detached is a context for a fetch that outlives the request
+			detached := func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.WithoutCancel(ctx), sc.providerTimeout)
+			}
+
+			// an expired entry may stand in for fresh weather, unless the request
+			// asks for something the cache doesn't hold
+			var fallback cacheEntry
+			hasFallback := false
+			if raw == nil && len(excluded) == 0 && !past && !noCache(r) && (sc.softTimeout > 0 || sc.staleWhileRevalidate > 0) {
+				fallback, hasFallback = sc.cache.stale(key)
+			}
+
+			// with -stale-while-revalidate an entry that expired recently enough is
+			// served straight away, and refreshed in the background for the next request
+			if hasFallback && sc.staleWhileRevalidate > 0 && sc.clock.Since(fallback.stored) < sc.cache.ttl+sc.staleWhileRevalidate {
+				entry, agg, stale = fallback, fallback.data, true
+				w.Header().Set("X-Cache-Status", "stale")
+				city, lat, long := city, lat, long // the handler goes on to change them
+				refreshes.start(key, func() {
+					bg, bgCancel := detached()
+					defer bgCancel()
+					if _, err := fetch(bg, city, lat, long); err != nil {
+						log.Printf("refreshing stale weather for %s failed: %s", key, err)
+					}
+				})
+			} else if hasFallback && sc.softTimeout > 0 {
+				// with a soft timeout the fetch runs detached from the request, so
+				// that when it is too slow and the entry is served it still
+				// refreshes the cache
+				type fetched struct {
+					agg aggregate
+					err error
+				}
+				done := make(chan fetched, 1)
+				bg, bgCancel := detached()
+				go func(city string, lat float64, long float64) {
+					defer bgCancel()
+					agg, err := fetch(bg, city, lat, long)
+					done <- fetched{agg: agg, err: err}
+				}(city, lat, long)
+
+				select {
+				case f := <-done:
+					agg, err = f.agg, f.err
+				case <-sc.clock.After(sc.softTimeout):
+					log.Printf("serving cached weather for %s, fresh weather is taking over %s", key, sc.softTimeout)
+					entry, agg, stale = fallback, fallback.data, true
+					w.Header().Set("X-Cache-Status", "stale")
+				case <-r.Context().Done():
+					// the client is gone, and the fetch goes on to refresh the cache
+					return
+				}
+			} else {
+				agg, err = fetch(ctx, city, lat, long)
+			}
+			if err != nil && r.Context().Err() != nil {
+				// the client is gone, so there is nobody to answer
+				log.Printf("client went away before the weather for %s was in: %s", key, err)
+				return
+			}
+			if err != nil && sc.staleWhileError && len(excluded) == 0 && !past {
+				if e, ok := sc.cache.stale(key); ok {
+					log.Printf("serving stale weather for %s: %s", key, err)
+					entry, agg, stale, err = e, e.data, true, nil
+					w.Header().Set("X-Cache-Status", "stale")
+				}
+			}
+			if errors.Is(err, errNoData) && sc.noDataStatus == http.StatusNoContent {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if errors.Is(err, errNoData) {
+				http.Error(w, err.Error(), sc.noDataStatus)
+				return
+			}
+			if errors.Is(err, ErrGeocodeTimeout) {
+				http.Error(w, err.Error(), http.StatusGatewayTimeout)
+				return
+			}
+			var gerr *geocodeError
+			if errors.As(err, &gerr) {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if city == "" {
+			city = agg.City
+		}
+		wd := agg.weatherData
+		lat, long, temp := wd.Latitude, wd.Longitude, wd.Celsius
+
+		displayLat := formatLatitude(lat, precision, coords)
+		displayLong := formatLongitude(long, precision, coords)
+		displayTemp, err := formatTemperature("c", temp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("city:%s, latitude:%s, longitude:%s, temperature:%s", city, displayLat, displayLong, displayTemp)
+
+		measurements, err := formatMeasurements(system, wd)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp := weatherResponse{
+			City:               city,
+			ICAO:               icao,
+			Lat:                displayLat,
+			Long:               displayLong,
+			Temp:               measurements.temp,
+			TempC:              roundDecimal(temp, 2, displayRounding),
+			Wind:               measurements.wind,
+			Pressure:           measurements.pressure,
+			FeelsLike:          measurements.feelsLike,
+			Conditions:         wd.Conditions,
+			CoordFallback:      agg.CoordFallback,
+			LocationNames:      locationNames(agg.Readings),
+			Attribution:        buildAttributions(agg.Readings),
+			Timings:            formatTimings(agg.Timings),
+			At:                 formatTime(reqOpts.at),
+			Sunrise:            formatTime(wd.Sunrise),
+			Sunset:             formatTime(wd.Sunset),
+			Partial:            len(agg.TimedOut) > 0,
+			SingleSource:       len(agg.Readings) == 1,
+			Consensus:          consensus(agg.Readings, sc.consensusTolerance),
+			ProvidersQueried:   agg.Queried,
+			ProvidersSucceeded: agg.Succeeded,
+			Stale:              stale,
+			TimedOut:           agg.TimedOut,
+		}
+
+		// ?units= replaces "temp" with a field for each requested unit
+		if units != nil {
+			resp.Temp = ""
+		}
+		for _, u := range units {
+			v, err := formatTemperature(u, temp)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp.setUnit(u, v)
+		}
+
+		low, high := confidenceInterval(agg.Readings)
+		if resp.CILow, err = formatTemperature(system.tempUnit(), low); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if resp.CIHigh, err = formatTemperature(system.tempUnit(), high); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// a cached answer is as old as the time it has spent in the cache,
+		// a fresh one as old as the observations behind it
+		if cached || stale {
+			age := ageSeconds(sc.clock.Since(entry.stored))
+			resp.Age = &age
+		} else if !wd.Observed.IsZero() {
+			age := ageSeconds(sc.clock.Since(wd.Observed))
+			resp.Age = &age
+		}
+		if coordsGiven && (agg.ResolvedLatitude != 0.0 || agg.ResolvedLongitude != 0.0) {
+			km := math.Round(haversineKm(lat, long, agg.ResolvedLatitude, agg.ResolvedLongitude)*100) / 100
+			resp.CoordDeltaKm = &km
+		}
+		if pct, ok := spreadPct(agg.Readings); ok {
+			pct = math.Round(pct*10) / 10
+			resp.SpreadPct = &pct
+		}
+		if wd.HasCloudCover {
+			cover := math.Round(wd.CloudCover)
+			resp.CloudCover = &cover
+		}
+		if raw != nil {
+			resp.Raw = raw.snapshot()
+		}
+		if r.URL.Query().Get("sources") == "1" {
+			resp.Skipped = agg.Skipped
+			resp.Sources, err = buildSources(agg)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		localizeNumbers(&resp, locale)
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Add("Vary", "Accept")
+		msgpack := wantsMsgpack(r)
+
+		etag, err := responseETag(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the two encodings are different bytes, so they mustn't share a tag
+		if msgpack {
+			etag = strings.TrimSuffix(etag, `"`) + `-msgpack"`
+		}
+		if tag := fields.tag(); tag != "" {
+			etag = strings.TrimSuffix(etag, `"`) + "-" + tag + `"`
+		}
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		resp.Took = sc.clock.Since(begin).String()
+		body, err := fields.apply(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if msgpack {
+			encoded, err := marshalMsgpack(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", msgpackContentType)
+			w.Write(encoded)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(body)
+	}
+}
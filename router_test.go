@@ -0,0 +1,821 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeReverse names every place it is asked about name.
+type fakeReverse struct {
+	name string
+}
+
+func (g fakeReverse) ReverseGeocode(ctx context.Context, lat float64, long float64) (string, error) {
+	return g.name, nil
+}
+
+// testRoutes serves the providers given, with caching off and a second for
+// them to answer in.
+func testRoutes(providers ...weatherProvider) routes {
+	mw := multiWeatherProvider(providers)
+	var current atomic.Pointer[multiWeatherProvider]
+	current.Store(&mw)
+	var started atomic.Bool
+	started.Store(true)
+
+	clock := Clock(realClock{})
+	return routes{
+		providers:          &current,
+		started:            &started,
+		cache:              newWeatherCache(clock, 0),
+		clock:              clock,
+		reverse:            fakeReverse{name: "Bucharest"},
+		providerTimeout:    time.Second,
+		gridParallelism:    2,
+		maxCityLength:      100,
+		noDataStatus:       http.StatusInternalServerError,
+		consensusTolerance: 1,
+	}
+}
+
+// serve sends a GET for target to h.
+func serve(h http.Handler, target string) *httptest.ResponseRecorder {
+	return serveWith(context.Background(), h, target)
+}
+
+// serveWith sends a GET for target to h under ctx, such as one from withCanned.
+func serveWith(ctx context.Context, h http.Handler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx))
+	return rec
+}
+
+// decodeWeather reads a 200 response to /weather/.
+func decodeWeather(t *testing.T, rec *httptest.ResponseRecorder) weatherResponse {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", rec.Code, rec.Body.String())
+	}
+	var resp weatherResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %s", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func TestWeatherPartialWhenAProviderTimesOut(t *testing.T) {
+	fast := &fakeProvider{id: "partial-fast", data: reading(10)}
+	slow := &fakeProvider{id: "partial-slow", data: reading(20), delay: time.Minute}
+	sc := testRoutes(fast, slow)
+	sc.providerTimeout = 50 * time.Millisecond
+
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest"))
+	if !resp.Partial {
+		t.Error("partial not set")
+	}
+	if len(resp.TimedOut) != 1 || resp.TimedOut[0] != "partial-slow" {
+		t.Errorf("timed_out is %v, want [partial-slow]", resp.TimedOut)
+	}
+	if resp.Temp != "10.00°C" {
+		t.Errorf("temp is %s, want the fast provider's 10.00°C", resp.Temp)
+	}
+}
+
+func TestWeatherNotPartialWhenEveryProviderAnswers(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "complete-a", data: reading(10)}, &fakeProvider{id: "complete-b", data: reading(20)})
+
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest"))
+	if resp.Partial || len(resp.TimedOut) > 0 {
+		t.Errorf("partial %t with timed_out %v, want neither", resp.Partial, resp.TimedOut)
+	}
+	if resp.Temp != "15.00°C" {
+		t.Errorf("temp is %s, want 15.00°C", resp.Temp)
+	}
+}
+
+func TestWeatherCoordPrecision(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "precision", data: reading(10)})
+	tests := []struct {
+		query     string
+		lat, long string
+	}{
+		{"", "44.4268", "26.1025"},
+		{"&coordprecision=4", "44.4268", "26.1025"},
+		{"&coordprecision=2", "44.43", "26.10"},
+	}
+	for _, tt := range tests {
+		resp := decodeWeather(t, serve(newRouter(sc), "/weather/?lat=44.42684&long=26.10251"+tt.query))
+		if resp.Lat != tt.lat || resp.Long != tt.long {
+			t.Errorf("%q: got %s, %s, want %s, %s", tt.query, resp.Lat, resp.Long, tt.lat, tt.long)
+		}
+	}
+}
+
+func TestWeatherCoordPrecisionNotANumber(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "precision-bad", data: reading(10)})
+	if rec := serve(newRouter(sc), "/weather/?lat=44.4268&long=26.1025&coordprecision=two"); rec.Code != http.StatusBadRequest {
+		t.Errorf("got %d, want 400", rec.Code)
+	}
+}
+
+func TestWeatherUnits(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "units", data: reading(10)})
+
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest?units=f"))
+	if resp.Fahrenheit != "50.00°F" || resp.Celsius != "" || resp.Temp != "" {
+		t.Errorf("?units=f gave temp %q, c %q, f %q, want only f 50.00°F", resp.Temp, resp.Celsius, resp.Fahrenheit)
+	}
+
+	resp = decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest?units=c,k"))
+	if resp.Celsius != "10.00°C" || resp.Kelvin != "283.15K" || resp.Fahrenheit != "" {
+		t.Errorf("?units=c,k gave c %q, k %q, f %q, want c 10.00°C and k 283.15K", resp.Celsius, resp.Kelvin, resp.Fahrenheit)
+	}
+
+	for _, bad := range []string{"x", "c,x", "", ","} {
+		if rec := serve(newRouter(sc), "/weather/Bucharest?units="+bad); rec.Code != http.StatusBadRequest {
+			t.Errorf("?units=%s: got %d, want 400", bad, rec.Code)
+		}
+	}
+}
+
+func TestWeatherAge(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	observed := reading(10)
+	observed.Observed = clock.Now().Add(-90 * time.Second)
+	sc := testRoutes(&fakeProvider{id: "age", data: observed})
+	sc.clock = clock
+	sc.cache = newWeatherCache(clock, 10*time.Minute)
+	h := newRouter(sc)
+
+	// fresh, as old as the observation
+	resp := decodeWeather(t, serve(h, "/weather/Bucharest"))
+	if resp.Age == nil || *resp.Age != 90 {
+		t.Errorf("fresh age is %v, want 90", resp.Age)
+	}
+
+	// cached, as old as the entry
+	clock.Advance(30 * time.Second)
+	resp = decodeWeather(t, serve(h, "/weather/Bucharest"))
+	if resp.Age == nil || *resp.Age != 30 {
+		t.Errorf("cached age is %v, want 30", resp.Age)
+	}
+}
+
+func TestWeatherAgeUnknown(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "age-unknown", data: reading(10)})
+	if resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest")); resp.Age != nil {
+		t.Errorf("age is %d for a reading without an observation time, want none", *resp.Age)
+	}
+}
+
+func TestWeatherSystem(t *testing.T) {
+	wd := reading(20)
+	wd.WindSpeed, wd.Pressure = 10, 1013.25
+	sc := testRoutes(&fakeProvider{id: "system", data: wd})
+
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest?system=imperial"))
+	if resp.Temp != "68.00°F" || resp.Wind != "22.4 mph" || resp.Pressure != "29.92 inHg" {
+		t.Errorf("imperial gave %s, %s, %s, want 68.00°F, 22.4 mph, 29.92 inHg", resp.Temp, resp.Wind, resp.Pressure)
+	}
+
+	resp = decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest?system=metric"))
+	if resp.Temp != "20.00°C" || resp.Wind != "10.0 m/s" || resp.Pressure != "1013 hPa" {
+		t.Errorf("metric gave %s, %s, %s, want 20.00°C, 10.0 m/s, 1013 hPa", resp.Temp, resp.Wind, resp.Pressure)
+	}
+
+	if rec := serve(newRouter(sc), "/weather/Bucharest?system=nautical"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown system: got %d, want 400", rec.Code)
+	}
+}
+
+func TestWeatherNoDataStatus(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusNotFound, http.StatusInternalServerError} {
+		sc := testRoutes(&fakeProvider{id: "no-data", data: weatherData{}})
+		sc.noDataStatus = status
+
+		rec := serve(newRouter(sc), "/weather/Atlantis")
+		if rec.Code != status {
+			t.Errorf("-no-data-status=%d: got %d", status, rec.Code)
+		}
+		if status == http.StatusNoContent && rec.Body.Len() > 0 {
+			t.Errorf("204 came with a body: %q", rec.Body.String())
+		}
+	}
+}
+
+func TestWeatherRaw(t *testing.T) {
+	// in key order, as the response is written
+	body := `{"current_weather":{"temperature":3.5,"time":1700000000,"windspeed":2},"latitude":44.43,"longitude":26.1}`
+	ctx, _ := withCanned(map[string]cannedResponse{"api.open-meteo.com": {body: body}})
+	sc := testRoutes(openMeteo{})
+	sc.debug = true
+
+	resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025&raw=1"))
+	raw, err := json.Marshal(resp.Raw["openmeteo"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != body {
+		t.Errorf("raw openmeteo body is %s, want %s", raw, body)
+	}
+
+	resp = decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025"))
+	if resp.Raw != nil {
+		t.Errorf("raw bodies %v without ?raw=1", resp.Raw)
+	}
+
+	sc.debug = false
+	resp = decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025&raw=1"))
+	if resp.Raw != nil {
+		t.Errorf("raw bodies %v without -debug", resp.Raw)
+	}
+}
+
+func TestWeatherNamesCoordinateRequests(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "reverse", data: reading(10)})
+	sc.reverse = fakeReverse{name: "Ploiești"}
+
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/?lat=44.9367&long=26.0129"))
+	if resp.City != "Ploiești" {
+		t.Errorf("city is %q, want the reverse geocoded Ploiești", resp.City)
+	}
+
+	resp = decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest?lat=44.4268&long=26.1025"))
+	if resp.City != "Bucharest" {
+		t.Errorf("city is %q, want the Bucharest asked for", resp.City)
+	}
+}
+
+func TestWeatherRejectsLongCities(t *testing.T) {
+	p := &fakeProvider{id: "long-city", data: reading(10)}
+	sc := testRoutes(p)
+	sc.maxCityLength = 20
+	h := newRouter(sc)
+
+	if rec := serve(h, "/weather/"+strings.Repeat("x", 21)); rec.Code != http.StatusBadRequest {
+		t.Errorf("21 characters: got %d, want 400", rec.Code)
+	}
+	if p.calls.Load() != 0 {
+		t.Error("provider asked about an over-length city")
+	}
+
+	// the limit counts characters, not bytes
+	decodeWeather(t, serve(h, "/weather/"+strings.Repeat("ș", 20)))
+	decodeWeather(t, serve(h, "/weather/"+strings.Repeat("x", 20)))
+}
+
+func BenchmarkHandler(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	h := newRouter(testRoutes(
+		&fakeProvider{id: "bench-handler-a", data: reading(10)},
+		&fakeProvider{id: "bench-handler-b", data: reading(11)},
+		&fakeProvider{id: "bench-handler-c", data: reading(12)},
+	))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if rec := serve(h, "/weather/?lat=44.4268&long=26.1025"); rec.Code != http.StatusOK {
+			b.Fatalf("got %d %q, want 200", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestWeatherCoordFormat(t *testing.T) {
+	sc := testRoutes(&fakeProvider{id: "coord-format", data: reading(10)})
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/?lat=-33.8688&long=-70.6693&coordformat=hemisphere"))
+	if resp.Lat != "33.8688° S" || resp.Long != "70.6693° W" {
+		t.Errorf("got %s, %s, want 33.8688° S, 70.6693° W", resp.Lat, resp.Long)
+	}
+	if rec := serve(newRouter(sc), "/weather/Bucharest?coordformat=dms"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown coordformat: got %d, want 400", rec.Code)
+	}
+}
+
+func TestWeatherNeedsACityOrCoordinates(t *testing.T) {
+	p := &fakeProvider{id: "no-city", data: reading(10)}
+	h := newRouter(testRoutes(p))
+	for _, target := range []string{"/weather/", "/weather/%20", "/weather/%20%20?units=c"} {
+		if rec := serve(h, target); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", target, rec.Code)
+		}
+	}
+	if p.calls.Load() != 0 {
+		t.Error("provider asked without a city or coordinates")
+	}
+	decodeWeather(t, serve(h, "/weather/%20?lat=44.4268&long=26.1025"))
+}
+
+func TestWeatherExclude(t *testing.T) {
+	kept := &fakeProvider{id: "openmeteo", data: reading(10)}
+	excluded := &fakeProvider{id: "darksky", data: reading(30)}
+	also := &fakeProvider{id: "metno", data: reading(31)}
+	sc := testRoutes(kept, excluded, also)
+	sc.cache = newWeatherCache(sc.clock, time.Minute)
+	h := newRouter(sc)
+
+	resp := decodeWeather(t, serve(h, "/weather/Bucharest?exclude=darksky,%20MetNo&sources=1"))
+	if excluded.calls.Load() != 0 || also.calls.Load() != 0 {
+		t.Errorf("excluded providers asked %d and %d times, want never", excluded.calls.Load(), also.calls.Load())
+	}
+	if resp.Temp != "10.00°C" {
+		t.Errorf("temp is %s, want openmeteo's 10.00°C alone", resp.Temp)
+	}
+	reasons := map[string]string{}
+	for _, s := range resp.Skipped {
+		reasons[s.Provider] = s.Reason
+	}
+	if reasons["darksky"] != skipExcluded || reasons["metno"] != skipExcluded {
+		t.Errorf("skipped %v, want darksky and metno as excluded", resp.Skipped)
+	}
+
+	// the partial answer isn't cached for requests excluding nothing
+	resp = decodeWeather(t, serve(h, "/weather/Bucharest"))
+	if resp.Temp != "23.67°C" || excluded.calls.Load() != 1 {
+		t.Errorf("without ?exclude= temp is %s with darksky asked %d times, want 23.67°C from all three", resp.Temp, excluded.calls.Load())
+	}
+
+	if rec := serve(h, "/weather/Bucharest?exclude=nosuch"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown provider: got %d, want 400", rec.Code)
+	}
+}
+
+func TestWeatherMinProvidersCantRaiseTheMinimum(t *testing.T) {
+	t.Cleanup(func() { switches.set("metno", true) })
+	switches.set("metno", false)
+
+	slow := &fakeProvider{id: "minproviders-slow", data: reading(30), delay: time.Minute}
+	sc := testRoutes(
+		&fakeProvider{id: "minproviders-a", data: reading(10)},
+		&fakeProvider{id: "minproviders-b", data: reading(12)},
+		&fakeProvider{id: "metno", data: reading(40)},
+		slow,
+	)
+	sc.opts.minReadings = 2
+
+	// 3 of the 3 enabled providers is allowed, but capped at -min-providers
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest?lat=44.4268&long=26.1025&minproviders=3&sources=1"))
+	if resp.Temp != "11.00°C" {
+		t.Errorf("temp is %s, want 11.00°C from the first two", resp.Temp)
+	}
+	for _, s := range resp.Skipped {
+		if s.Provider == slow.id && s.Reason != skipNotNeeded {
+			t.Errorf("%s skipped as %q, want it not needed after -min-providers readings", s.Provider, s.Reason)
+		}
+	}
+
+	// the switched-off provider doesn't count, so 4 is more than there are
+	for _, v := range []string{"4", "0"} {
+		if rec := serve(newRouter(sc), "/weather/Bucharest?lat=44.4268&long=26.1025&minproviders="+v); rec.Code != http.StatusBadRequest {
+			t.Errorf("minproviders=%s: got %d, want 400", v, rec.Code)
+		}
+	}
+}
+
+func TestWeatherTimings(t *testing.T) {
+	delays := map[string]time.Duration{"timings-fast": 20 * time.Millisecond, "timings-slow": 80 * time.Millisecond}
+	sc := testRoutes(
+		&fakeProvider{id: "timings-fast", data: reading(10), delay: delays["timings-fast"]},
+		&fakeProvider{id: "timings-slow", data: reading(12), delay: delays["timings-slow"]},
+	)
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest?lat=44.4268&long=26.1025"))
+
+	if len(resp.Timings) != len(delays) {
+		t.Errorf("timings %v, want one for each provider", resp.Timings)
+	}
+	for name, delay := range delays {
+		took, err := time.ParseDuration(resp.Timings[name])
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if took < delay || took > delay+200*time.Millisecond {
+			t.Errorf("%s took %s, want about its %s delay", name, took, delay)
+		}
+	}
+}
+
+func TestWeatherSingleSource(t *testing.T) {
+	sc := testRoutes(
+		&fakeProvider{id: "single-ok", data: reading(10)},
+		&fakeProvider{id: "single-fails", err: ErrProviderUnavailable},
+	)
+	h := newRouter(sc)
+	if resp := decodeWeather(t, serve(h, "/weather/Bucharest")); !resp.SingleSource {
+		t.Error("single_source not set for one reading")
+	}
+
+	sc = testRoutes(&fakeProvider{id: "single-a", data: reading(10)}, &fakeProvider{id: "single-b", data: reading(12)})
+	if resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest")); resp.SingleSource {
+		t.Error("single_source set for two readings")
+	}
+}
+
+func TestWeatherProviderCounts(t *testing.T) {
+	sc := testRoutes(
+		&fakeProvider{id: "counts-a", data: reading(10)},
+		&fakeProvider{id: "counts-fails", err: ErrProviderUnavailable},
+		&fakeProvider{id: "counts-b", data: reading(12)},
+		&fakeProvider{id: "counts-empty"},
+		&fakeProvider{id: "counts-hot", data: reading(plausibleTemps.max + 1)},
+	)
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest?lat=44.4268&long=26.1025"))
+	if resp.ProvidersQueried != 5 || resp.ProvidersSucceeded != 2 {
+		t.Errorf("queried %d, succeeded %d, want 5 and 2", resp.ProvidersQueried, resp.ProvidersSucceeded)
+	}
+}
+
+func TestWeatherTempCMatchesTemp(t *testing.T) {
+	for _, c := range []float64{12.344, -3.5, 0} {
+		h := newRouter(testRoutes(&fakeProvider{id: "temp-c", data: reading(c)}))
+		rec := serve(h, "/weather/Bucharest")
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fields["temp_c"]; !ok {
+			t.Errorf("%v: no temp_c in %s", c, rec.Body.String())
+		}
+		resp := decodeWeather(t, rec)
+		shown, err := strconv.ParseFloat(strings.TrimSuffix(resp.Temp, "°C"), 64)
+		if err != nil || shown != resp.TempC {
+			t.Errorf("%v: temp %q and temp_c %v disagree", c, resp.Temp, resp.TempC)
+		}
+	}
+}
+
+func TestNewRouterOverHTTP(t *testing.T) {
+	srv := httptest.NewServer(newRouter(testRoutes(&fakeProvider{id: "over-http", data: reading(10)})))
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/weather/Bucharest", http.StatusOK},
+		{"/livez", http.StatusOK},
+		{"/openapi.json", http.StatusOK},
+		{"/stats", http.StatusOK},
+		{"/debug/cache", http.StatusNotFound}, // without -debug
+		{"/nosuch", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/weather/Bucharest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body weatherResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Temp != "10.00°C" || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Errorf("got temp %q as %q, want 10.00°C as JSON", body.Temp, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestWeatherSkippedReasons(t *testing.T) {
+	sc := testRoutes(
+		&fakeProvider{id: "openmeteo", data: reading(10)},
+		&fakeProvider{id: "darksky", data: reading(30)},
+		&fakeProvider{id: "skip-down", err: ErrProviderUnavailable},
+		&fakeProvider{id: "skip-hot", data: reading(plausibleTemps.max + 1)},
+	)
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest?lat=44.4268&long=26.1025&exclude=darksky&sources=1"))
+
+	reasons := map[string]string{}
+	for _, s := range resp.Skipped {
+		reasons[s.Provider] = s.Reason
+	}
+	want := map[string]string{"darksky": skipExcluded, "skip-down": skipUnavailable, "skip-hot": skipImplausible}
+	if len(reasons) != len(want) {
+		t.Errorf("skipped %+v, want %v", resp.Skipped, want)
+	}
+	for provider, reason := range want {
+		if reasons[provider] != reason {
+			t.Errorf("%s skipped as %q, want %q", provider, reasons[provider], reason)
+		}
+	}
+}
+
+func TestWeatherConfidenceInterval(t *testing.T) {
+	sc := testRoutes(
+		&fakeProvider{id: "ci-a", data: reading(10)},
+		&fakeProvider{id: "ci-b", data: reading(12)},
+		&fakeProvider{id: "ci-c", data: reading(14)},
+	)
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/Bucharest"))
+	if resp.CILow != "7.03°C" || resp.CIHigh != "16.97°C" {
+		t.Errorf("got %s to %s, want 7.03°C to 16.97°C", resp.CILow, resp.CIHigh)
+	}
+}
+
+// cachedRoutes serves the providers like testRoutes, with a cache on clock
+// holding entries for ttl and for maxStale after that.
+func cachedRoutes(clock *fakeClock, ttl time.Duration, maxStale time.Duration, providers ...weatherProvider) routes {
+	sc := testRoutes(providers...)
+	sc.clock = clock
+	sc.cache = newWeatherCache(clock, ttl)
+	sc.cache.maxStale = maxStale
+	return sc
+}
+
+func TestWeatherNoCacheRefreshesTheEntry(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	p := &fakeProvider{id: "no-cache", data: reading(10)}
+	sc := cachedRoutes(clock, time.Minute, 0, p)
+	h := newRouter(sc)
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+
+	byHeader := httptest.NewRequest(http.MethodGet, "/weather/Bucharest", nil)
+	byHeader.Header.Set("Cache-Control", "no-cache")
+	tests := []struct {
+		name string
+		r    *http.Request
+		temp float64
+	}{
+		{"Cache-Control: no-cache", byHeader, 20},
+		{"?nocache=1", httptest.NewRequest(http.MethodGet, "/weather/Bucharest?nocache=1", nil), 30},
+	}
+	for _, tt := range tests {
+		p.data = reading(tt.temp)
+		want := fmt.Sprintf("%.2f°C", tt.temp)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, tt.r)
+		if resp := decodeWeather(t, rec); resp.Temp != want {
+			t.Errorf("%s: temp is %s, want the fresh %s", tt.name, resp.Temp, want)
+		}
+
+		p.data = reading(0)
+		if resp := decodeWeather(t, serve(h, "/weather/Bucharest")); resp.Temp != want {
+			t.Errorf("%s: the cache then holds %s, want the refreshed %s", tt.name, resp.Temp, want)
+		}
+	}
+	if n := p.calls.Load(); n != 3 {
+		t.Errorf("provider asked %d times, want once plus once for each no-cache request", n)
+	}
+}
+
+func TestWeatherStaleWhileError(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	p := &fakeProvider{id: "stale-while-error", data: reading(10)}
+	sc := cachedRoutes(clock, time.Minute, time.Hour, p)
+	sc.staleWhileError = true
+	h := newRouter(sc)
+
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+	p.err = errors.New("boom")
+	clock.Advance(2 * time.Minute)
+
+	rec := serve(h, "/weather/Bucharest")
+	resp := decodeWeather(t, rec)
+	if !resp.Stale || resp.Temp != "10.00°C" || rec.Header().Get("X-Cache-Status") != "stale" {
+		t.Errorf("got %s, stale %t, X-Cache-Status %q, want the stale 10.00°C", resp.Temp, resp.Stale, rec.Header().Get("X-Cache-Status"))
+	}
+	if resp.Age == nil || *resp.Age != 120 {
+		t.Errorf("age is %v, want the entry's 120", resp.Age)
+	}
+	if p.calls.Load() != 2 {
+		t.Errorf("asked %d times, want a fresh fetch tried before the stale entry was served", p.calls.Load())
+	}
+
+	// once the provider recovers the answer is fresh again
+	p.err = nil
+	p.data = reading(11)
+	resp = decodeWeather(t, serve(h, "/weather/Bucharest"))
+	if resp.Stale || resp.Temp != "11.00°C" {
+		t.Errorf("got %s, stale %t, want a fresh 11.00°C", resp.Temp, resp.Stale)
+	}
+}
+
+func TestWeatherErrorWithoutStaleWhileError(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	p := &fakeProvider{id: "no-stale-while-error", data: reading(10)}
+	h := newRouter(cachedRoutes(clock, time.Minute, time.Hour, p))
+
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+	p.err = errors.New("boom")
+	clock.Advance(2 * time.Minute)
+	if rec := serve(h, "/weather/Bucharest"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("got %d, want the failure as a 500", rec.Code)
+	}
+}
+
+func TestWeatherPastMaxStaleIsAnError(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	p := &fakeProvider{id: "past-max-stale", data: reading(10)}
+	sc := cachedRoutes(clock, time.Minute, time.Hour, p)
+	sc.staleWhileError = true
+	h := newRouter(sc)
+
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+	p.err = errors.New("boom")
+	clock.Advance(time.Minute + time.Hour)
+	if rec := serve(h, "/weather/Bucharest"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("got %d %q, want the failure rather than weather over an hour stale", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWeatherClientGoingAwayCancelsProviders(t *testing.T) {
+	const n = 3
+	started := make(chan struct{}, n)
+	ended := make(chan error, n)
+	var finished atomic.Int32
+	blocked := func(id string) *fakeProvider {
+		return &fakeProvider{id: id, answer: func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+			started <- struct{}{}
+			select {
+			case <-ctx.Done():
+				ended <- ctx.Err()
+				return weatherData{}, ctx.Err()
+			case <-time.After(time.Minute):
+				finished.Add(1)
+				return reading(10), nil
+			}
+		}}
+	}
+	sc := testRoutes(blocked("gone-a"), blocked("gone-b"), blocked("gone-c"))
+	sc.providerTimeout = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan *httptest.ResponseRecorder, 1)
+	go func() { served <- serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025") }()
+	for i := 0; i < n; i++ {
+		<-started
+	}
+	cancel()
+
+	select {
+	case rec := <-served:
+		if rec.Body.Len() != 0 {
+			t.Errorf("answered %q to a client that went away", rec.Body.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still running after the client went away")
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-ended:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("provider's context ended with %v, want context.Canceled", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d of %d providers still running after the client went away", n-i, n)
+		}
+	}
+	if f := finished.Load(); f != 0 {
+		t.Errorf("%d providers finished, want all cut short", f)
+	}
+}
+
+func TestWeatherSoftTimeoutServesTheStaleEntry(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	asked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	p := &fakeProvider{id: "soft-timeout"}
+	p.answer = func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+		if p.calls.Load() == 1 {
+			return reading(10), nil
+		}
+		asked <- struct{}{}
+		<-release
+		return reading(20), nil
+	}
+	sc := cachedRoutes(clock, time.Minute, time.Hour, p)
+	sc.softTimeout = time.Second
+	h := newRouter(sc)
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+	clock.Advance(2 * time.Minute)
+
+	served := make(chan *httptest.ResponseRecorder, 1)
+	go func() { served <- serve(h, "/weather/Bucharest") }()
+	<-asked
+	for clock.waiters() == 0 {
+		runtime.Gosched()
+	}
+	clock.Advance(sc.softTimeout)
+
+	rec := <-served
+	resp := decodeWeather(t, rec)
+	if resp.Temp != "10.00°C" || !resp.Stale || rec.Header().Get("X-Cache-Status") != "stale" {
+		t.Errorf("got %s, stale %t, X-Cache-Status %q, want the cached 10.00°C marked stale", resp.Temp, resp.Stale, rec.Header().Get("X-Cache-Status"))
+	}
+
+	// the slow fetch goes on to refresh the cache
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if e, ok := sc.cache.get(cacheKey("Bucharest", 0, 0)); ok && e.data.Celsius == 20 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cache not refreshed by the slow fetch")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWeatherSoftTimeoutWaitsForAQuickFetch(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	p := &fakeProvider{id: "soft-timeout-quick", data: reading(10)}
+	sc := cachedRoutes(clock, time.Minute, time.Hour, p)
+	sc.softTimeout = time.Second
+	h := newRouter(sc)
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+	clock.Advance(2 * time.Minute)
+
+	p.data = reading(20)
+	rec := serve(h, "/weather/Bucharest")
+	if resp := decodeWeather(t, rec); resp.Temp != "20.00°C" || resp.Stale {
+		t.Errorf("got %s, stale %t, want the fresh 20.00°C", resp.Temp, resp.Stale)
+	}
+}
+
+func TestWeatherStaleWhileRevalidate(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	asked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	p := &fakeProvider{id: "swr"}
+	p.answer = func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+		if p.calls.Load() == 1 {
+			return reading(10), nil
+		}
+		asked <- struct{}{}
+		<-release
+		return reading(20), nil
+	}
+	sc := cachedRoutes(clock, time.Minute, time.Hour, p)
+	sc.staleWhileRevalidate = 5 * time.Minute
+	h := newRouter(sc)
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+	clock.Advance(2 * time.Minute)
+
+	// served while the refresh is still held up, so without waiting for it
+	for i := 0; i < 2; i++ {
+		rec := serve(h, "/weather/Bucharest")
+		resp := decodeWeather(t, rec)
+		if resp.Temp != "10.00°C" || !resp.Stale || rec.Header().Get("X-Cache-Status") != "stale" {
+			t.Errorf("request %d: got %s, stale %t, X-Cache-Status %q, want the cached 10.00°C marked stale", i, resp.Temp, resp.Stale, rec.Header().Get("X-Cache-Status"))
+		}
+	}
+	<-asked
+	if n := p.calls.Load(); n != 2 {
+		t.Errorf("asked %d times, want one refresh for both stale requests", n-1)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if e, ok := sc.cache.get(cacheKey("Bucharest", 0, 0)); ok && e.data.Celsius == 20 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cache not refreshed in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if resp := decodeWeather(t, serve(h, "/weather/Bucharest")); resp.Temp != "20.00°C" || resp.Stale {
+		t.Errorf("got %s, stale %t, want the refreshed 20.00°C", resp.Temp, resp.Stale)
+	}
+}
+
+func TestWeatherTooStaleToRevalidate(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	p := &fakeProvider{id: "swr-too-stale", data: reading(10)}
+	sc := cachedRoutes(clock, time.Minute, time.Hour, p)
+	sc.staleWhileRevalidate = 5 * time.Minute
+	h := newRouter(sc)
+	decodeWeather(t, serve(h, "/weather/Bucharest"))
+
+	// past the window the request waits for fresh weather
+	clock.Advance(10 * time.Minute)
+	p.data = reading(20)
+	if resp := decodeWeather(t, serve(h, "/weather/Bucharest")); resp.Temp != "20.00°C" || resp.Stale {
+		t.Errorf("got %s, stale %t, want the fresh 20.00°C", resp.Temp, resp.Stale)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// selfTestCity is asked of every provider by -selftest, by name and coordinates
+// so that providers needing either can answer.
+var selfTestCity = struct {
+	name      string
+	lat, long float64
+}{"Bucharest", 44.4268, 26.1025}
+
+// conversionChecks are known values for the conversion helpers.
+var conversionChecks = []struct {
+	name      string
+	convert   func(float64) (float64, error)
+	in, want  float64
+	tolerance float64
+}{
+	{"celsiusToKelvin", celsiusToKelvin, 0, 273.15, 1e-9},
+	{"kelvinToCelsius", kelvinToCelsius, 373.15, 100, 1e-9},
+	{"fahrenheitToCelsius", fahrenheitToCelsius, -40, -40, 1e-9},
+	{"celsiusToFahrenheit", celsiusToFahrenheit, 100, 212, 1e-9},
+	{"kelvinToFahrenheit", kelvinToFahrenheit, 273.15, 32, 1e-9},
+	{"metresPerSecondToMph", metresPerSecondToMph, 10, 22.3694, 1e-4},
+	{"mphToMetresPerSecond", mphToMetresPerSecond, 22.3694, 10, 1e-4},
+	{"hectopascalsToInchesOfMercury", hectopascalsToInchesOfMercury, 1013.25, 29.9213, 1e-4},
+}
+
+// runSelfTest asks every provider about selfTestCity and checks the conversion
+// helpers, writing a line per check to out. It reports whether everything
+// passed. Provider calls go through ask, so they also update the reachability
+// /readyz goes by.
+func runSelfTest(out io.Writer, mw multiWeatherProvider, timeout time.Duration) bool {
+	ok := true
+	report := func(passed bool, name string, format string, args ...interface{}) {
+		status := "PASS"
+		if !passed {
+			status = "FAIL"
+			ok = false
+		}
+		fmt.Fprintf(out, "%s %s: %s\n", status, name, fmt.Sprintf(format, args...))
+	}
+
+	for _, p := range mw {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		wd, err := ask(ctx, realClock{}, p, selfTestCity.name, selfTestCity.lat, selfTestCity.long)
+		cancel()
+
+		switch {
+		case err != nil:
+			report(false, p.name(), "%s", err)
+		case !wd.HasReading:
+			report(false, p.name(), "no reading for %s", selfTestCity.name)
+		case !wd.valid():
+			report(false, p.name(), "%.2f°C in %s is outside %s", wd.Celsius, selfTestCity.name, plausibleTemps)
+		default:
+			report(true, p.name(), "%.2f°C in %s", wd.Celsius, selfTestCity.name)
+		}
+	}
+
+	for _, c := range conversionChecks {
+		got, err := c.convert(c.in)
+		switch {
+		case err != nil:
+			report(false, c.name, "%g: %s", c.in, err)
+		case math.Abs(got-c.want) > c.tolerance:
+			report(false, c.name, "%g gave %g, want %g", c.in, got, c.want)
+		default:
+			report(true, c.name, "%g = %g", c.in, got)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfTest(t *testing.T) {
+	tests := []struct {
+		p      *fakeProvider
+		passed bool
+		line   string
+	}{
+		{&fakeProvider{id: "selftest-ok", data: reading(10)}, true, "PASS selftest-ok: 10.00°C in Bucharest"},
+		{&fakeProvider{id: "selftest-error", err: errors.New("boom")}, false, "FAIL selftest-error: boom"},
+		{&fakeProvider{id: "selftest-empty"}, false, "FAIL selftest-empty: no reading for Bucharest"},
+		{&fakeProvider{id: "selftest-implausible", data: reading(500)}, false, "FAIL selftest-implausible: 500.00°C in Bucharest is outside"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		passed := runSelfTest(&out, multiWeatherProvider{tt.p}, time.Second)
+		if passed != tt.passed || !strings.Contains(out.String(), tt.line) {
+			t.Errorf("%s: passed %t with\n%s\nwant %t with %q", tt.p.id, passed, out.String(), tt.passed, tt.line)
+		}
+		if tt.p.calls.Load() != 1 {
+			t.Errorf("%s: asked %d times, want once", tt.p.id, tt.p.calls.Load())
+		}
+	}
+}
+
+func TestSelfTestMixedProviders(t *testing.T) {
+	var out bytes.Buffer
+	mw := multiWeatherProvider{
+		&fakeProvider{id: "selftest-mixed-ok", data: reading(10)},
+		&fakeProvider{id: "selftest-mixed-failing", err: errors.New("boom")},
+	}
+	if runSelfTest(&out, mw, time.Second) {
+		t.Errorf("passed with a failing provider:\n%s", out.String())
+	}
+	// every provider is checked, and the conversions too
+	for _, line := range []string{"PASS selftest-mixed-ok", "FAIL selftest-mixed-failing", "PASS celsiusToKelvin"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("missing %q in\n%s", line, out.String())
+		}
+	}
+	if n := strings.Count(out.String(), "\n"); n != len(mw)+len(conversionChecks) {
+		t.Errorf("%d lines, want one per provider and conversion", n)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// serverTimeouts bound how long a client may hold a connection at each stage.
+type serverTimeouts struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+}
+
+func newServer(addr string, handler http.Handler, t serverTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: t.readHeader,
+		ReadTimeout:       t.read,
+		WriteTimeout:      t.write,
+		IdleTimeout:       t.idle,
+	}
+}
+
+// run serves handler on addr until ctx is done, then shuts down, giving
+// requests in flight up to the write timeout to finish.
+func run(ctx context.Context, addr string, handler http.Handler, t serverTimeouts) error {
+	srv := newServer(addr, handler, t)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), t.write)
+	defer cancel()
+	return srv.Shutdown(shutdown)
+}
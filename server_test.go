@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewServerTimeouts(t *testing.T) {
+	timeouts := serverTimeouts{readHeader: time.Second, read: 2 * time.Second, write: 3 * time.Second, idle: 4 * time.Second}
+	srv := newServer(":8080", http.NotFoundHandler(), timeouts)
+
+	if srv.Addr != ":8080" {
+		t.Errorf("addr is %q, want :8080", srv.Addr)
+	}
+	got := serverTimeouts{readHeader: srv.ReadHeaderTimeout, read: srv.ReadTimeout, write: srv.WriteTimeout, idle: srv.IdleTimeout}
+	if got != timeouts {
+		t.Errorf("got timeouts %+v, want %+v", got, timeouts)
+	}
+}
+
+func TestServerDropsSlowHeaders(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer("", http.NotFoundHandler(), serverTimeouts{readHeader: 50 * time.Millisecond, read: time.Second, write: time.Second, idle: time.Second})
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// start a request and never finish its headers
+	if _, err := io.WriteString(conn, "GET /weather/Bucharest HTTP/1.1\r\nHost: localhost\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection not closed by the server: %s", err)
+	}
+}
+
+func TestRunShutsDownWithTheContext(t *testing.T) {
+	timeouts := serverTimeouts{readHeader: time.Second, read: time.Second, write: time.Second, idle: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, "127.0.0.1:0", http.NotFoundHandler(), timeouts) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("got %v, want a clean shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return once its context was done")
+	}
+
+	if err := run(context.Background(), "127.0.0.1:-1", http.NotFoundHandler(), timeouts); err == nil {
+		t.Error("run on a bad address returned no error")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import "errors"
+
+// sourceEntry describes one provider's part in a response, for ?sources=1.
+type sourceEntry struct {
+	Provider   string `json:"provider"`
+	Temp       string `json:"temp"`
+	NativeUnit string `json:"native_unit,omitempty"`
+	FromCache  bool   `json:"from_cache"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+
+	Quality map[string]string `json:"quality,omitempty"` // the provider's own data-quality hints
+}
+
+// skippedProvider is a provider that didn't contribute to an aggregate, and why.
+type skippedProvider struct {
+	Provider string `json:"provider"`
+	Reason   string `json:"reason"`
+
+	HTTPStatus int `json:"http_status,omitempty"`
+}
+
+// The reasons a provider is skipped.
+const (
+	skipDisabled    = "disabled"    // switched off through /admin/providers
+	skipExcluded    = "excluded"    // left out by ?exclude=
+	skipTimedOut    = "timed_out"   // didn't answer in time
+	skipUnavailable = "unavailable" // failed in a way that is passed over
+	skipFailed      = "failed"      // failed while other providers are required
+	skipNoReading   = "no_reading"  // answered without a usable reading, e.g. for want of coordinates
+	skipImplausible = "implausible" // answered with a temperature outside plausibleTemps
+	skipDuplicate   = "duplicate"   // same coordinates as a reading already counted
+	skipNotNeeded   = "not_needed"  // enough readings were in before it answered
+	skipNoHistory   = "no_history"  // can't give past readings, for ?at=
+)
+
+func failureReason(err error) string {
+	if errors.Is(err, ErrProviderUnavailable) {
+		return skipUnavailable
+	}
+	return skipFailed
+}
+
+// buildSources lists the readings behind an aggregate, in provider order.
+func buildSources(agg aggregate) ([]sourceEntry, error) {
+	sources := make([]sourceEntry, 0, len(agg.Readings))
+	for _, r := range agg.Readings {
+		temp, err := formatTemperature("c", r.data.Celsius)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, sourceEntry{
+			Provider:   r.provider,
+			Temp:       temp,
+			NativeUnit: r.data.NativeUnit,
+			FromCache:  r.fromCache,
+			HTTPStatus: r.data.HTTPStatus,
+			Quality:    r.data.Quality,
+		})
+	}
+	return sources, nil
+}
+
+// attribution is the credit a provider asks for wherever its data is shown.
+type attribution struct {
+	Provider string `json:"provider"`
+	Text     string `json:"text"`
+	URL      string `json:"url,omitempty"`
+}
+
+// providerAttributions are the credits required by each provider's terms. The
+// file provider is our own data and needs none.
+var providerAttributions = map[string]attribution{
+	"openweathermap": {Text: "Weather data provided by OpenWeather", URL: "https://openweathermap.org/"},
+	"darksky":        {Text: "Powered by Dark Sky", URL: "https://darksky.net/poweredby/"},
+	"weatherbit":     {Text: "Weather data by Weatherbit", URL: "https://www.weatherbit.io/"},
+	"openmeteo":      {Text: "Weather data by Open-Meteo.com, CC BY 4.0", URL: "https://open-meteo.com/"},
+	"metno":          {Text: "Data from MET Norway, CC BY 4.0", URL: "https://api.met.no/"},
+}
+
+// buildAttributions lists the credits of the providers whose readings went into
+// an aggregate, once each, in provider order. A reading a fallback answered is
+// credited to the fallback.
+func buildAttributions(readings []providerReading) []attribution {
+	var credits []attribution
+	seen := map[string]bool{}
+	for _, r := range readings {
+		source := r.source()
+		a, ok := providerAttributions[source]
+		if !ok || seen[source] {
+			continue
+		}
+		seen[source] = true
+		a.Provider = source
+		credits = append(credits, a)
+	}
+	return credits
+}
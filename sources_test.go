@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestBuildAttributions(t *testing.T) {
+	fellBack := reading(12)
+	fellBack.Source = "openmeteo"
+	readings := []providerReading{
+		{provider: "metno", data: reading(10)},
+		{provider: "darksky", data: fellBack},
+		{provider: "openmeteo", data: reading(11)},
+		{provider: "file", data: reading(13)}, // our own data, with nothing to credit
+	}
+
+	want := []attribution{
+		{Provider: "metno", Text: "Data from MET Norway, CC BY 4.0", URL: "https://api.met.no/"},
+		{Provider: "openmeteo", Text: "Weather data by Open-Meteo.com, CC BY 4.0", URL: "https://open-meteo.com/"},
+	}
+	if got := buildAttributions(readings); !reflect.DeepEqual(got, want) {
+		t.Errorf("credited %+v, want %+v, once each", got, want)
+	}
+}
+
+func TestWeatherAttributesTheProvidersThatContributed(t *testing.T) {
+	ctx, _ := withCanned(map[string]cannedResponse{
+		"api.darksky.net":        {status: http.StatusInternalServerError},
+		"api.open-meteo.com":     providerFixtures["api.open-meteo.com"],
+		"api.met.no":             providerFixtures["api.met.no"],
+		"api.openweathermap.org": {status: http.StatusInternalServerError},
+	})
+	sc := testRoutes(
+		openWeatherMap{apiKey: "key"},
+		fallbackProvider{weatherProvider: darkSky{apiKey: "key"}, backup: openMeteo{}},
+		metNo{},
+	)
+	resp := decodeWeather(t, serveWith(ctx, newRouter(sc), "/weather/?lat=44.4268&long=26.1025"))
+
+	var got []string
+	for _, a := range resp.Attribution {
+		got = append(got, a.Provider)
+	}
+	// openweathermap failed, and darksky's reading is open-meteo's
+	if want := []string{"openmeteo", "metno"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("credited %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxStationDistanceKm is how far away the nearest station may be and still
+// stand in for the requested coordinates.
+const maxStationDistanceKm = 100.0
+
+// station is one reading in a station file.
+type station struct {
+	City      string  `json:"city"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"long"`
+	Celsius   float64 `json:"temp"`
+}
+
+// fileProvider answers from a local station file such as a sensor export,
+// without touching the network. Cities are matched by name, ignoring case,
+// and coordinates by the nearest station.
+type fileProvider struct {
+	stations []station
+}
+
+// loadStations reads a station file. Files ending in .csv need a header row
+// naming the city, lat, long and temp columns, anything else is read as a JSON
+// array of {"city", "lat", "long", "temp"} objects. Temperatures are in celsius.
+func loadStations(path string) (fileProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileProvider{}, err
+	}
+	defer f.Close()
+
+	var stations []station
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		stations, err = readStationCSV(f)
+	} else {
+		err = json.NewDecoder(f).Decode(&stations)
+	}
+	if err != nil {
+		return fileProvider{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(stations) == 0 {
+		return fileProvider{}, fmt.Errorf("%s: no stations", path)
+	}
+	return fileProvider{stations: stations}, nil
+}
+
+func readStationCSV(r io.Reader) ([]station, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("missing header row")
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"city", "lat", "long", "temp"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing %s column", name)
+		}
+	}
+
+	var stations []station
+	for n, row := range rows[1:] {
+		number := func(column string) (float64, error) {
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[columns[column]]), 64)
+			if err != nil {
+				return 0, fmt.Errorf("row %d: %s %q is not a number", n+2, column, row[columns[column]])
+			}
+			return v, nil
+		}
+
+		s := station{City: strings.TrimSpace(row[columns["city"]])}
+		if s.Latitude, err = number("lat"); err != nil {
+			return nil, err
+		}
+		if s.Longitude, err = number("long"); err != nil {
+			return nil, err
+		}
+		if s.Celsius, err = number("temp"); err != nil {
+			return nil, err
+		}
+		stations = append(stations, s)
+	}
+	return stations, nil
+}
+
+func (w fileProvider) name() string { return "file" }
+
+func (w fileProvider) temperature(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+	s, ok := w.lookup(city, lat, long)
+	if !ok {
+		// nothing close enough, which is no reading rather than an error
+		return weatherData{}, nil
+	}
+
+	f, err := celsiusToFahrenheit(s.Celsius)
+	if err != nil {
+		return weatherData{}, err
+	}
+	k, err := celsiusToKelvin(s.Celsius)
+	if err != nil {
+		return weatherData{}, err
+	}
+
+	return weatherData{
+		HasReading:   true,
+		Celsius:      s.Celsius,
+		Fahrenheit:   f,
+		Kelvin:       k,
+		Latitude:     s.Latitude,
+		Longitude:    s.Longitude,
+		NativeUnit:   "c",
+		LocationName: s.City,
+	}, nil
+}
+
+// lookup finds the station named city, or else the one nearest lat/long
+// within maxStationDistanceKm.
+func (w fileProvider) lookup(city string, lat float64, long float64) (station, bool) {
+	for _, s := range w.stations {
+		if city != "" && strings.EqualFold(s.City, city) {
+			return s, true
+		}
+	}
+
+	if lat == 0.0 && long == 0.0 {
+		return station{}, false
+	}
+
+	best := -1
+	bestKm := math.Inf(1)
+	for i, s := range w.stations {
+		if km := haversineKm(lat, long, s.Latitude, s.Longitude); km < bestKm {
+			best, bestKm = i, km
+		}
+	}
+	if best < 0 || bestKm > maxStationDistanceKm {
+		return station{}, false
+	}
+	return w.stations[best], true
+}
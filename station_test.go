@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+const stationCSV = `city,lat,long,temp
+Bucharest,44.4268,26.1025,10.5
+Ploiești,44.9367,26.0129,8
+Cluj-Napoca,46.7712,23.6236,4.25
+`
+
+const stationJSON = `[
+	{"city": "Bucharest", "lat": 44.4268, "long": 26.1025, "temp": 10.5},
+	{"city": "Ploiești", "lat": 44.9367, "long": 26.0129, "temp": 8},
+	{"city": "Cluj-Napoca", "lat": 46.7712, "long": 23.6236, "temp": 4.25}
+]`
+
+func TestFileProviderLookup(t *testing.T) {
+	for _, file := range []string{writeFile(t, "stations.csv", stationCSV), writeFile(t, "stations.json", stationJSON)} {
+		p, err := loadStations(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			city      string
+			lat, long float64
+			want      string // the station answering, none when empty
+		}{
+			{"bucharest", 0, 0, "Bucharest"},
+			{"CLUJ-NAPOCA", 0, 0, "Cluj-Napoca"},
+			{"Ploiești", 44.4268, 26.1025, "Ploiești"}, // the name wins over coordinates
+			{"", 44.85, 26.05, "Ploiești"},             // nearest, about 10 km away
+			{"", 44.50, 26.20, "Bucharest"},            // nearest, about 11 km away
+			{"Timișoara", 46.5, 23.9, "Cluj-Napoca"},   // unknown name, by coordinates
+			{"", 45.7489, 21.2087, ""},                 // Timișoara, over 100 km from any station
+			{"Timișoara", 0, 0, ""},
+		}
+		for _, tt := range tests {
+			wd, err := p.temperature(context.Background(), tt.city, tt.lat, tt.long)
+			if err != nil {
+				t.Errorf("%s: %q at %v, %v: %s", file, tt.city, tt.lat, tt.long, err)
+				continue
+			}
+			if wd.LocationName != tt.want || wd.valid() != (tt.want != "") {
+				t.Errorf("%s: %q at %v, %v answered by %q, valid %t, want %q", file, tt.city, tt.lat, tt.long, wd.LocationName, wd.valid(), tt.want)
+			}
+		}
+	}
+}
+
+func TestFileProviderReading(t *testing.T) {
+	p, err := loadStations(writeFile(t, "stations.csv", stationCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := p.temperature(context.Background(), "Cluj-Napoca", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.Celsius != 4.25 || wd.Fahrenheit != 39.65 || wd.Kelvin != 277.4 || wd.NativeUnit != "c" {
+		t.Errorf("got %v°C, %v°F, %vK in %q, want 4.25°C, 39.65°F, 277.4K in c", wd.Celsius, wd.Fahrenheit, wd.Kelvin, wd.NativeUnit)
+	}
+	if wd.Latitude != 46.7712 || wd.Longitude != 23.6236 {
+		t.Errorf("reported %v, %v, want the station's 46.7712, 23.6236", wd.Latitude, wd.Longitude)
+	}
+}
+
+func TestLoadStationsRejectsBadFiles(t *testing.T) {
+	tests := map[string]string{
+		"missing.csv":      "city,lat,temp\nBucharest,44.4268,10\n",
+		"not-a-number.csv": "city,lat,long,temp\nBucharest,44.4268,26.1025,warm\n",
+		"empty.csv":        "",
+		"empty.json":       "[]",
+		"broken.json":      `[{"city": "Bucharest"`,
+	}
+	for name, content := range tests {
+		if _, err := loadStations(writeFile(t, name, content)); err == nil {
+			t.Errorf("%s loaded", name)
+		}
+	}
+}
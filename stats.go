@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// stats counts provider calls since the process started.
+var stats = newStatsRegistry()
+
+type providerStats struct {
+	calls     atomic.Int64
+	successes atomic.Int64
+	failures  atomic.Int64
+
+	lastSucceeded atomic.Bool // whether the most recent call succeeded, false until one has, for /readyz
+}
+
+type statsRegistry struct {
+	mu        sync.Mutex
+	providers map[string]*providerStats
+	cache     *weatherCache
+}
+
+func newStatsRegistry() *statsRegistry {
+	return &statsRegistry{providers: make(map[string]*providerStats)}
+}
+
+// provider returns the counters for the named provider, creating them on first use.
+func (s *statsRegistry) provider(name string) *providerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.providers[name]
+	if !ok {
+		ps = &providerStats{}
+		s.providers[name] = ps
+	}
+	return ps
+}
+
+type providerCounts struct {
+	Calls     int64 `json:"calls"`
+	Successes int64 `json:"successes"`
+	Failures  int64 `json:"failures"`
+}
+
+type cacheCounts struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+type statsSnapshot struct {
+	Providers map[string]providerCounts `json:"providers"`
+	Cache     *cacheCounts              `json:"cache,omitempty"`
+}
+
+// watchCache adds the cache's hit and miss counts to the stats.
+func (s *statsRegistry) watchCache(c *weatherCache) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = c
+}
+
+func (s *statsRegistry) snapshot() statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := statsSnapshot{Providers: make(map[string]providerCounts, len(s.providers))}
+	for name, ps := range s.providers {
+		snap.Providers[name] = providerCounts{
+			Calls:     ps.calls.Load(),
+			Successes: ps.successes.Load(),
+			Failures:  ps.failures.Load(),
+		}
+	}
+	if s.cache != nil {
+		snap.Cache = &cacheCounts{
+			Hits:     s.cache.hits.Load(),
+			Misses:   s.cache.misses.Load(),
+			HitRatio: s.cache.hitRatio(),
+		}
+	}
+	return snap
+}
+
+func (s *statsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(s.snapshot())
+}
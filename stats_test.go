@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// freshStats swaps in an empty stats registry for the test, so that counts from
+// other tests, or from earlier runs with -count, don't add up.
+func freshStats(t *testing.T) {
+	saved := stats
+	stats = newStatsRegistry()
+	t.Cleanup(func() { stats = saved })
+}
+
+func TestStatsCountCallsSuccessesAndFailures(t *testing.T) {
+	freshStats(t)
+	ok := &fakeProvider{id: "stats-ok", data: reading(10)}
+	failing := &fakeProvider{id: "stats-failing", err: errors.New("boom")}
+	clock := newFakeClock(time.Now())
+	for i := 0; i < 3; i++ {
+		ask(context.Background(), clock, ok, "Bucharest", 0, 0)
+	}
+	for i := 0; i < 2; i++ {
+		ask(context.Background(), clock, failing, "Bucharest", 0, 0)
+	}
+
+	rec := serve(stats, "/stats")
+	var snap statsSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("decoding %q: %s", rec.Body.String(), err)
+	}
+	if got, want := snap.Providers["stats-ok"], (providerCounts{Calls: 3, Successes: 3}); got != want {
+		t.Errorf("stats-ok: got %+v, want %+v", got, want)
+	}
+	if got, want := snap.Providers["stats-failing"], (providerCounts{Calls: 2, Failures: 2}); got != want {
+		t.Errorf("stats-failing: got %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsDontCountProvidersCutShortAsFailed(t *testing.T) {
+	freshStats(t)
+	p := &fakeProvider{id: "stats-cut-short", delay: time.Minute}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errEnoughReadings)
+	ask(ctx, realClock{}, p, "Bucharest", 0, 0)
+
+	ps := stats.provider("stats-cut-short")
+	if ps.calls.Load() != 1 || ps.failures.Load() != 0 {
+		t.Errorf("got %d calls and %d failures, want 1 call and no failures", ps.calls.Load(), ps.failures.Load())
+	}
+}
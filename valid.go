@@ -0,0 +1,27 @@
+package main
+
+import "fmt"
+
+// plausibleTemps bounds the readings, in °C, taken as real. The defaults are a
+// little past the coldest and hottest temperatures ever recorded, and can be
+// changed with -min-temp and -max-temp.
+var plausibleTemps = tempRange{min: -100, max: 70}
+
+type tempRange struct {
+	min float64
+	max float64
+}
+
+func (r tempRange) contains(c float64) bool {
+	return c >= r.min && c <= r.max
+}
+
+func (r tempRange) String() string {
+	return fmt.Sprintf("%g°C to %g°C", r.min, r.max)
+}
+
+// valid reports whether wd is a reading to use: its provider said it had one,
+// and its temperature is plausible.
+func (wd weatherData) valid() bool {
+	return wd.HasReading && plausibleTemps.contains(wd.Celsius)
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestValidReadings(t *testing.T) {
+	noReading := reading(10)
+	noReading.HasReading = false
+	noKelvin := reading(10)
+	noKelvin.Kelvin = 0 // validity no longer goes by the kelvin conversion
+
+	tests := []struct {
+		name string
+		wd   weatherData
+		want bool
+	}{
+		{"mild", reading(10), true},
+		{"freezing", reading(0), true},
+		{"the coldest allowed", reading(plausibleTemps.min), true},
+		{"the hottest allowed", reading(plausibleTemps.max), true},
+		{"just too cold", reading(plausibleTemps.min - 0.01), false},
+		{"just too hot", reading(plausibleTemps.max + 0.01), false},
+		{"absolute zero", reading(-KelvinShift), false},
+		{"just above absolute zero", reading(-KelvinShift + 0.01), false},
+		{"kelvin left unset", noKelvin, true},
+		{"no reading", noReading, false},
+		{"the zero value", weatherData{}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.wd.valid(); got != tt.want {
+			t.Errorf("%s: %.2f°C valid is %t, want %t", tt.name, tt.wd.Celsius, got, tt.want)
+		}
+	}
+}
+
+func TestValidReadingsWithWiderBounds(t *testing.T) {
+	saved := plausibleTemps
+	t.Cleanup(func() { plausibleTemps = saved })
+	plausibleTemps = tempRange{min: -KelvinShift, max: 70}
+
+	tests := []struct {
+		c    float64
+		want bool
+	}{
+		{-KelvinShift, true},
+		{-KelvinShift + 0.01, true},
+		{-KelvinShift - 0.01, false},
+		{-150, true},
+	}
+	for _, tt := range tests {
+		if got := reading(tt.c).valid(); got != tt.want {
+			t.Errorf("%.2f°C valid is %t within %s, want %t", tt.c, got, plausibleTemps, tt.want)
+		}
+	}
+}
+
+func TestWeatherSkipsAReadingNearAbsoluteZero(t *testing.T) {
+	sc := testRoutes(
+		&fakeProvider{id: "zero-kelvin", data: reading(-KelvinShift + 0.01)},
+		&fakeProvider{id: "zero-mild-a", data: reading(10)},
+		&fakeProvider{id: "zero-mild-b", data: reading(12)},
+	)
+	resp := decodeWeather(t, serve(newRouter(sc), "/weather/?lat=44.4268&long=26.1025"))
+	if resp.Temp != "11.00°C" || resp.ProvidersSucceeded != 2 {
+		t.Errorf("got %s from %d providers, want 11.00°C from the 2 mild ones", resp.Temp, resp.ProvidersSucceeded)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// parseCityList splits a comma-separated list of cities, e.g. "Bucharest,Urbandale".
+func parseCityList(list string) []string {
+	var cities []string
+	for _, city := range strings.Split(list, ",") {
+		if city = strings.TrimSpace(city); city != "" {
+			cities = append(cities, city)
+		}
+	}
+	return cities
+}
+
+// warmCache fetches the weather for every city into the cache straight away and
+// again every interval, so their requests never wait for providers. Each
+// provider call starts up to jitter late so they don't all land on the
+// providers at once. warmed is called once the first round is done. It runs
+// until the process exits.
+func warmCache(cities []string, providers *atomic.Pointer[multiWeatherProvider], opts aggregateOptions, cache *weatherCache, timeout time.Duration, interval time.Duration, jitter time.Duration, warmed func()) {
+	warmCities(cities, *providers.Load(), opts, cache, timeout, jitter)
+	warmed()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		warmCities(cities, *providers.Load(), opts, cache, timeout, jitter)
+	}
+}
+
+func warmCities(cities []string, mw multiWeatherProvider, opts aggregateOptions, cache *weatherCache, timeout time.Duration, jitter time.Duration) {
+	for _, city := range cities {
+		// the providers get their whole timeout however late they start
+		ctx, cancel := context.WithTimeout(context.Background(), timeout+jitter)
+		ctx = withStartJitter(ctx, jitter)
+		agg, err := mw.temperature(ctx, city, 0, 0, opts)
+		cancel()
+		if err != nil {
+			log.Printf("warming %s failed: %s", city, err)
+			continue
+		}
+
+		// same rule as the handler, an incomplete average isn't cached
+		if len(agg.TimedOut) == 0 {
+			cache.set(cacheKey(city, 0, 0), agg)
+		}
+	}
+}
+
+type startJitterKey struct{}
+
+// withStartJitter returns a context under which ask waits a random time up to
+// jitter before calling each provider.
+func withStartJitter(ctx context.Context, jitter time.Duration) context.Context {
+	if jitter <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, startJitterKey{}, jitter)
+}
+
+// waitStartJitter waits out the start jitter ctx asks for, if any, returning
+// early with ctx's error when it is done first.
+func waitStartJitter(ctx context.Context) error {
+	jitter, _ := ctx.Value(startJitterKey{}).(time.Duration)
+	if jitter <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(time.Duration(rand.Int63n(int64(jitter))))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseCityList(t *testing.T) {
+	got := parseCityList(" Bucharest, ,Urbandale,")
+	if strings.Join(got, "|") != "Bucharest|Urbandale" {
+		t.Errorf("got %q, want Bucharest and Urbandale", got)
+	}
+	if got := parseCityList(""); got != nil {
+		t.Errorf("got %q from an empty list", got)
+	}
+}
+
+func TestWarmCachePopulatesTheCache(t *testing.T) {
+	p := &fakeProvider{id: "warm", answer: func(ctx context.Context, city string, lat float64, long float64) (weatherData, error) {
+		if city == "Nowhere" {
+			return weatherData{}, errors.New("no such city")
+		}
+		return reading(float64(len(city))), nil
+	}}
+	mw := multiWeatherProvider{p}
+	var providers atomic.Pointer[multiWeatherProvider]
+	providers.Store(&mw)
+	cache := newWeatherCache(newFakeClock(time.Now()), time.Hour)
+
+	warmed := make(chan struct{})
+	go warmCache([]string{"Bucharest", "Nowhere", "Urbandale"}, &providers, aggregateOptions{}, cache, time.Second, time.Hour, 0, func() { close(warmed) })
+	select {
+	case <-warmed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first round never finished")
+	}
+
+	for _, city := range []string{"Bucharest", "Urbandale"} {
+		e, ok := cache.get(cacheKey(city, 0, 0))
+		if !ok {
+			t.Errorf("%s isn't cached after the warm-up", city)
+			continue
+		}
+		if want := float64(len(city)); e.data.Celsius != want {
+			t.Errorf("%s cached as %.2f°C, want %.2f°C", city, e.data.Celsius, want)
+		}
+	}
+	if _, ok := cache.get(cacheKey("Nowhere", 0, 0)); ok {
+		t.Error("a city that failed to warm is cached")
+	}
+}
+
+func TestStartJitterStaggersCalls(t *testing.T) {
+	const jitter = 40 * time.Millisecond
+	ctx := withStartJitter(context.Background(), jitter)
+
+	var shortest, longest time.Duration
+	for i := 0; i < 20; i++ {
+		began := time.Now()
+		if err := waitStartJitter(ctx); err != nil {
+			t.Fatal(err)
+		}
+		waited := time.Since(began)
+		if i == 0 || waited < shortest {
+			shortest = waited
+		}
+		longest = max(longest, waited)
+	}
+	if longest > jitter+50*time.Millisecond {
+		t.Errorf("waited up to %s, want no more than about %s", longest, jitter)
+	}
+	if longest-shortest < jitter/4 {
+		t.Errorf("waits ranged from %s to %s, want them spread over %s", shortest, longest, jitter)
+	}
+
+	// no jitter, no wait, and a done context stops the wait
+	if err := waitStartJitter(withStartJitter(context.Background(), 0)); err != nil {
+		t.Error(err)
+	}
+	done, cancel := context.WithCancel(withStartJitter(context.Background(), time.Hour))
+	cancel()
+	if err := waitStartJitter(done); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v from a cancelled context, want context.Canceled", err)
+	}
+}